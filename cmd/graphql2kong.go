@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kong/go-apiops/deckformat"
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/graphql2kong"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/spf13/cobra"
+)
+
+// Executes the CLI command "graphql2kong"
+func executeGraphql2Kong(cmd *cobra.Command, _ []string) error {
+	verbosity, _ := cmd.Flags().GetInt("verbose")
+	logbasics.Initialize(log.LstdFlags, verbosity)
+
+	inputFilename, err := cmd.Flags().GetString("schema")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'schema'; %w", err)
+	}
+
+	sidecarFilename, err := cmd.Flags().GetString("sidecar")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'sidecar'; %w", err)
+	}
+
+	outputFilename, err := cmd.Flags().GetString("output-file")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'output-file'; %w", err)
+	}
+
+	docName, err := cmd.Flags().GetString("uuid-base")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'uuid-base'; %w", err)
+	}
+
+	var entityTags []string
+	{
+		tags, err := cmd.Flags().GetStringSlice("select-tag")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'select-tag'; %w", err)
+		}
+		entityTags = tags
+		if len(entityTags) == 0 {
+			entityTags = nil
+		}
+	}
+
+	var outputFormat string
+	{
+		outputFormat, err = cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'format'; %w", err)
+		}
+		outputFormat = strings.ToUpper(outputFormat)
+	}
+
+	var noID bool
+	{
+		noID, err = cmd.Flags().GetBool("no-id")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'no-id'; %w", err)
+		}
+	}
+
+	trackInfo := deckformat.HistoryNewEntry("graphql2kong")
+	trackInfo["input"] = inputFilename
+	trackInfo["sidecar"] = sidecarFilename
+	trackInfo["output"] = outputFilename
+	trackInfo["uuid-base"] = docName
+
+	// do the work: read/convert/write
+	content, err := filebasics.ReadFile(inputFilename)
+	if err != nil {
+		return err
+	}
+	var sidecar []byte
+	if sidecarFilename != "" {
+		if sidecar, err = filebasics.ReadFile(sidecarFilename); err != nil {
+			return err
+		}
+	}
+
+	options := graphql2kong.G2kOptions{
+		Tags:       entityTags,
+		DocName:    docName,
+		SkipID:     noID,
+		SchemaName: inputFilename,
+		Sidecar:    sidecar,
+	}
+	result, err := graphql2kong.Convert(content, options)
+	if err != nil {
+		return fmt.Errorf("failed converting GraphQL schema '%s'; %w", inputFilename, err)
+	}
+	deckformat.HistoryAppend(result, trackInfo)
+	return filebasics.WriteSerializedFile(outputFilename, result, filebasics.OutputFormat(outputFormat))
+}
+
+//
+//
+// Define the CLI data for the graphql2kong command
+//
+//
+
+var graphql2kongCmd = &cobra.Command{
+	Use:   "graphql2kong",
+	Short: "Convert GraphQL schemas to Kong's decK format",
+	Long: `Convert GraphQL SDL schemas to Kong's decK format.
+
+Generates a service for the GraphQL backend, with a '/graphql' route. The
+directives for the conversion are kept in a sidecar file (JSON or YAML):
+
+  - servers: the GraphQL endpoint(s) of the backend, eg. [{ url: https://host/graphql }]
+  - x-kong-name: Custom entity naming
+  - x-kong-tags: Tags for all entities
+  - x-kong-service-defaults: Service entity defaults
+  - x-kong-route-defaults: Route entity defaults
+  - x-kong-upstream-defaults: Upstream entity defaults
+  - x-kong-plugin-*: Additional plugins, on the service
+  - x-kong-persisted-queries: Queries exposed as REST endpoints by the degraphql
+    plugin, eg. [{ uri: /products/:id, query: "...", methods: [GET] }]
+
+The '@cost(weight|complexity, multipliers)' and '@listSize(assumedSize,
+slicingArguments)' directives on fields become cost decorations for the
+graphql-rate-limiting-advanced plugin.`,
+	RunE: executeGraphql2Kong,
+	Args: cobra.NoArgs,
+}
+
+func init() {
+	rootCmd.AddCommand(graphql2kongCmd)
+	graphql2kongCmd.Flags().StringP("schema", "s", "-", "GraphQL SDL schema file to process. Use - to read from stdin")
+	graphql2kongCmd.Flags().StringP("sidecar", "", "", "sidecar file (JSON or YAML) with the x-kong-... directives")
+	graphql2kongCmd.Flags().StringP("output-file", "o", "-", "output file to write. Use - to write to stdout")
+	graphql2kongCmd.Flags().StringP("format", "", string(filebasics.OutputFormatYaml), "output format: "+
+		string(filebasics.OutputFormatJSON)+" or "+string(filebasics.OutputFormatYaml))
+	graphql2kongCmd.Flags().StringP("uuid-base", "", "",
+		`the unique base-string for uuid-v5 generation of entity id's (if omitted
+will use the "x-kong-name" directive from the sidecar)`)
+	graphql2kongCmd.Flags().StringSlice("select-tag", nil,
+		`select tags to apply to all entities (if omitted will use the "x-kong-tags"
+directive from the sidecar)`)
+	graphql2kongCmd.Flags().BoolP("no-id", "", false, "do not generate UUIDs for entities")
+}
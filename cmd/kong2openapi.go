@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/kong2openapi"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/spf13/cobra"
+)
+
+// Executes the CLI command "kong2openapi"
+func executeKong2Openapi(cmd *cobra.Command, _ []string) error {
+	verbosity, _ := cmd.Flags().GetInt("verbose")
+	logbasics.Initialize(log.LstdFlags, verbosity)
+
+	inputFilename, err := cmd.Flags().GetString("state")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'state'; %w", err)
+	}
+
+	outputFilename, err := cmd.Flags().GetString("output-file")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'output-file'; %w", err)
+	}
+
+	var outputFormat string
+	{
+		outputFormat, err = cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'format'; %w", err)
+		}
+		outputFormat = strings.ToUpper(outputFormat)
+	}
+
+	var title string
+	{
+		title, err = cmd.Flags().GetString("title")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'title'; %w", err)
+		}
+	}
+
+	var specVersion string
+	{
+		specVersion, err = cmd.Flags().GetString("spec-version")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'spec-version'; %w", err)
+		}
+	}
+
+	options := kong2openapi.K2OOptions{
+		Title:   title,
+		Version: specVersion,
+	}
+
+	// do the work: read/convert/write
+	data, err := filebasics.DeserializeFile(inputFilename)
+	if err != nil {
+		return fmt.Errorf("failed to read input file '%s'; %w", inputFilename, err)
+	}
+	result, err := kong2openapi.Convert(data, options)
+	if err != nil {
+		return fmt.Errorf("failed converting decK file '%s'; %w", inputFilename, err)
+	}
+	return filebasics.WriteSerializedFile(outputFilename, result, filebasics.OutputFormat(outputFormat))
+}
+
+//
+//
+// Define the CLI data for the kong2openapi command
+//
+//
+
+var kong2openapiCmd = &cobra.Command{
+	Use:   "kong2openapi",
+	Short: "Convert a decK file into an OpenAPI spec skeleton",
+	Long: `Convert a decK file into an OpenAPI 3.0 spec skeleton.
+
+Services become 'servers' (the targets of their upstream if any), routes become 'paths'
+and operations, with the regex captures of their paths as '{params}'. Plain paths are
+prefix matches, so they are also kept in 'x-kong-route-defaults'. The schemas of
+'request-validator' plugins become parameters and request bodies. All other plugins,
+and entity fields that cannot be represented in the spec, are kept as 'x-kong-*'
+directives. Converting the result back with 'openapi2kong' results in an equivalent
+configuration.
+
+The first service is used on the document level, the others on the path or operation
+level. Routes using expressions or complex regexes, and global plugins, are skipped.`,
+	RunE: executeKong2Openapi,
+	Args: cobra.NoArgs,
+}
+
+func init() {
+	rootCmd.AddCommand(kong2openapiCmd)
+	kong2openapiCmd.Flags().StringP("state", "s", "-", "decK file to process. Use - to read from stdin")
+	kong2openapiCmd.Flags().StringP("output-file", "o", "-", "output file to write. Use - to write to stdout")
+	kong2openapiCmd.Flags().StringP("format", "", string(filebasics.OutputFormatYaml), "output format: "+
+		string(filebasics.OutputFormatJSON)+" or "+string(filebasics.OutputFormatYaml))
+	kong2openapiCmd.Flags().StringP("title", "", "", "the 'info.title' of the spec, defaults to the name "+
+		"of the first service")
+	kong2openapiCmd.Flags().StringP("spec-version", "", "", "the 'info.version' of the spec (default \"1.0.0\")")
+}
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/kong/go-apiops/deckformat"
@@ -10,6 +13,8 @@ import (
 	"github.com/kong/go-apiops/logbasics"
 	"github.com/kong/go-apiops/openapi2kong"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.yaml.in/yaml/v4"
 )
 
 // Executes the CLI command "openapi2kong"
@@ -17,9 +22,19 @@ func executeOpenapi2Kong(cmd *cobra.Command, _ []string) error {
 	verbosity, _ := cmd.Flags().GetInt("verbose")
 	logbasics.Initialize(log.LstdFlags, verbosity)
 
-	inputFilename, err := cmd.Flags().GetString("spec")
-	if err != nil {
-		return fmt.Errorf("failed getting cli argument 'spec'; %w", err)
+	var (
+		inputFilenames []string
+		batch          bool // converting multiple specs into a single file
+	)
+	{
+		specs, err := cmd.Flags().GetStringArray("spec")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'spec'; %w", err)
+		}
+		inputFilenames, batch, err = getSpecFilenames(specs)
+		if err != nil {
+			return err
+		}
 	}
 
 	outputFilename, err := cmd.Flags().GetString("output-file")
@@ -69,6 +84,14 @@ func executeOpenapi2Kong(cmd *cobra.Command, _ []string) error {
 		}
 	}
 
+	var strictSecurity bool
+	{
+		strictSecurity, err = cmd.Flags().GetBool("strict-security")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'strict-security'; %w", err)
+		}
+	}
+
 	var insoCompatibility bool
 	{
 		insoCompatibility, err = cmd.Flags().GetBool("inso-compatible")
@@ -93,34 +116,333 @@ func executeOpenapi2Kong(cmd *cobra.Command, _ []string) error {
 		}
 	}
 
+	var failOnRouteConflicts bool
+	{
+		failOnRouteConflicts, err = cmd.Flags().GetBool("fail-on-route-conflicts")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'fail-on-route-conflicts'; %w", err)
+		}
+	}
+
+	var validateResponses bool
+	{
+		validateResponses, err = cmd.Flags().GetBool("validate-responses")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'validate-responses'; %w", err)
+		}
+	}
+
+	var callbacks bool
+	{
+		callbacks, err = cmd.Flags().GetBool("callbacks")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'callbacks'; %w", err)
+		}
+	}
+
+	var serviceGrouping string
+	{
+		serviceGrouping, err = cmd.Flags().GetString("service-grouping")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'service-grouping'; %w", err)
+		}
+	}
+
+	var mock string
+	{
+		mock, err = cmd.Flags().GetString("mock")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'mock'; %w", err)
+		}
+	}
+
+	var consumerGroups bool
+	{
+		consumerGroups, err = cmd.Flags().GetBool("consumer-groups")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'consumer-groups'; %w", err)
+		}
+	}
+
+	var documentPath string
+	{
+		documentPath, err = cmd.Flags().GetString("document-path")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'document-path'; %w", err)
+		}
+	}
+
+	var writeDocument bool
+	{
+		writeDocument, err = cmd.Flags().GetBool("write-document")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'write-document'; %w", err)
+		}
+		if writeDocument && documentPath == "" {
+			return fmt.Errorf("cannot use 'write-document' without a 'document-path'")
+		}
+	}
+
+	var idStrategy string
+	{
+		idStrategy, err = cmd.Flags().GetString("id-strategy")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'id-strategy'; %w", err)
+		}
+	}
+
+	var idPins map[string]string
+	{
+		idPinsFilename, err := cmd.Flags().GetString("id-pins")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'id-pins'; %w", err)
+		}
+		if idPinsFilename != "" {
+			idPinsContent, err := filebasics.ReadFile(idPinsFilename)
+			if err != nil {
+				return err
+			}
+			idPins, err = openapi2kong.ParseIDPins(idPinsContent)
+			if err != nil {
+				return fmt.Errorf("failed to parse the id pins file '%s'; %w", idPinsFilename, err)
+			}
+		}
+	}
+
+	var inferPolicies bool
+	{
+		inferPolicies, err = cmd.Flags().GetBool("infer-policies")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'infer-policies'; %w", err)
+		}
+	}
+
+	var routerFlavor string
+	{
+		routerFlavor, err = cmd.Flags().GetString("router-flavor")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'router-flavor'; %w", err)
+		}
+	}
+
+	var provenanceFilename string
+	{
+		provenanceFilename, err = cmd.Flags().GetString("provenance-file")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'provenance-file'; %w", err)
+		}
+	}
+
+	var overlays [][]byte
+	{
+		overlayFilenames, err := cmd.Flags().GetStringArray("overlay")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'overlay'; %w", err)
+		}
+		for _, filename := range overlayFilenames {
+			overlayContent, err := filebasics.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			overlays = append(overlays, overlayContent)
+		}
+	}
+
+	basePath, refMirrors, err := getReferenceFlags(cmd, inputFilenames[0])
+	if err != nil {
+		return err
+	}
+	if batch {
+		if cmd.Flags().Changed("base-path") {
+			return fmt.Errorf("cannot use 'base-path' when converting multiple specs, references are " +
+				"resolved from the directory of each spec")
+		}
+		if provenanceFilename != "" {
+			return fmt.Errorf("cannot use 'provenance-file' when converting multiple specs")
+		}
+		basePath = "." // the spec filenames are relative to the working directory
+	}
+
 	options := openapi2kong.O2kOptions{
 		Tags:                 entityTags,
 		DocName:              docName,
 		OIDC:                 generateSecurity,
 		IgnoreSecurityErrors: ignoreSecurityErrors,
+		StrictSecurity:       strictSecurity,
 		InsoCompat:           insoCompatibility,
 		IgnoreCircularRefs:   ignoreCircularRefs,
 		ReuseServices:        reuseServices,
+		FailOnRouteConflicts: failOnRouteConflicts,
+		ValidateResponses:    validateResponses,
+		Callbacks:            callbacks,
+		ServiceGrouping:      serviceGrouping,
+		Mock:                 mock,
+		ConsumerGroups:       consumerGroups,
+		InferPolicies:        inferPolicies,
+		DocumentPath:         documentPath,
+		IDStrategy:           idStrategy,
+		IDPins:               idPins,
+		RouterFlavor:         routerFlavor,
+		Overlays:             overlays,
+		BasePath:             basePath,
+		RemoteRefMirrors:     refMirrors,
 	}
 
 	trackInfo := deckformat.HistoryNewEntry("openapi2kong")
-	trackInfo["input"] = inputFilename
+	trackInfo["input"] = strings.Join(inputFilenames, ",")
 	trackInfo["output"] = outputFilename
 	trackInfo["uuid-base"] = docName
 
 	// do the work: read/convert/write
+	if batch {
+		specs := make(map[string][]byte, len(inputFilenames))
+		for _, filename := range inputFilenames {
+			if specs[filename], err = filebasics.ReadFile(filename); err != nil {
+				return err
+			}
+		}
+		result, err := openapi2kong.ConvertAll(specs, options)
+		if err != nil {
+			return fmt.Errorf("failed converting OpenAPI specs; %w", err)
+		}
+		if writeDocument {
+			for _, filename := range inputFilenames {
+				err = writeSanitizedSpec(specs[filename], filepath.Join(documentPath, filepath.Base(filename)), options)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		deckformat.HistoryAppend(result, trackInfo)
+		return filebasics.WriteSerializedFile(outputFilename, result, filebasics.OutputFormat(outputFormat))
+	}
+
+	inputFilename := inputFilenames[0]
 	content, err := filebasics.ReadFile(inputFilename)
 	if err != nil {
 		return err
 	}
-	result, err := openapi2kong.Convert(content, options)
+	result, provenance, err := openapi2kong.ConvertWithProvenance(content, options)
 	if err != nil {
 		return fmt.Errorf("failed converting OpenAPI spec '%s'; %w", inputFilename, err)
 	}
+	if provenanceFilename != "" {
+		provenanceJSON, err := json.MarshalIndent(provenance, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to serialize the provenance; %w", err)
+		}
+		if err = filebasics.WriteFile(provenanceFilename, append(provenanceJSON, '\n')); err != nil {
+			return err
+		}
+	}
+	if writeDocument {
+		if err = writeSanitizedSpec(content, documentPath, options); err != nil {
+			return err
+		}
+	}
 	deckformat.HistoryAppend(result, trackInfo)
 	return filebasics.WriteSerializedFile(outputFilename, result, filebasics.OutputFormat(outputFormat))
 }
 
+// writeSanitizedSpec writes the spec, as published in the developer portal, to the document path.
+func writeSanitizedSpec(content []byte, documentPath string, options openapi2kong.O2kOptions) error {
+	options.DocumentPath = documentPath
+	document, err := openapi2kong.SanitizeSpec(content, options)
+	if err != nil {
+		return fmt.Errorf("failed sanitizing OpenAPI spec for '%s'; %w", documentPath, err)
+	}
+	return filebasics.WriteFile(documentPath, document)
+}
+
+// isSpecFile returns true if the file is an OpenAPI or Swagger document.
+func isSpecFile(filename string) bool {
+	content, err := filebasics.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return false
+	}
+	return doc["openapi"] != nil || doc["swagger"] != nil
+}
+
+// getSpecFilenames returns the spec files to convert. Directories are replaced by the OpenAPI and Swagger
+// documents in them (.json, .yaml, or .yml files, not recursive). Returns true if multiple specs are to be
+// converted into a single file, which is the case for more than one file, or a directory.
+func getSpecFilenames(names []string) ([]string, bool, error) {
+	filenames := make([]string, 0, len(names))
+	batch := len(names) > 1
+	for _, name := range names {
+		if name == "-" {
+			if len(names) > 1 {
+				return nil, false, fmt.Errorf("cannot read from stdin when converting multiple specs")
+			}
+			filenames = append(filenames, name)
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil || !info.IsDir() {
+			filenames = append(filenames, name) // errors are reported when reading the file
+			continue
+		}
+
+		batch = true
+		entries, err := os.ReadDir(name)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read directory '%s'; %w", name, err)
+		}
+		for _, entry := range entries {
+			filename := filepath.Join(name, entry.Name())
+			switch strings.ToLower(filepath.Ext(filename)) {
+			case ".json", ".yaml", ".yml":
+				if !entry.IsDir() && isSpecFile(filename) {
+					filenames = append(filenames, filename)
+				} else {
+					logbasics.Info("skipping file, not an OpenAPI spec", "file", filename)
+				}
+			}
+		}
+	}
+	if len(filenames) == 0 {
+		return nil, false, fmt.Errorf("no OpenAPI specs found in: %s", strings.Join(names, ", "))
+	}
+	return filenames, batch, nil
+}
+
+// getReferenceFlags returns the base path and remote mirrors for resolving references to other files.
+// The base path defaults to the directory of the input file.
+func getReferenceFlags(cmd *cobra.Command, inputFilename string) (string, map[string]string, error) {
+	basePath, err := cmd.Flags().GetString("base-path")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed getting cli argument 'base-path'; %w", err)
+	}
+	if basePath == "" && inputFilename != "-" {
+		basePath = filepath.Dir(inputFilename)
+	}
+
+	refMirrors, err := cmd.Flags().GetStringToString("ref-mirror")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed getting cli argument 'ref-mirror'; %w", err)
+	}
+	if len(refMirrors) == 0 {
+		refMirrors = nil
+	}
+
+	return basePath, refMirrors, nil
+}
+
+// addReferenceFlags adds the flags for resolving references to other files.
+func addReferenceFlags(flags *pflag.FlagSet) {
+	flags.StringP("base-path", "", "",
+		`directory to resolve relative file references from (if omitted will use the
+directory of the spec file, or only resolve local references when reading from stdin)`)
+	flags.StringToString("ref-mirror", nil,
+		`local mirror for remote references, in the form 'url-prefix=local-path' (relative
+paths are resolved from the base-path). Remote references are never fetched, so
+references without a mirror will fail`)
+}
+
 //
 //
 // Define the CLI data for the openapi2kong command
@@ -130,7 +452,12 @@ func executeOpenapi2Kong(cmd *cobra.Command, _ []string) error {
 var openapi2kongCmd = &cobra.Command{
 	Use:   "openapi2kong",
 	Short: "Convert OpenAPI files to Kong's decK format",
-	Long: `Convert OpenAPI files to Kong's decK format.
+	Long: `Convert OpenAPI files to Kong's decK format. Swagger 2.0 files are
+upgraded to OpenAPI 3 before converting.
+
+Multiple specs (by repeating '--spec', or by passing a directory) are converted
+concurrently into a single file. Each spec generates its own entity names and
+UUIDs, the conversion fails if specs generate entities with the same name.
 
 The example file has extensive annotations explaining the conversion
 process, as well as all supported custom annotations (x-kong-... directives).
@@ -141,7 +468,8 @@ See: https://github.com/Kong/go-apiops/blob/main/docs/learnservice_oas.yaml`,
 
 func init() {
 	rootCmd.AddCommand(openapi2kongCmd)
-	openapi2kongCmd.Flags().StringP("spec", "s", "-", "OpenAPI spec file to process. Use - to read from stdin")
+	openapi2kongCmd.Flags().StringArrayP("spec", "s", []string{"-"}, "OpenAPI spec file, or directory of spec "+
+		"files, to process. Use - to read from stdin. Multiple specs are converted into a single file")
 	openapi2kongCmd.Flags().StringP("output-file", "o", "-", "output file to write. Use - to write to stdout")
 	openapi2kongCmd.Flags().StringP("format", "", string(filebasics.OutputFormatYaml), "output format: "+
 		string(filebasics.OutputFormatJSON)+" or "+string(filebasics.OutputFormatYaml))
@@ -151,11 +479,46 @@ will use the root-level "x-kong-name" directive, or fall back to 'info.title')`)
 	openapi2kongCmd.Flags().StringSlice("select-tag", nil,
 		`select tags to apply to all entities (if omitted will use the "x-kong-tags"
 directive from the file)`)
-	openapi2kongCmd.Flags().BoolP("generate-security", "", false, "generate OpenIDConnect plugins from the "+
-		"security directives")
+	openapi2kongCmd.Flags().BoolP("generate-security", "", false, "generate security plugins (openid-connect, "+
+		"key-auth, basic-auth, jwt, mtls-auth) from the security directives")
 	openapi2kongCmd.Flags().BoolP("ignore-security-errors", "", false, "ignore errors for unsupported security schemes")
+	openapi2kongCmd.Flags().BoolP("strict-security", "", false, "fail on operations with unsupported "+
+		"security-requirements, instead of skipping them")
 	openapi2kongCmd.Flags().BoolP("inso-compatible", "", false, "generate the config in an Inso compatible way")
 	openapi2kongCmd.Flags().BoolP("ignore-circular-refs", "", false, "ignore circular references in the spec")
 	openapi2kongCmd.Flags().BoolP("reuse-services", "", false, "reuse services when multiple paths have identical "+
 		"server configurations and no path-level plugins")
+	openapi2kongCmd.Flags().BoolP("fail-on-route-conflicts", "", false, "fail if generated routes shadow "+
+		"each other, or are ambiguous (traditional router flavor only)")
+	openapi2kongCmd.Flags().BoolP("validate-responses", "", false, "generate oas-validation plugins "+
+		"validating the responses against the response schemas")
+	openapi2kongCmd.Flags().BoolP("callbacks", "", false, "generate routes on a dedicated service "+
+		"for receiving the webhooks and callbacks defined in the spec")
+	openapi2kongCmd.Flags().StringP("service-grouping", "", openapi2kong.ServiceGroupingDocument,
+		"the services to create, per: "+openapi2kong.ServiceGroupingDocument+", "+openapi2kong.ServiceGroupingPath+
+			", "+openapi2kong.ServiceGroupingTag+" (OAS tag), or "+openapi2kong.ServiceGroupingExtension+
+			" (x-kong-service-group)")
+	openapi2kongCmd.Flags().StringP("mock", "", "", "generate mock routes serving the response examples, "+
+		"using plugin: "+openapi2kong.MockRequestTermination+" or "+openapi2kong.MockMocking)
+	openapi2kongCmd.Flags().BoolP("consumer-groups", "", false, "generate a consumer group per required scope, "+
+		"and acl plugins allowing the groups of each operation")
+	openapi2kongCmd.Flags().StringP("document-path", "", "", "the path of the spec in the developer portal, "+
+		"generates document_objects referencing it on every service (with multiple specs the directory)")
+	openapi2kongCmd.Flags().BoolP("write-document", "", false, "write the spec, without the x-kong extensions, "+
+		"to the document-path for publishing it in the developer portal (with multiple specs by their filenames)")
+	openapi2kongCmd.Flags().StringP("id-strategy", "", openapi2kong.IDStrategyName,
+		"what the generated ids are based on: "+openapi2kong.IDStrategyName+" (entity names), "+
+			openapi2kong.IDStrategyOperationID+" (operationId), or "+openapi2kong.IDStrategyExtension+" (x-kong-id)")
+	openapi2kongCmd.Flags().StringP("id-pins", "", "", "file mapping previously generated ids to the entities "+
+		"that should keep them, as '<entity-type>:<name>', to keep ids stable when refactoring the spec")
+	openapi2kongCmd.Flags().BoolP("infer-policies", "", false, "generate rate-limiting, proxy-cache, and "+
+		"response-transformer plugins from rate limit extensions, Cache-Control headers, and deprecations")
+	openapi2kongCmd.Flags().StringP("router-flavor", "", openapi2kong.RouterFlavorTraditional,
+		"the Kong router flavor to generate routes for: "+openapi2kong.RouterFlavorTraditional+
+			" or "+openapi2kong.RouterFlavorExpressions)
+	openapi2kongCmd.Flags().StringArrayP("overlay", "", []string{}, "OpenAPI Overlay file to apply to the spec "+
+		"before converting (can be specified more than once, applied in order)")
+	openapi2kongCmd.Flags().StringP("provenance-file", "", "", "write a JSON file mapping the generated entities "+
+		"(by id, or name) to the OAS objects and x-kong extensions they were generated from")
+	addReferenceFlags(openapi2kongCmd.Flags())
 }
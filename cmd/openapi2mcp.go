@@ -98,6 +98,19 @@ func executeOpenapi2Mcp(cmd *cobra.Command, _ []string) error {
 		}
 	}
 
+	var schemaBudget int
+	{
+		schemaBudget, err = cmd.Flags().GetInt("schema-budget")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'schema-budget'; %w", err)
+		}
+	}
+
+	basePath, refMirrors, err := getReferenceFlags(cmd, inputFilename)
+	if err != nil {
+		return err
+	}
+
 	options := openapi2mcp.O2MOptions{
 		Tags:                 entityTags,
 		DocName:              docName,
@@ -106,6 +119,9 @@ func executeOpenapi2Mcp(cmd *cobra.Command, _ []string) error {
 		IncludeDirectRoute:   includeDirectRoute,
 		SkipID:               noID,
 		IgnoreSecurityErrors: ignoreSecurityErrors,
+		SchemaBudget:         schemaBudget,
+		BasePath:             basePath,
+		RemoteRefMirrors:     refMirrors,
 	}
 
 	trackInfo := deckformat.HistoryNewEntry("openapi2mcp")
@@ -198,4 +214,7 @@ directive from the file)`)
 		`do not generate UUIDs for entities`)
 	openapi2mcpCmd.Flags().BoolP("ignore-security-errors", "", false,
 		`ignore errors for unsupported security schemes or missing x-kong-mcp-acl extensions`)
+	openapi2mcpCmd.Flags().IntP("schema-budget", "", openapi2mcp.DefaultSchemaBudget,
+		`maximum number of schema objects per tool parameter or request body schema, deeper ones are pruned`)
+	addReferenceFlags(openapi2mcpCmd.Flags())
 }
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/overlay"
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v4"
+)
+
+// Executes the CLI command "overlay"
+func executeOverlay(cmd *cobra.Command, args []string) error {
+	verbosity, _ := cmd.Flags().GetInt("verbose")
+	logbasics.Initialize(log.LstdFlags, verbosity)
+
+	inputFilename, err := cmd.Flags().GetString("spec")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'spec'; %w", err)
+	}
+
+	outputFilename, err := cmd.Flags().GetString("output-file")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'output-file'; %w", err)
+	}
+
+	var outputFormat string
+	{
+		outputFormat, err = cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'format'; %w", err)
+		}
+		outputFormat = strings.ToUpper(outputFormat)
+	}
+
+	overlays := make([]*overlay.Overlay, 0)
+	for _, filename := range args {
+		o, err := overlay.ParseFile(filename)
+		if err != nil {
+			return fmt.Errorf("failed to parse '%s': %w", filename, err)
+		}
+		overlays = append(overlays, o)
+	}
+
+	// do the work; read/apply/write
+	content, err := filebasics.ReadFile(inputFilename)
+	if err != nil {
+		return err
+	}
+	content, err = overlay.ApplyToContent(content, overlays...)
+	if err != nil {
+		return fmt.Errorf("failed to apply the overlays to '%s'; %w", inputFilename, err)
+	}
+
+	if strings.EqualFold(outputFormat, string(filebasics.OutputFormatYaml)) {
+		// write the YAML as is, to retain the order of the document
+		return filebasics.WriteFile(outputFilename, content)
+	}
+	var data map[string]interface{}
+	if err = yaml.Unmarshal(content, &data); err != nil {
+		return fmt.Errorf("failed to parse the result; %w", err)
+	}
+	return filebasics.WriteSerializedFile(outputFilename, data, filebasics.OutputFormat(outputFormat))
+}
+
+//
+//
+// Define the CLI data for the overlay command
+//
+//
+
+var overlayCmd = &cobra.Command{
+	Use:   "overlay [flags] overlay-files...",
+	Short: "Applies OpenAPI Overlays to an OpenAPI spec",
+	Long: `Applies OpenAPI Overlays (version 1.0) to an OpenAPI spec.
+
+The overlay files are applied in order, and each has a list of actions. Each action
+selects nodes in the spec using the JSONpath query in 'target', and then either
+removes them ('remove: true'), or merges the 'update' value into them. Objects are
+merged recursively, and arrays get the update appended.
+
+Example overlay, adding a plugin to all operations:
+
+  overlay: 1.0.0
+  info:
+    title: Kong settings
+    version: 1.0.0
+  actions:
+  - target: $.paths.*['get','post','put','patch','delete']
+    update:
+      x-kong-plugin-key-auth:
+        config:
+          key_names: [apikey]
+
+The 'openapi2kong' command can apply overlays directly, using '--overlay'.`,
+	RunE: executeOverlay,
+	Args: cobra.MinimumNArgs(1),
+}
+
+func init() {
+	rootCmd.AddCommand(overlayCmd)
+	overlayCmd.Flags().StringP("spec", "s", "-", "OpenAPI spec file to process. Use - to read from stdin")
+	overlayCmd.Flags().StringP("output-file", "o", "-", "output file to write. Use - to write to stdout")
+	overlayCmd.Flags().StringP("format", "", string(filebasics.OutputFormatYaml), "output format: "+
+		string(filebasics.OutputFormatJSON)+" or "+string(filebasics.OutputFormatYaml))
+}
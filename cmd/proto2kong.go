@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kong/go-apiops/deckformat"
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/proto2kong"
+	"github.com/spf13/cobra"
+)
+
+// Executes the CLI command "proto2kong"
+func executeProto2Kong(cmd *cobra.Command, _ []string) error {
+	verbosity, _ := cmd.Flags().GetInt("verbose")
+	logbasics.Initialize(log.LstdFlags, verbosity)
+
+	inputFilenames, err := cmd.Flags().GetStringArray("proto")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'proto'; %w", err)
+	}
+	if len(inputFilenames) == 0 {
+		return fmt.Errorf("at least one '--proto' file is required")
+	}
+
+	outputFilename, err := cmd.Flags().GetString("output-file")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'output-file'; %w", err)
+	}
+
+	docName, err := cmd.Flags().GetString("uuid-base")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'uuid-base'; %w", err)
+	}
+
+	var entityTags []string
+	{
+		tags, err := cmd.Flags().GetStringSlice("select-tag")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'select-tag'; %w", err)
+		}
+		entityTags = tags
+		if len(entityTags) == 0 {
+			entityTags = nil
+		}
+	}
+
+	var outputFormat string
+	{
+		outputFormat, err = cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'format'; %w", err)
+		}
+		outputFormat = strings.ToUpper(outputFormat)
+	}
+
+	var importPaths []string
+	{
+		importPaths, err = cmd.Flags().GetStringArray("import-path")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'import-path'; %w", err)
+		}
+	}
+
+	var servers []string
+	{
+		servers, err = cmd.Flags().GetStringArray("server")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'server'; %w", err)
+		}
+	}
+
+	var grpcGateway bool
+	{
+		grpcGateway, err = cmd.Flags().GetBool("grpc-gateway")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'grpc-gateway'; %w", err)
+		}
+	}
+
+	var protoPath string
+	{
+		protoPath, err = cmd.Flags().GetString("proto-path")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'proto-path'; %w", err)
+		}
+	}
+
+	var noID bool
+	{
+		noID, err = cmd.Flags().GetBool("no-id")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'no-id'; %w", err)
+		}
+	}
+
+	options := proto2kong.P2kOptions{
+		Tags:        entityTags,
+		DocName:     docName,
+		SkipID:      noID,
+		ImportPaths: importPaths,
+		Servers:     servers,
+		GrpcGateway: grpcGateway,
+		ProtoPath:   protoPath,
+	}
+
+	trackInfo := deckformat.HistoryNewEntry("proto2kong")
+	trackInfo["input"] = inputFilenames
+	trackInfo["output"] = outputFilename
+	trackInfo["uuid-base"] = docName
+
+	// do the work: read/convert/write
+	result, err := proto2kong.Convert(inputFilenames, options)
+	if err != nil {
+		return fmt.Errorf("failed converting proto files; %w", err)
+	}
+	deckformat.HistoryAppend(result, trackInfo)
+	return filebasics.WriteSerializedFile(outputFilename, result, filebasics.OutputFormat(outputFormat))
+}
+
+//
+//
+// Define the CLI data for the proto2kong command
+//
+//
+
+var proto2kongCmd = &cobra.Command{
+	Use:   "proto2kong",
+	Short: "Convert protobuf service definitions to Kong's decK format",
+	Long: `Convert protobuf service definitions to Kong's decK format. Reads '.proto' files,
+or compiled FileDescriptorSet files (eg. from 'protoc --descriptor_set_out').
+
+Each gRPC service becomes a Kong service, with a route per method matching its
+gRPC path ('/package.Service/Method'). Entities are named '<package>_<service>'
+and '<package>_<service>_<method>', and get UUIDv5 ids like 'openapi2kong'.
+
+With '--grpc-gateway', each 'google.api.http' annotation also generates a route
+with a 'grpc-gateway' plugin, exposing the method as a REST endpoint. The plugin
+needs the '.proto' files on the Kong nodes, see '--proto-path'.`,
+	RunE: executeProto2Kong,
+	Args: cobra.NoArgs,
+}
+
+func init() {
+	rootCmd.AddCommand(proto2kongCmd)
+	proto2kongCmd.Flags().StringArrayP("proto", "p", nil, "'.proto' file, or compiled FileDescriptorSet file "+
+		"(any other extension), to process. Repeat to convert multiple files into a single file")
+	proto2kongCmd.Flags().StringArrayP("import-path", "I", nil, "directory for resolving imports of '.proto' "+
+		"files (if omitted the directory of each file is used)")
+	proto2kongCmd.Flags().StringP("output-file", "o", "-", "output file to write. Use - to write to stdout")
+	proto2kongCmd.Flags().StringP("format", "", string(filebasics.OutputFormatYaml), "output format: "+
+		string(filebasics.OutputFormatJSON)+" or "+string(filebasics.OutputFormatYaml))
+	proto2kongCmd.Flags().StringP("uuid-base", "", "",
+		`the unique base-string for uuid-v5 generation of entity id's (if omitted
+will use the package of each service)`)
+	proto2kongCmd.Flags().StringSlice("select-tag", nil, "select tags to apply to all entities")
+	proto2kongCmd.Flags().StringArrayP("server", "", nil, "url of the gRPC backend, eg. 'grpcs://host:443'. "+
+		"Repeat for an upstream with multiple targets (default 'grpc://localhost:80')")
+	proto2kongCmd.Flags().BoolP("grpc-gateway", "", false, "generate routes with grpc-gateway plugins "+
+		"from the 'google.api.http' annotations")
+	proto2kongCmd.Flags().StringP("proto-path", "", "", "directory holding the '.proto' files on the Kong "+
+		"nodes, for the grpc-gateway plugins")
+	proto2kongCmd.Flags().BoolP("no-id", "", false, "do not generate UUIDs for entities")
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/routeconflicts"
+	"github.com/spf13/cobra"
+)
+
+// Executes the CLI command "route-conflicts"
+func executeRouteConflicts(cmd *cobra.Command, _ []string) error {
+	inputFilename, err := cmd.Flags().GetString("state")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'state'; %w", err)
+	}
+
+	outputFilename, err := cmd.Flags().GetString("output-file")
+	if err != nil {
+		return fmt.Errorf("failed getting cli argument 'output-file'; %w", err)
+	}
+
+	var outputFormat string
+	{
+		outputFormat, err = cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'format'; %w", err)
+		}
+		outputFormat = strings.ToUpper(outputFormat)
+	}
+
+	var failOnConflicts bool
+	{
+		failOnConflicts, err = cmd.Flags().GetBool("fail-on-conflicts")
+		if err != nil {
+			return fmt.Errorf("failed getting cli argument 'fail-on-conflicts'; %w", err)
+		}
+	}
+
+	// do the work: read/analyze/write
+	data, err := filebasics.DeserializeFile(inputFilename)
+	if err != nil {
+		return fmt.Errorf("failed to read input file '%s'; %w", inputFilename, err)
+	}
+
+	conflicts, err := routeconflicts.Analyze(data, nil)
+	if err != nil {
+		return fmt.Errorf("failed to analyze routes; %w", err)
+	}
+
+	if outputFormat == "PLAIN" {
+		// return as a plain text format, unix style; line separated
+		lines := make([]string, len(conflicts))
+		for i, conflict := range conflicts {
+			lines[i] = conflict.String()
+		}
+		err = filebasics.WriteFile(outputFilename, []byte(strings.Join(lines, "\n")))
+	} else {
+		// return as yaml/json, create an object containing only a conflicts-array
+		list := make([]interface{}, len(conflicts))
+		for i, conflict := range conflicts {
+			list[i] = map[string]interface{}{
+				"type":          string(conflict.Type),
+				"route":         conflict.Route.Name,
+				"service":       conflict.Route.Service,
+				"origin":        conflict.Route.Origin.String(),
+				"other_route":   conflict.Other.Name,
+				"other_service": conflict.Other.Service,
+				"other_origin":  conflict.Other.Origin.String(),
+			}
+		}
+		result := make(map[string]interface{})
+		result["conflicts"] = list
+		err = filebasics.WriteSerializedFile(outputFilename, result, filebasics.OutputFormat(outputFormat))
+	}
+	if err != nil {
+		return err
+	}
+
+	if failOnConflicts && len(conflicts) > 0 {
+		return fmt.Errorf("found %d route conflicts", len(conflicts))
+	}
+	return nil
+}
+
+//
+//
+// Define the CLI data for the route-conflicts command
+//
+//
+
+var routeConflictsCmd = &cobra.Command{
+	Use:   "route-conflicts [flags]",
+	Short: "Reports shadowed and ambiguous routes in a decK file",
+	Long: `Reports shadowed and ambiguous routes in a decK file.
+
+Routes of all services are compared, since routes are global in Kong. Their paths
+(including regex captures), methods, hosts, and headers are checked for requests
+that match multiple routes. A route is reported as "shadowed" if every request it
+matches is matched by another route first, and as "ambiguous" if both routes match
+with equal precedence, in which case which route is matched is undefined.
+
+Run it after 'merge' to find conflicts between routes generated from different specs.
+Routes using expressions, or regex constructs that cannot be analyzed, are skipped.`,
+	RunE: executeRouteConflicts,
+	Args: cobra.NoArgs,
+}
+
+func init() {
+	rootCmd.AddCommand(routeConflictsCmd)
+	routeConflictsCmd.Flags().StringP("state", "s", "-", "decK file to process. Use - to read from stdin")
+	routeConflictsCmd.Flags().StringP("output-file", "o", "-", "output file to write. Use - to write to stdout")
+	routeConflictsCmd.Flags().StringP("format", "", "PLAIN", "output format: "+
+		string(filebasics.OutputFormatJSON)+", "+string(filebasics.OutputFormatYaml)+", or PLAIN")
+	routeConflictsCmd.Flags().BoolP("fail-on-conflicts", "", false, "exit with an error if conflicts are found")
+}
@@ -42,10 +42,16 @@ var EntityPointers = map[string][]string{
 	"consumers": {
 		"$.consumers[*]",
 	},
+	"degraphql_routes": {
+		"$.degraphql_routes[*]",
+	},
 	"document_objects": {
 		"$.document_objects[*]",
 		"$.services[*].document_objects[*]",
 	},
+	"graphql_ratelimiting_cost_decorations": {
+		"$.graphql_ratelimiting_cost_decorations[*]",
+	},
 	"hmacauth_credentials": {
 		"$.hmacauth_credentials[*]",
 		"$.consumers[*].hmacauth_credentials[*]",
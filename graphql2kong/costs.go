@@ -0,0 +1,138 @@
+package graphql2kong
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// costDirectives are the declarations of the supported cost directives, added to the schema if it
+// does not declare them itself. '@cost' covers both the IBM cost spec ('weight'), and the older
+// graphql-cost-analysis style ('complexity' and 'multipliers').
+var costDirectives = map[string]string{
+	"cost": `directive @cost(weight: String, complexity: Int, multipliers: [String!])
+	on ARGUMENT_DEFINITION | ENUM | FIELD_DEFINITION | INPUT_FIELD_DEFINITION | OBJECT | SCALAR`,
+	"listSize": `directive @listSize(assumedSize: Int, slicingArguments: [String!],
+	sizedFields: [String!], requireOneSlicingArgument: Boolean = true) on FIELD_DEFINITION`,
+}
+
+// getNumberArgument returns the numeric value of a directive argument, which may be given as a string.
+// Returns the default if the argument is not set.
+func getNumberArgument(directive *ast.Directive, name string, defaultValue float64) (float64, error) {
+	argument := directive.Arguments.ForName(name)
+	if argument == nil || argument.Value == nil || argument.Value.Kind == ast.NullValue {
+		return defaultValue, nil
+	}
+	number, err := strconv.ParseFloat(argument.Value.Raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected argument '%s' of '@%s' to be a number, got '%s'",
+			name, directive.Name, argument.Value.Raw)
+	}
+	return number, nil
+}
+
+// getListArgument returns the strings of a list argument of a directive, or an empty list if not set.
+func getListArgument(directive *ast.Directive, name string) []string {
+	result := make([]string, 0)
+	argument := directive.Arguments.ForName(name)
+	if argument == nil || argument.Value == nil {
+		return result
+	}
+	if argument.Value.Kind != ast.ListValue {
+		return append(result, argument.Value.Raw) // a single value is coerced into a list
+	}
+	for _, child := range argument.Value.Children {
+		result = append(result, child.Value.Raw)
+	}
+	return result
+}
+
+// getCostDecoration returns the cost decoration for a field, from its '@cost' and '@listSize' directives.
+// The weight, or complexity, is the constant to add, and the assumed size the constant to multiply by.
+// The slicing arguments, or multipliers, are the arguments to multiply by. Returns nil if the field
+// has neither directive.
+func getCostDecoration(typePath string, field *ast.FieldDefinition) (map[string]interface{}, error) {
+	cost := field.Directives.ForName("cost")
+	listSize := field.Directives.ForName("listSize")
+	if cost == nil && listSize == nil {
+		return nil, nil
+	}
+
+	var (
+		addConstant  = 1.0
+		mulConstant  = 1.0
+		mulArguments = make([]string, 0)
+		err          error
+	)
+	if cost != nil {
+		if addConstant, err = getNumberArgument(cost, "weight", addConstant); err != nil {
+			return nil, fmt.Errorf("field '%s': %w", typePath, err)
+		}
+		if addConstant, err = getNumberArgument(cost, "complexity", addConstant); err != nil {
+			return nil, fmt.Errorf("field '%s': %w", typePath, err)
+		}
+		mulArguments = append(mulArguments, getListArgument(cost, "multipliers")...)
+	}
+	if listSize != nil {
+		if mulConstant, err = getNumberArgument(listSize, "assumedSize", mulConstant); err != nil {
+			return nil, fmt.Errorf("field '%s': %w", typePath, err)
+		}
+		mulArguments = append(mulArguments, getListArgument(listSize, "slicingArguments")...)
+	}
+
+	return map[string]interface{}{
+		"type_path":     typePath,
+		"add_constant":  addConstant,
+		"add_arguments": make([]string, 0),
+		"mul_constant":  mulConstant,
+		"mul_arguments": mulArguments,
+	}, nil
+}
+
+// createCostDecorations creates the 'graphql-rate-limiting-advanced' cost decorations for the fields of
+// the schema that have cost directives, sorted by type path ('Type.field').
+func createCostDecorations(
+	schema *ast.Schema,
+	serviceName string,
+	tags []string,
+	uuidNamespace uuid.UUID,
+	skipID bool,
+) ([]interface{}, error) {
+	decorations := make(map[string]map[string]interface{})
+	for _, definition := range schema.Types {
+		if definition.BuiltIn || (definition.Kind != ast.Object && definition.Kind != ast.Interface) {
+			continue
+		}
+		for _, field := range definition.Fields {
+			typePath := definition.Name + "." + field.Name
+			decoration, err := getCostDecoration(typePath, field)
+			if err != nil {
+				return nil, err
+			}
+			if decoration != nil {
+				decorations[typePath] = decoration
+			}
+		}
+	}
+
+	typePaths := make([]string, 0, len(decorations))
+	for typePath := range decorations {
+		typePaths = append(typePaths, typePath)
+	}
+	sort.Strings(typePaths)
+
+	result := make([]interface{}, len(typePaths))
+	for i, typePath := range typePaths {
+		decoration := decorations[typePath]
+		decoration["service"] = serviceName
+		decoration["tags"] = tags
+		if !skipID {
+			decoration["id"] = uuid.NewSHA1(uuidNamespace, []byte(serviceName+".cost_decoration."+typePath)).String()
+		}
+		result[i] = decoration
+	}
+	return result, nil
+}
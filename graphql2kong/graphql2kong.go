@@ -0,0 +1,491 @@
+package graphql2kong
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/openapitools"
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"github.com/pb33f/libopenapi/orderedmap"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+	"go.yaml.in/yaml/v4"
+)
+
+const (
+	formatVersionKey   = "_format_version"
+	formatVersionValue = "3.0"
+
+	// the path of the route proxying GraphQL requests
+	graphqlPath = "/graphql"
+
+	// the sidecar extension listing the persisted queries, exposed as REST endpoints by 'degraphql'
+	persistedQueriesExtension = "x-kong-persisted-queries"
+
+	degraphqlPlugin   = "degraphql"
+	rateLimitedPlugin = "graphql-rate-limiting-advanced"
+
+	// default regex priorities to assign to routes, same as openapi2kong
+	regexPriorityWithPathParams = 100
+	regexPriorityPlain          = 200
+)
+
+// G2kOptions defines the options for a graphql2kong conversion operation
+type G2kOptions struct {
+	// Array of tags to mark all generated entities with, taken from 'x-kong-tags' if omitted.
+	Tags []string
+	// Base document name, will be taken from x-kong-name if omitted (for UUID generation!)
+	DocName string
+	// Namespace for UUID generation, defaults to DNS namespace for UUID v5
+	UUIDNamespace uuid.UUID
+	// Skip ID generation (UUIDs)
+	SkipID bool
+	// Name of the schema, used in error messages.
+	SchemaName string
+	// The sidecar document (JSON or YAML) holding the 'x-kong-...' directives for the schema. Optional.
+	Sidecar []byte
+}
+
+// setDefaults sets the defaults for the GraphQL2Kong operation.
+func (opts *G2kOptions) setDefaults() {
+	var emptyUUID uuid.UUID
+
+	if bytes.Equal(emptyUUID[:], opts.UUIDNamespace[:]) {
+		opts.UUIDNamespace = uuid.NameSpaceDNS
+	}
+	if opts.SchemaName == "" {
+		opts.SchemaName = "schema.graphql"
+	}
+}
+
+// persistedQuery is an entry of 'x-kong-persisted-queries'.
+type persistedQuery struct {
+	URI     string   `json:"uri"`     // the path exposing the query, eg. "/products/:id"
+	Query   string   `json:"query"`   // the GraphQL query, variables are taken from the uri or query string
+	Methods []string `json:"methods"` // the HTTP methods, defaults to GET
+}
+
+// sidecar holds the directives from the sidecar document.
+type sidecar struct {
+	extensions *orderedmap.Map[string, *yaml.Node] // the 'x-kong-...' directives, by name
+	servers    []*v3.Server                        // the backend GraphQL endpoints
+}
+
+// parseSidecar parses the sidecar document. An empty document results in an empty sidecar.
+func parseSidecar(content []byte) (*sidecar, error) {
+	result := &sidecar{extensions: orderedmap.New[string, *yaml.Node]()}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return result, nil
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse the sidecar: %w", err)
+	}
+	root := &document
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("expected the sidecar to be an object")
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		switch {
+		case key == "servers":
+			var servers []struct {
+				URL string `yaml:"url"`
+			}
+			if err := value.Decode(&servers); err != nil {
+				return nil, errors.New("expected 'servers' in the sidecar to be an array of objects with a 'url'")
+			}
+			for _, server := range servers {
+				result.servers = append(result.servers, &v3.Server{URL: server.URL})
+			}
+		case strings.HasPrefix(key, "x-kong-"):
+			result.extensions.Set(key, value)
+		}
+	}
+	return result, nil
+}
+
+// getKongTags returns the provided tags or if nil, then the 'x-kong-tags' directive, validated to be
+// a string array.
+func getKongTags(extensions *orderedmap.Map[string, *yaml.Node], tagsProvided []string) ([]string, error) {
+	if tagsProvided != nil {
+		return tagsProvided, nil
+	}
+	tags := make([]string, 0)
+	node, ok := extensions.Get("x-kong-tags")
+	if !ok || node == nil {
+		return tags, nil
+	}
+	if err := node.Decode(&tags); err != nil {
+		return nil, errors.New("expected 'x-kong-tags' to be an array of strings")
+	}
+	return tags, nil
+}
+
+// getDocName returns the provided name, or the 'x-kong-name' directive. If neither is set, then a random
+// name is generated.
+func getDocName(extensions *orderedmap.Map[string, *yaml.Node], nameProvided string) (string, error) {
+	if nameProvided != "" {
+		return nameProvided, nil
+	}
+	if node, ok := extensions.Get("x-kong-name"); ok && node != nil {
+		var name string
+		if err := node.Decode(&name); err != nil {
+			return "", errors.New("expected 'x-kong-name' to be a string")
+		}
+		return name, nil
+	}
+	logbasics.Info("no document name, nor x-kong-name specified, generating random name")
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate UUID: %w", err)
+	}
+	return id.String(), nil
+}
+
+// getPersistedQueries returns the entries of 'x-kong-persisted-queries', validated against the schema.
+func getPersistedQueries(
+	extensions *orderedmap.Map[string, *yaml.Node],
+	schema *ast.Schema,
+) ([]persistedQuery, error) {
+	node, ok := extensions.Get(persistedQueriesExtension)
+	if !ok || node == nil {
+		return nil, nil
+	}
+	var queries []persistedQuery
+	if err := node.Decode(&queries); err != nil {
+		return nil, fmt.Errorf("expected '%s' to be an array of objects", persistedQueriesExtension)
+	}
+
+	seen := make(map[string]bool)
+	for i, query := range queries {
+		if !strings.HasPrefix(query.URI, "/") {
+			return nil, fmt.Errorf("expected the 'uri' of persisted query %d to start with '/'", i+1)
+		}
+		if len(query.Methods) == 0 {
+			queries[i].Methods = []string{"GET"}
+		}
+		for j, method := range queries[i].Methods {
+			queries[i].Methods[j] = strings.ToUpper(method)
+			key := queries[i].Methods[j] + " " + query.URI
+			if seen[key] {
+				return nil, fmt.Errorf("persisted query '%s' is defined more than once", key)
+			}
+			seen[key] = true
+		}
+		if _, errs := gqlparser.LoadQuery(schema, query.Query); len(errs) > 0 {
+			return nil, fmt.Errorf("persisted query '%s' is invalid: %s", query.URI, strings.TrimSpace(errs.Error()))
+		}
+	}
+	return queries, nil
+}
+
+// loadSchema parses and validates the SDL schema. The supported cost directives are declared if the
+// schema does not declare them itself.
+func loadSchema(content []byte, name string) (*ast.Schema, error) {
+	source := &ast.Source{Name: name, Input: string(content)}
+	document, err := parser.ParseSchema(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the schema: %w", err)
+	}
+
+	sources := []*ast.Source{source}
+	for _, directive := range []string{"cost", "listSize"} {
+		if document.Directives.ForName(directive) == nil {
+			sources = append(sources, &ast.Source{Name: "@" + directive, Input: costDirectives[directive]})
+		}
+	}
+	schema, err := gqlparser.LoadSchema(sources...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the schema: %w", err)
+	}
+	return schema, nil
+}
+
+// convertURIToRegex converts a 'degraphql' uri, with ':name' parameters, into a Kong regex path.
+// Returns the regex path, and whether it has any parameters.
+func convertURIToRegex(uri string) (string, bool) {
+	segments := strings.Split(uri, "/")
+	hasParams := false
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") && len(segment) > 1 {
+			captureName := openapitools.SanitizeRegexCapture(segment[1:], false)
+			segments[i] = "(?<" + captureName + ">[^#?/]+)"
+			hasParams = true
+			continue
+		}
+		segments[i] = regexp.QuoteMeta(segment)
+	}
+	return "~" + strings.Join(segments, "/") + "$", hasParams
+}
+
+// createRoute creates a route, based on the route defaults (JSON string, or nil).
+func createRoute(
+	routeName string,
+	paths []string,
+	methods []string,
+	regexPriority int,
+	stripPath bool,
+	routeDefaults []byte,
+	tags []string,
+	uuidNamespace uuid.UUID,
+	skipID bool,
+) map[string]interface{} {
+	route := make(map[string]interface{})
+	if routeDefaults != nil {
+		_ = json.Unmarshal(routeDefaults, &route)
+	}
+	route["name"] = routeName
+	route["paths"] = paths
+	route["methods"] = methods
+	route["tags"] = tags
+	route["plugins"] = make([]interface{}, 0)
+	if _, found := route["regex_priority"]; !found {
+		route["regex_priority"] = regexPriority
+	}
+	if _, found := route["strip_path"]; !found {
+		route["strip_path"] = stripPath
+	}
+	if !skipID {
+		route["id"] = uuid.NewSHA1(uuidNamespace, []byte(routeName+".route")).String()
+	}
+	return route
+}
+
+// createPlugin creates a plugin entity, with the given config.
+func createPlugin(
+	name string,
+	config map[string]interface{},
+	baseName string,
+	tags []string,
+	uuidNamespace uuid.UUID,
+	skipID bool,
+) map[string]interface{} {
+	plugin := map[string]interface{}{
+		"name":   name,
+		"config": config,
+		"tags":   tags,
+	}
+	if !skipID {
+		plugin["id"] = uuid.NewSHA1(uuidNamespace, []byte(baseName+".plugin."+name)).String()
+	}
+	return plugin
+}
+
+// getPlugins returns the plugins from the 'x-kong-plugin-...' directives of the sidecar, sorted by name.
+func getPlugins(
+	extensions *orderedmap.Map[string, *yaml.Node],
+	baseName string,
+	tags []string,
+	uuidNamespace uuid.UUID,
+	skipID bool,
+) ([]interface{}, error) {
+	plugins := make(map[string]map[string]interface{})
+	for pair := extensions.First(); pair != nil; pair = pair.Next() {
+		if !strings.HasPrefix(pair.Key(), "x-kong-plugin-") {
+			continue
+		}
+		pluginName := strings.TrimPrefix(pair.Key(), "x-kong-plugin-")
+		jsonstr, err := openapitools.GetXKongObject(extensions, pair.Key(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var plugin map[string]interface{}
+		_ = json.Unmarshal(jsonstr, &plugin)
+		plugin["name"] = pluginName
+		plugin["tags"] = tags
+		if !skipID {
+			plugin["id"] = uuid.NewSHA1(uuidNamespace, []byte(baseName+".plugin."+pluginName)).String()
+		}
+		// foreign keys to service+route are not allowed (consumer is allowed)
+		delete(plugin, "service")
+		delete(plugin, "route")
+		plugins[pluginName] = plugin
+	}
+
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]interface{}, len(names))
+	for i, name := range names {
+		result[i] = plugins[name]
+	}
+	return result, nil
+}
+
+// Convert converts a GraphQL SDL schema into a Kong declarative file. It generates a service for the
+// GraphQL backend (from the 'servers' in the sidecar), with a '/graphql' route proxying the GraphQL
+// requests. The persisted queries from 'x-kong-persisted-queries' are exposed as REST endpoints, on a
+// route with the 'degraphql' plugin, which sends them to the same backend path as the GraphQL requests.
+// The '@cost' and '@listSize' directives on fields become cost decorations for the
+// 'graphql-rate-limiting-advanced' plugin.
+func Convert(content []byte, opts G2kOptions) (map[string]interface{}, error) {
+	opts.setDefaults()
+	logbasics.Debug("received GraphQL2Kong options", "options", opts)
+
+	schema, err := loadSchema(content, opts.SchemaName)
+	if err != nil {
+		return nil, err
+	}
+	side, err := parseSidecar(opts.Sidecar)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := getKongTags(side.extensions, opts.Tags)
+	if err != nil {
+		return nil, err
+	}
+	docBaseName, err := getDocName(side.extensions, opts.DocName)
+	if err != nil {
+		return nil, err
+	}
+	docBaseName = openapitools.Slugify(false, docBaseName)
+	logbasics.Info("document name (namespace for UUID generation)", "name", docBaseName)
+
+	serviceDefaults, err := openapitools.GetXKongObject(side.extensions, "x-kong-service-defaults", nil)
+	if err != nil {
+		return nil, err
+	}
+	upstreamDefaults, err := openapitools.GetXKongObject(side.extensions, "x-kong-upstream-defaults", nil)
+	if err != nil {
+		return nil, err
+	}
+	routeDefaults, err := openapitools.GetXKongObject(side.extensions, "x-kong-route-defaults", nil)
+	if err != nil {
+		return nil, err
+	}
+	queries, err := getPersistedQueries(side.extensions, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	service, upstream, err := openapitools.CreateKongService(docBaseName, side.servers, serviceDefaults,
+		upstreamDefaults, tags, opts.UUIDNamespace, opts.SkipID)
+	if err != nil {
+		return nil, err
+	}
+	upstreams := make([]interface{}, 0)
+	if upstream != nil {
+		upstreams = append(upstreams, upstream)
+	}
+	if service["plugins"], err = getPlugins(side.extensions, docBaseName, tags, opts.UUIDNamespace,
+		opts.SkipID); err != nil {
+		return nil, err
+	}
+
+	costDecorations, err := createCostDecorations(schema, docBaseName, tags, opts.UUIDNamespace, opts.SkipID)
+	if err != nil {
+		return nil, err
+	}
+	if len(costDecorations) > 0 {
+		// the cost decorations are only used by the 'default' cost strategy
+		for _, plugin := range service["plugins"].([]interface{}) {
+			plugin := plugin.(map[string]interface{})
+			if plugin["name"] != rateLimitedPlugin {
+				continue
+			}
+			config, _ := plugin["config"].(map[string]interface{})
+			if config == nil {
+				config = make(map[string]interface{})
+				plugin["config"] = config
+			}
+			if config["cost_strategy"] == nil {
+				config["cost_strategy"] = "default"
+			}
+		}
+	}
+
+	// the route proxying the GraphQL requests, to the path of the server
+	routeName := docBaseName + "_graphql"
+	graphqlRoute := createRoute(routeName, []string{"~" + graphqlPath + "$"}, []string{"GET", "POST"},
+		regexPriorityPlain, true, routeDefaults, tags, opts.UUIDNamespace, opts.SkipID)
+	routes := []interface{}{graphqlRoute}
+
+	// the backend path the GraphQL requests end up on, the persisted queries are sent there as well
+	backendPath, _ := service["path"].(string)
+	if backendPath == "" {
+		backendPath = "/"
+	}
+	if graphqlRoute["strip_path"] == false {
+		// the route defaults disabled stripping, so the route path is appended
+		backendPath = strings.TrimSuffix(backendPath, "/") + graphqlPath
+	}
+
+	degraphqlRoutes := make([]interface{}, 0, len(queries))
+	if len(queries) > 0 {
+		// the route exposing the persisted queries, the 'degraphql' plugin maps them to GraphQL requests
+		paths := make([]string, 0, len(queries))
+		methods := make([]string, 0)
+		regexPriority := regexPriorityPlain
+		seenPaths, seenMethods := make(map[string]bool), make(map[string]bool)
+		for _, query := range queries {
+			path, hasParams := convertURIToRegex(query.URI)
+			if hasParams {
+				regexPriority = regexPriorityWithPathParams
+			}
+			if !seenPaths[path] {
+				paths = append(paths, path)
+				seenPaths[path] = true
+			}
+			for _, method := range query.Methods {
+				if !seenMethods[method] {
+					methods = append(methods, method)
+					seenMethods[method] = true
+				}
+			}
+
+			degraphqlRoute := map[string]interface{}{
+				"service": docBaseName,
+				"uri":     query.URI,
+				"query":   query.Query,
+				"methods": query.Methods,
+				"tags":    tags,
+			}
+			if !opts.SkipID {
+				degraphqlRoute["id"] = uuid.NewSHA1(opts.UUIDNamespace,
+					[]byte(docBaseName+".degraphql_route."+strings.Join(query.Methods, ",")+" "+query.URI)).String()
+			}
+			degraphqlRoutes = append(degraphqlRoutes, degraphqlRoute)
+		}
+		sort.Strings(methods)
+
+		queriesRouteName := docBaseName + "_persisted-queries"
+		route := createRoute(queriesRouteName, paths, methods, regexPriority, false, routeDefaults, tags,
+			opts.UUIDNamespace, opts.SkipID)
+		route["plugins"] = []interface{}{
+			createPlugin(degraphqlPlugin, map[string]interface{}{"graphql_server_path": backendPath},
+				queriesRouteName, tags, opts.UUIDNamespace, opts.SkipID),
+		}
+		routes = append(routes, route)
+	}
+	service["routes"] = routes
+
+	result := map[string]interface{}{
+		formatVersionKey: formatVersionValue,
+		"services":       []interface{}{service},
+		"upstreams":      upstreams,
+	}
+	if len(degraphqlRoutes) > 0 {
+		result["degraphql_routes"] = degraphqlRoutes
+	}
+	if len(costDecorations) > 0 {
+		result["graphql_ratelimiting_cost_decorations"] = costDecorations
+	}
+	return result, nil
+}
@@ -0,0 +1,188 @@
+package graphql2kong
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fixturePath = "./graphql_testfiles/"
+
+func Test_Graphql2kong(t *testing.T) {
+	files, err := os.ReadDir(fixturePath)
+	require.NoError(t, err)
+
+	for _, file := range files {
+		fileNameIn := file.Name()
+		if !strings.HasSuffix(fileNameIn, ".graphql") {
+			continue
+		}
+		t.Run(fileNameIn, func(t *testing.T) {
+			baseName := strings.TrimSuffix(fileNameIn, ".graphql")
+			fileNameExpected := baseName + ".expected.json"
+			fileNameOut := baseName + ".generated.json"
+
+			dataIn, err := os.ReadFile(fixturePath + fileNameIn)
+			require.NoError(t, err)
+			opts := G2kOptions{SchemaName: fileNameIn}
+			opts.Sidecar, err = os.ReadFile(fixturePath + baseName + ".sidecar.yaml")
+			if os.IsNotExist(err) {
+				opts.DocName = baseName // no sidecar, so no 'x-kong-name'
+			} else {
+				require.NoError(t, err)
+			}
+
+			dataOut, err := Convert(dataIn, opts)
+			require.NoError(t, err)
+
+			JSONOut, _ := json.MarshalIndent(dataOut, "", "  ")
+			os.WriteFile(fixturePath+fileNameOut, JSONOut, 0o600)
+			JSONExpected, err := os.ReadFile(fixturePath + fileNameExpected)
+			require.NoError(t, err)
+
+			assert.JSONEq(t, string(JSONExpected), string(JSONOut),
+				"'%s': the JSON blobs should be equal", fixturePath+fileNameIn)
+		})
+	}
+}
+
+func Test_Graphql2kong_Errors(t *testing.T) {
+	schema := []byte(`type Query {
+  product(id: ID!): Product
+}
+
+type Product {
+  id: ID!
+}
+`)
+
+	tests := []struct {
+		name    string
+		schema  []byte
+		sidecar string
+		err     string
+	}{
+		{
+			name:   "invalid schema",
+			schema: []byte("type Query {"),
+			err:    "failed to parse the schema: schema.graphql:1:13: Expected Name, found <EOF>",
+		},
+		{
+			name:   "undefined type",
+			schema: []byte("type Query { product: Product }"),
+			err:    "failed to load the schema: schema.graphql:1:23: Undefined type Product.",
+		},
+		{
+			name:   "invalid cost",
+			schema: []byte(`type Query { product: String @cost(weight: "high") }`),
+			err:    "field 'Query.product': expected argument 'weight' of '@cost' to be a number, got 'high'",
+		},
+		{
+			name:    "invalid sidecar",
+			schema:  schema,
+			sidecar: "- x-kong-name: products",
+			err:     "expected the sidecar to be an object",
+		},
+		{
+			name:   "invalid persisted query",
+			schema: schema,
+			sidecar: `x-kong-persisted-queries:
+- uri: /products/:id
+  query: "query ($id: ID!) { product(id: $id) { name } }"
+`,
+			err: "persisted query '/products/:id' is invalid: input:1:39: Cannot query field \"name\" on type \"Product\".",
+		},
+		{
+			name:   "relative uri",
+			schema: schema,
+			sidecar: `x-kong-persisted-queries:
+- uri: products
+  query: "{ product(id: 1) { id } }"
+`,
+			err: "expected the 'uri' of persisted query 1 to start with '/'",
+		},
+		{
+			name:   "duplicate persisted query",
+			schema: schema,
+			sidecar: `x-kong-persisted-queries:
+- uri: /products/:id
+  query: "query ($id: ID!) { product(id: $id) { id } }"
+- uri: /products/:id
+  methods: [POST, GET]
+  query: "query ($id: ID!) { product(id: $id) { id } }"
+`,
+			err: "persisted query 'GET /products/:id' is defined more than once",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Convert(tc.schema, G2kOptions{Sidecar: []byte(tc.sidecar)})
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func Test_Graphql2kong_BackendPath(t *testing.T) {
+	schema := []byte("type Query { product(id: ID!): String }")
+
+	tests := []struct {
+		name      string
+		sidecar   string
+		stripPath bool
+		path      string
+	}{
+		{
+			name:      "server path",
+			sidecar:   "servers: [{url: 'https://catalog.internal/api/graphql'}]",
+			stripPath: true,
+			path:      "/api/graphql",
+		},
+		{
+			name:      "no server path",
+			sidecar:   "servers: [{url: 'https://catalog.internal'}]",
+			stripPath: true,
+			path:      "/",
+		},
+		{
+			name: "no stripping",
+			sidecar: `servers: [{url: 'https://catalog.internal/api'}]
+x-kong-route-defaults:
+  strip_path: false
+`,
+			stripPath: false,
+			path:      "/api/graphql",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sidecar := tc.sidecar + `
+x-kong-persisted-queries:
+- uri: /products/:id
+  query: "query ($id: ID!) { product(id: $id) }"
+`
+			result, err := Convert(schema, G2kOptions{Sidecar: []byte(sidecar), SkipID: true})
+			require.NoError(t, err)
+
+			// the GraphQL requests, and the persisted queries, end up on the same backend path
+			service := result["services"].([]interface{})[0].(map[string]interface{})
+			routes := service["routes"].([]interface{})
+			assert.Equal(t, tc.stripPath, routes[0].(map[string]interface{})["strip_path"])
+			plugin := routes[1].(map[string]interface{})["plugins"].([]interface{})[0].(map[string]interface{})
+			assert.Equal(t, tc.path, plugin["config"].(map[string]interface{})["graphql_server_path"])
+		})
+	}
+}
+
+func Test_convertURIToRegex(t *testing.T) {
+	path, hasParams := convertURIToRegex("/products")
+	assert.Equal(t, "~/products$", path)
+	assert.False(t, hasParams)
+
+	path, hasParams = convertURIToRegex("/:owner/repos/:name.json")
+	assert.Equal(t, "~/(?<owner>[^#?/]+)/repos/(?<name_json>[^#?/]+)$", path)
+	assert.True(t, hasParams)
+}
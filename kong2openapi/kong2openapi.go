@@ -0,0 +1,534 @@
+package kong2openapi
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-apiops/jsonbasics"
+	"github.com/kong/go-apiops/logbasics"
+)
+
+const (
+	openapiVersion = "3.0.3"
+	defaultTitle   = "Kong API"
+	defaultVersion = "1.0.0"
+	pluginPrefix   = "x-kong-plugin-"
+)
+
+// K2OOptions defines the options for a kong2openapi conversion
+type K2OOptions struct {
+	// Title of the generated spec, defaults to the name of the first service
+	Title string
+	// Version of the generated spec, defaults to "1.0.0"
+	Version string
+}
+
+// the fields of entities that are represented in the spec, or generated by openapi2kong. The other fields
+// go into the 'x-kong-...-defaults' extensions.
+var (
+	serviceFields  = []string{"id", "name", "tags", "routes", "plugins", "host", "port", "protocol", "path", "url"}
+	routeFields    = []string{"id", "name", "tags", "plugins", "service", "paths", "methods", "regex_priority"}
+	upstreamFields = []string{"id", "name", "tags", "targets"}
+	pluginFields   = []string{"id", "name", "tags", "service", "route"}
+	entityFields   = []string{"created_at", "updated_at"}
+)
+
+// allMethods are the operations generated for routes without methods, since a spec requires explicit methods
+var allMethods = []string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH"}
+
+// service is a Kong service, with its routes and plugins collected from the deck file.
+type service struct {
+	entity   map[string]interface{}
+	name     string
+	routes   []map[string]interface{}
+	plugins  []map[string]interface{}
+	servers  []interface{}          // the OAS servers
+	defaults map[string]interface{} // the 'x-kong-service-defaults'
+	upstream map[string]interface{} // the 'x-kong-upstream-defaults'
+}
+
+// copyFields returns a copy of the entity, without the given fields, and without empty values.
+func copyFields(entity map[string]interface{}, skip ...[]string) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, value := range entity {
+		if value == nil || slices.Contains(entityFields, key) {
+			continue
+		}
+		skipped := false
+		for _, fields := range skip {
+			skipped = skipped || slices.Contains(fields, key)
+		}
+		if !skipped {
+			result[key] = value
+		}
+	}
+	return jsonbasics.DeepCopyObject(result)
+}
+
+// getReference returns the name or id of a foreign key, which is either a string or an object.
+func getReference(value interface{}) []string {
+	switch ref := value.(type) {
+	case string:
+		return []string{ref}
+	case map[string]interface{}:
+		refs := make([]string, 0)
+		for _, field := range []string{"name", "id"} {
+			if s, ok := ref[field].(string); ok {
+				refs = append(refs, s)
+			}
+		}
+		return refs
+	}
+	return nil
+}
+
+// getServers returns the OAS servers for a service, based on its url, or protocol, host, port, and path.
+// If the host is an upstream, then its targets are the servers. Protocols other than http(s) are returned
+// to be added to the service defaults.
+func getServers(svc map[string]interface{}, upstreams map[string]map[string]interface{},
+) ([]interface{}, map[string]interface{}, error) {
+	protocol, _ := svc["protocol"].(string)
+	host, _ := svc["host"].(string)
+	path, _ := svc["path"].(string)
+	port := ""
+	if svc["port"] != nil {
+		port = fmt.Sprintf("%v", svc["port"])
+	}
+	if svcURL, ok := svc["url"].(string); ok {
+		u, err := url.Parse(svcURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse url of service '%v': %w", svc["name"], err)
+		}
+		protocol, host, port, path = u.Scheme, u.Hostname(), u.Port(), u.Path
+	}
+	if host == "" {
+		return nil, nil, fmt.Errorf("service '%v' has no host", svc["name"])
+	}
+	if protocol == "" {
+		protocol = "http"
+	}
+
+	// the url scheme must be http(s), other protocols are set in the defaults
+	defaults := make(map[string]interface{})
+	scheme := protocol
+	if protocol != "http" && protocol != "https" {
+		defaults["protocol"] = protocol
+		scheme = "http"
+		if strings.HasSuffix(protocol, "s") {
+			scheme = "https"
+		}
+	}
+
+	createURL := func(hostPort string) string {
+		return scheme + "://" + hostPort + path
+	}
+	addPort := func(host string, port string) string {
+		if port == "" || (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+			return host
+		}
+		return host + ":" + port
+	}
+
+	upstream, found := upstreams[host]
+	if !found {
+		return []interface{}{map[string]interface{}{"url": createURL(addPort(host, port))}}, defaults, nil
+	}
+
+	targets, err := jsonbasics.GetObjectArrayField(upstream, "targets")
+	if err != nil {
+		return nil, nil, fmt.Errorf("expected 'targets' of upstream '%s' to be an array: %w", host, err)
+	}
+	if len(targets) == 0 {
+		return nil, nil, fmt.Errorf("upstream '%s' of service '%v' has no targets", host, svc["name"])
+	}
+	servers := make([]interface{}, 0, len(targets))
+	for _, target := range targets {
+		hostPort, _ := target["target"].(string)
+		if targetHost, targetPort, found := strings.Cut(hostPort, ":"); found {
+			hostPort = addPort(targetHost, targetPort)
+		}
+		servers = append(servers, map[string]interface{}{"url": createURL(hostPort)})
+	}
+	return servers, defaults, nil
+}
+
+// getPluginExtensions converts the plugins into 'x-kong-plugin-*' extensions on the target object. The
+// request-validator is skipped, since its schemas are converted separately.
+func getPluginExtensions(plugins []map[string]interface{}, target map[string]interface{}) {
+	for _, plugin := range plugins {
+		name, _ := plugin["name"].(string)
+		if name == "" || name == validatorPlugin {
+			continue
+		}
+		target[pluginPrefix+name] = copyFields(plugin, pluginFields)
+	}
+}
+
+// getOperationID returns the operationId for a route, with the service name prefix removed, since
+// openapi2kong adds that again.
+func getOperationID(route map[string]interface{}, svc *service) string {
+	name, _ := route["name"].(string)
+	if name == "" {
+		name = svc.name + "_route"
+	}
+	for _, sep := range []string{"_", "-"} {
+		if strings.HasPrefix(name, svc.name+sep) && len(name) > len(svc.name)+1 {
+			return strings.TrimPrefix(name, svc.name+sep)
+		}
+	}
+	return name
+}
+
+// Convert converts a deck file into an OpenAPI 3.0 spec skeleton. Services become servers, routes become
+// paths and operations, and request-validator schemas become parameters and request bodies. Other
+// plugins, and entity fields that are not represented in the spec, are kept as 'x-kong-*' extensions.
+// The first service is the document level service, operations of other services get their own servers.
+// Plain (prefix) route paths are kept in the 'x-kong-route-defaults', since a template is an exact match.
+// Routes that cannot be represented (expressions, complex regexes) are skipped and logged.
+func Convert(deckfile map[string]interface{}, opts K2OOptions) (map[string]interface{}, error) {
+	if deckfile == nil {
+		return nil, fmt.Errorf("no deck file provided")
+	}
+
+	serviceEntities, err := jsonbasics.GetObjectArrayField(deckfile, "services")
+	if err != nil {
+		return nil, fmt.Errorf("expected 'services' to be an array: %w", err)
+	}
+	if len(serviceEntities) == 0 {
+		return nil, fmt.Errorf("the deck file has no services")
+	}
+
+	upstreamEntities, err := jsonbasics.GetObjectArrayField(deckfile, "upstreams")
+	if err != nil {
+		return nil, fmt.Errorf("expected 'upstreams' to be an array: %w", err)
+	}
+	upstreams := make(map[string]map[string]interface{})
+	for _, upstream := range upstreamEntities {
+		if name, ok := upstream["name"].(string); ok {
+			upstreams[name] = upstream
+		}
+	}
+
+	// collect the services, their routes, and the plugins of both
+	services := make([]*service, 0, len(serviceEntities))
+	servicesByRef := make(map[string]*service)
+	routesByRef := make(map[string]map[string]interface{})
+	routePlugins := make(map[string][]map[string]interface{}) // plugins by route name
+	for _, entity := range serviceEntities {
+		name, _ := entity["name"].(string)
+		svc := &service{entity: entity, name: name}
+		if svc.routes, err = jsonbasics.GetObjectArrayField(entity, "routes"); err != nil {
+			return nil, fmt.Errorf("expected 'routes' of service '%s' to be an array: %w", name, err)
+		}
+		if svc.plugins, err = jsonbasics.GetObjectArrayField(entity, "plugins"); err != nil {
+			return nil, fmt.Errorf("expected 'plugins' of service '%s' to be an array: %w", name, err)
+		}
+		services = append(services, svc)
+		for _, ref := range getReference(entity) {
+			servicesByRef[ref] = svc
+		}
+	}
+
+	topLevelRoutes, err := jsonbasics.GetObjectArrayField(deckfile, "routes")
+	if err != nil {
+		return nil, fmt.Errorf("expected 'routes' to be an array: %w", err)
+	}
+	for _, route := range topLevelRoutes {
+		var svc *service
+		for _, ref := range getReference(route["service"]) {
+			if svc == nil {
+				svc = servicesByRef[ref]
+			}
+		}
+		if svc == nil {
+			logbasics.Info("skipping route without a known service", "route", route["name"])
+			continue
+		}
+		svc.routes = append(svc.routes, route)
+	}
+	for _, svc := range services {
+		for _, route := range svc.routes {
+			for _, ref := range getReference(route) {
+				routesByRef[ref] = route
+			}
+			plugins, err := jsonbasics.GetObjectArrayField(route, "plugins")
+			if err != nil {
+				return nil, fmt.Errorf("expected 'plugins' of route '%v' to be an array: %w", route["name"], err)
+			}
+			routePlugins[fmt.Sprintf("%v", route["name"])] = plugins
+		}
+	}
+
+	topLevelPlugins, err := jsonbasics.GetObjectArrayField(deckfile, "plugins")
+	if err != nil {
+		return nil, fmt.Errorf("expected 'plugins' to be an array: %w", err)
+	}
+	for _, plugin := range topLevelPlugins {
+		attached := false
+		for _, ref := range getReference(plugin["route"]) {
+			if route, found := routesByRef[ref]; found && !attached {
+				routeName := fmt.Sprintf("%v", route["name"])
+				routePlugins[routeName] = append(routePlugins[routeName], plugin)
+				attached = true
+			}
+		}
+		for _, ref := range getReference(plugin["service"]) {
+			if svc, found := servicesByRef[ref]; found && !attached {
+				svc.plugins = append(svc.plugins, plugin)
+				attached = true
+			}
+		}
+		if !attached {
+			logbasics.Info("skipping plugin without a known service or route", "plugin", plugin["name"])
+		}
+	}
+
+	for _, svc := range services {
+		var protocolDefaults map[string]interface{}
+		if svc.servers, protocolDefaults, err = getServers(svc.entity, upstreams); err != nil {
+			return nil, err
+		}
+		svc.defaults = copyFields(svc.entity, serviceFields)
+		for key, value := range protocolDefaults {
+			svc.defaults[key] = value
+		}
+		if upstream, found := upstreams[fmt.Sprintf("%v", svc.entity["host"])]; found {
+			svc.upstream = copyFields(upstream, upstreamFields)
+		}
+	}
+
+	//
+	//
+	//  Create the document, the first service is the document level one
+	//
+	//
+
+	docService := services[0]
+	title := opts.Title
+	if title == "" {
+		title = docService.name
+	}
+	if title == "" {
+		title = defaultTitle
+	}
+	version := opts.Version
+	if version == "" {
+		version = defaultVersion
+	}
+
+	doc := map[string]interface{}{
+		"openapi": openapiVersion,
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": version,
+		},
+		"servers": docService.servers,
+	}
+	if docService.name != "" {
+		doc["x-kong-name"] = docService.name
+	}
+	if tags := getCommonTags(services); len(tags) > 0 {
+		doc["x-kong-tags"] = tags
+	}
+	if len(docService.defaults) > 0 {
+		doc["x-kong-service-defaults"] = docService.defaults
+	}
+	if len(docService.upstream) > 0 {
+		doc["x-kong-upstream-defaults"] = docService.upstream
+	}
+	getPluginExtensions(docService.plugins, doc)
+
+	paths := make(map[string]interface{})
+	pathOwners := make(map[string]*service) // the service whose servers are set on the path level
+	componentSchemas := make(map[string]interface{})
+	operationIDs := make(map[string]bool)
+
+	for _, svc := range services {
+		// routes with headers last, such that the fallback routes generated by openapi2kong take precedence
+		routes := slices.Clone(svc.routes)
+		sort.SliceStable(routes, func(i, j int) bool {
+			return routes[i]["headers"] == nil && routes[j]["headers"] != nil
+		})
+
+		for _, route := range routes {
+			routeName := fmt.Sprintf("%v", route["name"])
+			if route["expression"] != nil {
+				logbasics.Info("skipping route using an expression", "route", routeName)
+				continue
+			}
+			routePaths, _ := jsonbasics.GetStringArrayField(route, "paths")
+			if len(routePaths) == 0 {
+				routePaths = []string{"/"}
+			}
+			methods, _ := jsonbasics.GetStringArrayField(route, "methods")
+			if len(methods) == 0 {
+				logbasics.Info("route has no methods, generating operations for all methods", "route", routeName)
+				methods = allMethods
+			}
+
+			routeDefaults := copyFields(route, routeFields)
+			if routeDefaults["strip_path"] == false {
+				delete(routeDefaults, "strip_path") // the openapi2kong default
+			}
+
+			baseOperationID := getOperationID(route, svc)
+			for _, routePath := range routePaths {
+				template, params, err := convertRoutePath(routePath)
+				if err != nil {
+					logbasics.Info("skipping route path", "route", routeName, "error", err.Error())
+					continue
+				}
+
+				pathItem, found := paths[template].(map[string]interface{})
+				if !found {
+					pathItem = make(map[string]interface{})
+					paths[template] = pathItem
+					pathOwners[template] = svc
+					if svc != docService {
+						pathItem["servers"] = svc.servers
+						if len(svc.defaults) > 0 {
+							pathItem["x-kong-service-defaults"] = svc.defaults
+						}
+						if len(svc.upstream) > 0 {
+							pathItem["x-kong-upstream-defaults"] = svc.upstream
+						}
+						getPluginExtensions(svc.plugins, pathItem)
+					}
+				}
+
+				for _, method := range methods {
+					method = strings.ToLower(method)
+					if pathItem[method] != nil {
+						logbasics.Info("skipping duplicate operation", "route", routeName, "method", method,
+							"path", template)
+						continue
+					}
+
+					operationID := baseOperationID
+					if len(methods) > 1 {
+						operationID += "_" + method
+					}
+					for i := 2; operationIDs[operationID]; i++ {
+						operationID = fmt.Sprintf("%s_%d", baseOperationID, i)
+					}
+					operationIDs[operationID] = true
+
+					operation, err := createOperation(routePlugins[routeName], params, componentSchemas)
+					if err != nil {
+						return nil, fmt.Errorf("failed to convert route '%s': %w", routeName, err)
+					}
+					operation["operationId"] = operationID
+					operationDefaults := jsonbasics.DeepCopyObject(routeDefaults)
+					if !strings.HasPrefix(routePath, "~") {
+						// a plain path is a prefix match, while openapi2kong generates an exact match for the
+						// template, so the original path is kept
+						operationDefaults["paths"] = []interface{}{routePath}
+					}
+					if len(operationDefaults) > 0 {
+						operation["x-kong-route-defaults"] = operationDefaults
+					}
+					if owner := pathOwners[template]; owner != svc {
+						// the path belongs to another service, so set this service on the operation
+						operation["servers"] = svc.servers
+						if len(svc.defaults) > 0 {
+							operation["x-kong-service-defaults"] = svc.defaults
+						}
+						if len(svc.upstream) > 0 {
+							operation["x-kong-upstream-defaults"] = svc.upstream
+						}
+						// plugins on operation-level services are added to the routes
+						servicePlugins := make(map[string]interface{})
+						getPluginExtensions(svc.plugins, servicePlugins)
+						for key, value := range servicePlugins {
+							if operation[key] == nil {
+								operation[key] = value
+							}
+						}
+					}
+					pathItem[method] = operation
+				}
+			}
+		}
+	}
+
+	doc["paths"] = paths
+	if len(componentSchemas) > 0 {
+		doc["components"] = map[string]interface{}{
+			"schemas": componentSchemas,
+		}
+	}
+	return doc, nil
+}
+
+// createOperation creates an OAS operation for a route. The request-validator schemas become parameters
+// and a requestBody, and the other plugins 'x-kong-plugin-*' extensions.
+func createOperation(plugins []map[string]interface{}, pathParams []string, componentSchemas map[string]interface{},
+) (map[string]interface{}, error) {
+	operation := map[string]interface{}{
+		"responses": map[string]interface{}{
+			"default": map[string]interface{}{
+				"description": "Default response",
+			},
+		},
+	}
+	getPluginExtensions(plugins, operation)
+
+	parameters := make([]interface{}, 0)
+	for _, plugin := range plugins {
+		if plugin["name"] != validatorPlugin {
+			continue
+		}
+		validator := copyFields(plugin, pluginFields)
+		params, requestBody, err := convertValidator(validator, componentSchemas)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert request-validator: %w", err)
+		}
+		parameters = append(parameters, params...)
+		if requestBody != nil {
+			operation["requestBody"] = requestBody
+		}
+		operation[pluginPrefix+validatorPlugin] = validator
+	}
+
+	// path parameters are required in a spec, so add the ones not defined by the validator
+	for _, name := range pathParams {
+		found := false
+		for _, param := range parameters {
+			p := param.(map[string]interface{})
+			found = found || (p["in"] == "path" && p["name"] == name)
+		}
+		if !found {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	return operation, nil
+}
+
+// getCommonTags returns the tags that all services have in common, in the order of the first service.
+func getCommonTags(services []*service) []interface{} {
+	var common []string
+	for i, svc := range services {
+		tags, _ := jsonbasics.GetStringArrayField(svc.entity, "tags")
+		if i == 0 {
+			common = tags
+			continue
+		}
+		common = slices.DeleteFunc(common, func(tag string) bool { return !slices.Contains(tags, tag) })
+	}
+	result := make([]interface{}, len(common))
+	for i, tag := range common {
+		result[i] = tag
+	}
+	return result
+}
@@ -0,0 +1,315 @@
+package kong2openapi_test
+
+import (
+	"encoding/json"
+
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/kong2openapi"
+	"github.com/kong/go-apiops/openapi2kong"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// convert converts the deck file (yaml) into a spec, and returns it as plain JSON data
+func convert(data string, opts kong2openapi.K2OOptions) map[string]interface{} {
+	spec, err := kong2openapi.Convert(filebasics.MustDeserialize([]byte(data)), opts)
+	Expect(err).ToNot(HaveOccurred())
+	specJSON, err := json.Marshal(spec)
+	Expect(err).ToNot(HaveOccurred())
+	var result map[string]interface{}
+	Expect(json.Unmarshal(specJSON, &result)).To(Succeed())
+	return result
+}
+
+// get returns the value at the path of keys in the data
+func get(data interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		object, ok := data.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		data = object[key]
+	}
+	return data
+}
+
+var _ = Describe("kong2openapi", func() {
+	Describe("Convert", func() {
+		It("converts services to servers, and routes to operations", func() {
+			spec := convert(`
+services:
+- name: petstore
+  host: pets.example.com
+  port: 8080
+  protocol: http
+  path: /v1
+  retries: 3
+  tags: [team-a, pets]
+  routes:
+  - name: petstore_list-pets
+    paths: [/pets]
+    methods: [GET]
+    strip_path: false
+  - name: petstore_get-pet
+    paths: ['~/pets/(?<petId>[^#?/]+)$']
+    methods: [GET, DELETE]
+    preserve_host: true
+`, kong2openapi.K2OOptions{})
+
+			Expect(get(spec, "openapi")).To(Equal("3.0.3"))
+			Expect(get(spec, "info")).To(Equal(map[string]interface{}{"title": "petstore", "version": "1.0.0"}))
+			Expect(get(spec, "servers")).To(Equal([]interface{}{
+				map[string]interface{}{"url": "http://pets.example.com:8080/v1"},
+			}))
+			Expect(get(spec, "x-kong-name")).To(Equal("petstore"))
+			Expect(get(spec, "x-kong-tags")).To(Equal([]interface{}{"team-a", "pets"}))
+			Expect(get(spec, "x-kong-service-defaults")).To(Equal(map[string]interface{}{"retries": float64(3)}))
+
+			Expect(get(spec, "paths", "/pets", "get", "operationId")).To(Equal("list-pets"))
+			// the plain path is a prefix match, so it is kept
+			Expect(get(spec, "paths", "/pets", "get", "x-kong-route-defaults")).To(Equal(
+				map[string]interface{}{"paths": []interface{}{"/pets"}}))
+			Expect(get(spec, "paths", "/pets/{petId}", "get", "operationId")).To(Equal("get-pet_get"))
+			Expect(get(spec, "paths", "/pets/{petId}", "delete", "operationId")).To(Equal("get-pet_delete"))
+			Expect(get(spec, "paths", "/pets/{petId}", "get", "x-kong-route-defaults")).To(Equal(
+				map[string]interface{}{"preserve_host": true}))
+			Expect(get(spec, "paths", "/pets/{petId}", "get", "parameters")).To(Equal([]interface{}{
+				map[string]interface{}{
+					"name":     "petId",
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				},
+			}))
+		})
+
+		It("converts upstream targets to servers", func() {
+			spec := convert(`
+services:
+- name: api
+  host: api.upstream
+  protocol: https
+  routes:
+  - name: ping
+    paths: [/ping]
+    methods: [GET]
+upstreams:
+- name: api.upstream
+  slots: 1000
+  targets:
+  - target: one.example.com:443
+  - target: two.example.com:8443
+`, kong2openapi.K2OOptions{Title: "My API", Version: "2.0.0"})
+
+			Expect(get(spec, "info")).To(Equal(map[string]interface{}{"title": "My API", "version": "2.0.0"}))
+			Expect(get(spec, "servers")).To(Equal([]interface{}{
+				map[string]interface{}{"url": "https://one.example.com"},
+				map[string]interface{}{"url": "https://two.example.com:8443"},
+			}))
+			Expect(get(spec, "x-kong-upstream-defaults")).To(Equal(map[string]interface{}{"slots": float64(1000)}))
+		})
+
+		It("converts request-validator schemas to parameters and request bodies", func() {
+			spec := convert(`
+services:
+- name: api
+  url: https://api.example.com
+  routes:
+  - name: create-pet
+    paths: [/pets]
+    methods: [POST]
+    plugins:
+    - name: request-validator
+      config:
+        version: draft4
+        verbose_response: true
+        allowed_content_types: [application/json]
+        body_schema: '{"$ref":"#/definitions/Pet","definitions":{"Pet":{"type":"object"}}}'
+        parameter_schema:
+        - name: dryRun
+          in: query
+          required: false
+          style: form
+          explode: true
+          schema: '{"type":"boolean"}'
+`, kong2openapi.K2OOptions{})
+
+			operation := get(spec, "paths", "/pets", "post")
+			Expect(get(operation, "parameters")).To(Equal([]interface{}{
+				map[string]interface{}{
+					"name":     "dryRun",
+					"in":       "query",
+					"required": false,
+					"style":    "form",
+					"explode":  true,
+					"schema":   map[string]interface{}{"type": "boolean"},
+				},
+			}))
+			Expect(get(operation, "requestBody")).To(Equal(map[string]interface{}{
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": "#/components/schemas/Pet"},
+					},
+				},
+			}))
+			Expect(get(spec, "components", "schemas", "Pet")).To(Equal(map[string]interface{}{"type": "object"}))
+			// the remaining config is kept, such that openapi2kong generates the schemas again
+			Expect(get(operation, "x-kong-plugin-request-validator")).To(Equal(map[string]interface{}{
+				"config": map[string]interface{}{"verbose_response": true},
+			}))
+		})
+
+		It("keeps plugins as extensions, including top-level ones", func() {
+			spec := convert(`
+services:
+- name: api
+  host: api.example.com
+  plugins:
+  - name: cors
+    id: 4bd9d4ff-7e48-4b4a-a25b-6b3e1c6a7a2e
+    tags: [x]
+  routes:
+  - name: ping
+    paths: [/ping]
+    methods: [GET]
+plugins:
+- name: rate-limiting
+  route: ping
+  config:
+    minute: 10
+- name: file-log
+  config:
+    path: /tmp/log
+`, kong2openapi.K2OOptions{})
+
+			Expect(get(spec, "x-kong-plugin-cors")).To(Equal(map[string]interface{}{}))
+			Expect(get(spec, "paths", "/ping", "get", "x-kong-plugin-rate-limiting")).To(Equal(
+				map[string]interface{}{"config": map[string]interface{}{"minute": float64(10)}}))
+			// global plugins have no place in the spec
+			Expect(get(spec, "x-kong-plugin-file-log")).To(BeNil())
+		})
+
+		It("sets the servers of other services on the path or operation", func() {
+			spec := convert(`
+services:
+- name: main
+  host: main.example.com
+  routes:
+  - name: list-pets
+    paths: [/pets]
+    methods: [GET]
+- name: other
+  host: other.example.com
+  plugins:
+  - name: key-auth
+  routes:
+  - name: create-pet
+    paths: [/pets]
+    methods: [POST]
+  - name: list-users
+    paths: [/users]
+    methods: [GET]
+`, kong2openapi.K2OOptions{})
+
+			Expect(get(spec, "paths", "/users", "servers")).To(Equal([]interface{}{
+				map[string]interface{}{"url": "http://other.example.com"},
+			}))
+			Expect(get(spec, "paths", "/users", "x-kong-plugin-key-auth")).To(Equal(map[string]interface{}{}))
+			Expect(get(spec, "paths", "/pets", "servers")).To(BeNil())
+			Expect(get(spec, "paths", "/pets", "post", "servers")).To(Equal([]interface{}{
+				map[string]interface{}{"url": "http://other.example.com"},
+			}))
+			Expect(get(spec, "paths", "/pets", "post", "x-kong-plugin-key-auth")).To(Equal(map[string]interface{}{}))
+		})
+
+		It("skips routes that cannot be represented", func() {
+			spec := convert(`
+services:
+- name: api
+  host: api.example.com
+  routes:
+  - name: expression
+    expression: http.path == "/a"
+  - name: complex
+    paths: ['~/files/.*\.json$']
+    methods: [GET]
+  - name: plain
+    paths: [/plain]
+`, kong2openapi.K2OOptions{})
+
+			Expect(get(spec, "paths")).To(HaveLen(1))
+			// no methods, so all methods are generated
+			Expect(get(spec, "paths", "/plain")).To(HaveLen(7))
+		})
+
+		It("returns an error without services", func() {
+			_, err := kong2openapi.Convert(filebasics.MustDeserialize([]byte(`routes: []`)), kong2openapi.K2OOptions{})
+			Expect(err).To(MatchError("the deck file has no services"))
+		})
+
+		It("round-trips through openapi2kong", func() {
+			deckfile := `
+services:
+- name: petstore
+  host: pets.example.com
+  port: 443
+  protocol: https
+  path: /
+  routes:
+  - name: petstore_list-pets
+    paths: [/pets]
+    methods: [GET]
+    strip_path: false
+  - name: petstore_get-pet
+    paths: ['~/pets/(?<petid>[^#?/]+)$']
+    methods: [GET]
+    strip_path: false
+    plugins:
+    - name: request-validator
+      config:
+        version: draft4
+        parameter_schema:
+        - name: petid
+          in: path
+          required: true
+          style: simple
+          explode: false
+          schema: '{"type":"integer"}'
+`
+			spec := convert(deckfile, kong2openapi.K2OOptions{})
+			specJSON, err := json.Marshal(spec)
+			Expect(err).ToNot(HaveOccurred())
+			result, err := openapi2kong.Convert(specJSON, openapi2kong.O2kOptions{SkipID: true})
+			Expect(err).ToNot(HaveOccurred())
+
+			services := result["services"].([]interface{})
+			Expect(services).To(HaveLen(1))
+			service := services[0].(map[string]interface{})
+			Expect(service["name"]).To(Equal("petstore"))
+			Expect(service["host"]).To(Equal("pets.example.com"))
+			Expect(service["port"]).To(BeEquivalentTo(443))
+			Expect(service["protocol"]).To(Equal("https"))
+			Expect(service["path"]).To(Equal("/"))
+
+			routes := service["routes"].([]interface{})
+			Expect(routes).To(HaveLen(2))
+			names := make([]string, 0)
+			for _, route := range routes {
+				r := route.(map[string]interface{})
+				names = append(names, r["name"].(string))
+				if r["name"] == "petstore_list-pets" {
+					// still a prefix match
+					Expect(r["paths"]).To(Equal([]interface{}{"/pets"}))
+				}
+				if r["name"] == "petstore_get-pet" {
+					Expect(r["paths"]).To(Equal([]string{"~/pets/(?<petid>[^#?/]+)$"}))
+					plugins := *(r["plugins"].(*[]*map[string]interface{}))
+					Expect(plugins).To(HaveLen(1))
+					config := (*plugins[0])["config"].(map[string]interface{})
+					Expect(config["parameter_schema"]).To(HaveLen(1))
+				}
+			}
+			Expect(names).To(ConsistOf("petstore_list-pets", "petstore_get-pet"))
+		})
+	})
+})
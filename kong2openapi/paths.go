@@ -0,0 +1,79 @@
+package kong2openapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// regexMetaChars are the characters that have a special meaning in a regex path, if not escaped
+const regexMetaChars = `.*+?[](){}|^$`
+
+// convertRoutePath converts a Kong route path into an OAS path template, and returns the names of its
+// path parameters. Plain paths are returned as is. For regex paths ("~" prefixed) the named captures
+// become parameters, eg. "~/pets/(?<id>[^#?/]+)$" becomes "/pets/{id}". Returns an error if the regex
+// has other constructs, since those cannot be represented as a template.
+func convertRoutePath(path string) (string, []string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil, nil
+	}
+
+	input := []rune(strings.TrimPrefix(strings.TrimPrefix(path, "~"), "^"))
+	if len(input) > 0 && input[len(input)-1] == '$' && (len(input) < 2 || input[len(input)-2] != '\\') {
+		input = input[:len(input)-1]
+	}
+
+	var template strings.Builder
+	params := make([]string, 0)
+	for i := 0; i < len(input); i++ {
+		char := input[i]
+		switch {
+		case char == '\\':
+			if i+1 >= len(input) {
+				return "", nil, fmt.Errorf("unsupported regex path '%s': trailing '\\'", path)
+			}
+			i++
+			template.WriteRune(input[i])
+
+		case char == '(':
+			rest := string(input[i+1:])
+			if !strings.HasPrefix(rest, "?<") && !strings.HasPrefix(rest, "?P<") {
+				return "", nil, fmt.Errorf("unsupported regex path '%s': only named captures are supported", path)
+			}
+			nameEnd := strings.IndexRune(rest, '>')
+			if nameEnd == -1 {
+				return "", nil, fmt.Errorf("unsupported regex path '%s': unterminated capture name", path)
+			}
+			name := rest[strings.IndexRune(rest, '<')+1 : nameEnd]
+
+			// skip to the end of the capture, the pattern of the capture itself is dropped
+			depth := 0
+			end := -1
+			for j := i + 1; j < len(input) && end == -1; j++ {
+				switch input[j] {
+				case '\\':
+					j++
+				case '(':
+					depth++
+				case ')':
+					if depth == 0 {
+						end = j
+					}
+					depth--
+				}
+			}
+			if end == -1 {
+				return "", nil, fmt.Errorf("unsupported regex path '%s': unbalanced '('", path)
+			}
+			template.WriteString("{" + name + "}")
+			params = append(params, name)
+			i = end
+
+		case strings.ContainsRune(regexMetaChars, char):
+			return "", nil, fmt.Errorf("unsupported regex path '%s': '%c' is not supported", path, char)
+
+		default:
+			template.WriteRune(char)
+		}
+	}
+	return template.String(), params, nil
+}
@@ -0,0 +1,130 @@
+package kong2openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/kong/go-apiops/jsonbasics"
+)
+
+const (
+	validatorPlugin = "request-validator"
+	// the default JSONschema version of the request-validator, as generated by openapi2kong
+	validatorDefaultVersion = "draft4"
+	definitionsRef          = "#/definitions/"
+	componentsSchemasRef    = "#/components/schemas/"
+)
+
+// validatorSchemaFields are the request-validator config fields that are converted into the spec, openapi2kong
+// generates them from the spec again.
+var validatorSchemaFields = []string{"parameter_schema", "body_schema", "allowed_content_types"}
+
+// rewriteDefinitionRefs updates all references to "#/definitions/" in place, to point to the
+// "#/components/schemas/" of the spec.
+func rewriteDefinitionRefs(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, sub := range v {
+			if ref, ok := sub.(string); ok && key == "$ref" && strings.HasPrefix(ref, definitionsRef) {
+				v[key] = componentsSchemasRef + strings.TrimPrefix(ref, definitionsRef)
+			} else {
+				rewriteDefinitionRefs(sub)
+			}
+		}
+	case []interface{}:
+		for _, sub := range v {
+			rewriteDefinitionRefs(sub)
+		}
+	}
+}
+
+// parseSchema parses a JSONschema string from the request-validator. Its definitions are moved into
+// the component schemas, and the references updated accordingly.
+func parseSchema(schemaJSON string, componentSchemas map[string]interface{}) (map[string]interface{}, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(schemaJSON), &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	if definitions, ok := schema["definitions"].(map[string]interface{}); ok {
+		for name, definition := range definitions {
+			rewriteDefinitionRefs(definition)
+			componentSchemas[name] = definition
+		}
+		delete(schema, "definitions")
+	}
+	rewriteDefinitionRefs(schema)
+	return schema, nil
+}
+
+// isJSONContentType returns true for JSON content types, eg. "application/problem+json".
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// convertValidator converts the schemas of a request-validator plugin into OAS parameters, and a requestBody
+// (nil if there is none). The plugin is updated in place, to only hold the remaining configuration, such
+// that openapi2kong generates the schemas from the spec again.
+func convertValidator(plugin map[string]interface{}, componentSchemas map[string]interface{},
+) ([]interface{}, map[string]interface{}, error) {
+	config, _ := jsonbasics.ToObject(plugin["config"])
+	if config == nil {
+		return nil, nil, nil
+	}
+
+	parameters := make([]interface{}, 0)
+	paramSchemas, err := jsonbasics.GetObjectArrayField(config, "parameter_schema")
+	if err != nil {
+		return nil, nil, fmt.Errorf("expected 'parameter_schema' to be an array: %w", err)
+	}
+	for _, paramSchema := range paramSchemas {
+		parameter := make(map[string]interface{})
+		for _, field := range []string{"name", "in", "required", "style", "explode"} {
+			if paramSchema[field] != nil {
+				parameter[field] = paramSchema[field]
+			}
+		}
+		if schemaJSON, ok := paramSchema["schema"].(string); ok {
+			if parameter["schema"], err = parseSchema(schemaJSON, componentSchemas); err != nil {
+				return nil, nil, fmt.Errorf("parameter '%v': %w", parameter["name"], err)
+			}
+		}
+		parameters = append(parameters, parameter)
+	}
+
+	var requestBody map[string]interface{}
+	bodySchema, _ := config["body_schema"].(string)
+	contentTypes, _ := jsonbasics.GetStringArrayField(config, "allowed_content_types")
+	if bodySchema != "" && bodySchema != "{}" || len(contentTypes) > 0 {
+		if len(contentTypes) == 0 {
+			contentTypes = []string{"application/json"}
+		}
+		content := make(map[string]interface{})
+		for _, contentType := range contentTypes {
+			mediaType := make(map[string]interface{})
+			if bodySchema != "" && bodySchema != "{}" && isJSONContentType(contentType) {
+				// the body schema only applies to JSON bodies
+				if mediaType["schema"], err = parseSchema(bodySchema, componentSchemas); err != nil {
+					return nil, nil, fmt.Errorf("body schema: %w", err)
+				}
+			}
+			content[contentType] = mediaType
+		}
+		requestBody = map[string]interface{}{
+			"content": content,
+		}
+	}
+
+	for _, field := range validatorSchemaFields {
+		delete(config, field)
+	}
+	if config["version"] == validatorDefaultVersion {
+		delete(config, "version")
+	}
+	return parameters, requestBody, nil
+}
@@ -4,7 +4,7 @@
 //
 // General behaviour;
 // * Errors will not be logged, but returned instead. Logging those is up to the caller.
-// * The library does not use verbosity level 0
+// * level 0 is only used for warnings (when calling `Warn`), which are always shown
 // * level 1 is used for informational messages (when calling `Info`)
 // * level 2 is used for debug messages (when calling `Debug`)
 package logbasics
@@ -22,6 +22,12 @@ var (
 	defaultLogger *logr.Logger
 )
 
+// Warn logs a warning message ("info" at verbosity level 0), for problems the user should act on,
+// but that do not fail the operation.
+func Warn(msg string, keysAndValues ...interface{}) {
+	globalLogger.V(0).Info("WARNING: "+msg, keysAndValues...)
+}
+
 // Info logs an informational message ("info" at verbosity level 1).
 func Info(msg string, keysAndValues ...interface{}) {
 	globalLogger.V(1).Info(msg, keysAndValues...)
@@ -0,0 +1,273 @@
+package openapi2kong
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/kong/go-apiops/jsonbasics"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/routeconflicts"
+)
+
+// batchResult is the result of converting a single document in a batch.
+type batchResult struct {
+	result  map[string]interface{}
+	origins map[string]routeconflicts.Origin // the OAS operations of the routes, by route name
+	err     error
+}
+
+// getBatchOptions returns the options for converting a single document in a batch. Relative file
+// references are resolved from the directory of the document, the names are taken as paths relative
+// to the BasePath or FS. The DocumentPath is taken as a directory, holding the document by its filename.
+func getBatchOptions(name string, opts O2kOptions) (O2kOptions, error) {
+	if opts.DocumentPath != "" {
+		opts.DocumentPath = path.Join(opts.DocumentPath, path.Base(filepath.ToSlash(name)))
+	}
+	if opts.FS != nil {
+		subFS, err := fs.Sub(opts.FS, path.Dir(filepath.ToSlash(name)))
+		if err != nil {
+			return opts, err
+		}
+		opts.FS = subFS
+	}
+	if opts.BasePath != "" {
+		if filepath.IsAbs(name) {
+			opts.BasePath = filepath.Dir(name)
+		} else {
+			opts.BasePath = filepath.Join(opts.BasePath, filepath.Dir(name))
+		}
+	}
+	return opts, nil
+}
+
+// entityRegistry tracks the names and IDs of the entities in a batch, by the document that generated them.
+type entityRegistry struct {
+	owners map[string]string // the document name, by "<entity-type> name '<name>'", or "id '<id>'"
+	errs   []error           // the collisions found
+}
+
+// register records the entity for the document, and records an error if it was generated by another document.
+func (r *entityRegistry) register(entity string, object map[string]interface{}, document string) {
+	nameKey := "name"
+	if entity == "consumer" {
+		nameKey = "username"
+	}
+	keys := make([]string, 0, 2)
+	if name, ok := object[nameKey].(string); ok {
+		keys = append(keys, fmt.Sprintf("%s %s '%s'", entity, nameKey, name))
+	}
+	if id, ok := object["id"].(string); ok && id != "" {
+		keys = append(keys, fmt.Sprintf("id '%s'", id))
+	}
+	for _, key := range keys {
+		if owner, found := r.owners[key]; found && owner != document {
+			r.errs = append(r.errs, fmt.Errorf("%s is generated by both '%s' and '%s'", key, owner, document))
+		} else {
+			r.owners[key] = document
+		}
+	}
+}
+
+// mergeTags returns the union of the tag lists, in order of appearance. Returns nil if there are no tags.
+func mergeTags(lists ...interface{}) []string {
+	var merged []string
+	for _, list := range lists {
+		tags, _ := list.([]string)
+		for _, tag := range tags {
+			if !slices.Contains(merged, tag) {
+				merged = append(merged, tag)
+			}
+		}
+	}
+	return merged
+}
+
+// registerPlugins records the plugins in the list for the document.
+func (r *entityRegistry) registerPlugins(list interface{}, document string) {
+	for _, plugin := range getObjectList(list) {
+		// plugin names are not unique, only check the IDs
+		if id, ok := plugin["id"].(string); ok && id != "" {
+			r.register("plugin", map[string]interface{}{"id": id}, document)
+		}
+	}
+}
+
+// checkBatchRouteConflicts analyzes the routes of the merged result for conflicts between routes of
+// different documents, conflicts within a document have already been reported when converting it.
+func checkBatchRouteConflicts(
+	result map[string]interface{},
+	origins map[string]routeconflicts.Origin,
+	serviceDocuments map[string]string,
+	failOnConflicts bool,
+) error {
+	// the analyzer works on plain JSON data, so convert the typed result
+	conflicts, err := routeconflicts.Analyze(jsonbasics.DeepCopyObject(result), origins)
+	if err != nil {
+		return fmt.Errorf("failed to analyze the routes for conflicts: %w", err)
+	}
+
+	errs := make([]error, 0)
+	for _, conflict := range conflicts {
+		document, other := serviceDocuments[conflict.Route.Service], serviceDocuments[conflict.Other.Service]
+		if document == other {
+			continue
+		}
+		message := fmt.Sprintf("%s (from '%s' and '%s')", conflict.String(), document, other)
+		logbasics.Info("route conflict: " + message)
+		errs = append(errs, errors.New(message))
+	}
+	if failOnConflicts && len(errs) > 0 {
+		return fmt.Errorf("found %d route conflicts between documents: %w", len(errs), errors.Join(errs...))
+	}
+	return nil
+}
+
+// ConvertAll converts multiple OpenAPI specs, by name (eg. the filename), into a single Kong declarative
+// file. The specs are converted concurrently, using the same options, and the same UUID namespace. The
+// entities are named after their own document ('x-kong-name' or 'info.title'), so a DocName cannot be set.
+// Fails if documents generate entities with the same name or ID, except for consumer groups, which are
+// shared by name, with the tags of all documents. Route conflicts between documents are reported like route
+// conflicts within a document.
+// If the BasePath or FS is set, then the names are taken as paths relative to it, and relative references
+// are resolved from the directory of each spec. The DocumentPath is the directory of the specs in the
+// developer portal, the filenames of the specs are appended to it. The IDPins apply to the merged result.
+func ConvertAll(specs map[string][]byte, opts O2kOptions) (map[string]interface{}, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("no specs to convert")
+	}
+	if opts.DocName != "" {
+		return nil, errors.New("a document name cannot be used when converting multiple specs, " +
+			"since every document needs its own")
+	}
+	if opts.SkipID && len(opts.IDPins) > 0 {
+		return nil, errors.New("an id strategy, or id pins, cannot be combined with skipping ids")
+	}
+	pins := opts.IDPins
+	opts.IDPins = nil // a pin may refer to an entity of any document
+
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// convert concurrently, the results are kept in the order of the names
+	results := make([]batchResult, len(names))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.GOMAXPROCS(0), len(names)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				logbasics.Info("converting document", "name", names[i])
+				docOpts, err := getBatchOptions(names[i], opts)
+				if err != nil {
+					results[i].err = err
+					continue
+				}
+				results[i].origins = make(map[string]routeconflicts.Origin)
+				results[i].result, results[i].err = convert(specs[names[i]], docOpts, nil, results[i].origins)
+			}
+		}()
+	}
+	for i := range names {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	errs := make([]error, 0)
+	for i, result := range results {
+		if result.err != nil {
+			errs = append(errs, fmt.Errorf("failed to convert '%s': %w", names[i], result.err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// merge the results, and check for colliding entities
+	var (
+		services         = make([]interface{}, 0)
+		upstreams        = make([]interface{}, 0)
+		consumers        = make([]interface{}, 0)
+		consumerGroups   = make([]interface{}, 0)
+		plugins          = make([]*map[string]interface{}, 0)
+		sharedGroups     = make(map[string]map[string]interface{}) // the consumer groups, by name
+		serviceDocuments = make(map[string]string)                 // the document by service name
+		origins          = make(map[string]routeconflicts.Origin)
+		registry         = &entityRegistry{owners: make(map[string]string)}
+	)
+	for i, batch := range results {
+		document := names[i]
+		for _, service := range getObjectList(batch.result["services"]) {
+			registry.register("service", service, document)
+			registry.registerPlugins(service["plugins"], document)
+			for _, route := range getObjectList(service["routes"]) {
+				registry.register("route", route, document)
+				registry.registerPlugins(route["plugins"], document)
+			}
+			serviceDocuments[service["name"].(string)] = document
+			services = append(services, service)
+		}
+		for _, upstream := range getObjectList(batch.result["upstreams"]) {
+			registry.register("upstream", upstream, document)
+			upstreams = append(upstreams, upstream)
+		}
+		for _, consumer := range getObjectList(batch.result["consumers"]) {
+			registry.register("consumer", consumer, document)
+			consumers = append(consumers, consumer)
+		}
+		for _, group := range getObjectList(batch.result["consumer_groups"]) {
+			// groups are shared by the documents, their IDs are based on the name only
+			name := group["name"].(string)
+			if shared, found := sharedGroups[name]; found {
+				shared["tags"] = mergeTags(shared["tags"], group["tags"])
+				continue
+			}
+			sharedGroups[name] = group
+			registry.register("consumer_group", group, document)
+			consumerGroups = append(consumerGroups, group)
+		}
+		registry.registerPlugins(batch.result["plugins"], document)
+		for _, plugin := range getObjectList(batch.result["plugins"]) {
+			plugins = append(plugins, &plugin)
+		}
+		for name, origin := range batch.origins {
+			origins[name] = origin
+		}
+	}
+	if len(registry.errs) > 0 {
+		return nil, fmt.Errorf("the documents generate colliding entities: %w", errors.Join(registry.errs...))
+	}
+
+	result := make(map[string]interface{})
+	result[formatVersionKey] = formatVersionValue
+	result["services"] = services
+	result["upstreams"] = upstreams
+	if len(consumers) > 0 {
+		result["consumers"] = consumers
+	}
+	if len(consumerGroups) > 0 {
+		result["consumer_groups"] = consumerGroups
+	}
+	if len(plugins) > 0 {
+		result["plugins"] = &plugins
+	}
+
+	if err := applyIDPins(result, pins); err != nil {
+		return nil, err
+	}
+	if err := checkBatchRouteConflicts(result, origins, serviceDocuments, opts.FailOnRouteConflicts); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
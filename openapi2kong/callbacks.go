@@ -0,0 +1,238 @@
+package openapi2kong
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-apiops/openapitools"
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"github.com/pb33f/libopenapi/orderedmap"
+	"go.yaml.in/yaml/v4"
+)
+
+// the document level extension configuring the service for the callbacks and webhooks
+const callbacksExtension = "x-kong-callbacks"
+
+// callbackReceiver is a callback (per expression) or webhook, for which routes are generated that
+// receive the requests made by the API.
+type callbackReceiver struct {
+	name     string       // the name; x-kong-name, or the callback/webhook name
+	path     string       // the OAS path template to receive on
+	pathItem *v3.PathItem // the operations of the callback
+	pointer  string       // JSON pointer to the path item in the document
+}
+
+// runtimeExpression matches the runtime expressions in a callback expression, eg. "{$request.body#/url}"
+var runtimeExpression = regexp.MustCompile(`\{\$[^}]*\}`)
+
+// getCallbackPath returns the path to receive a callback on, from its expression. A leading runtime
+// expression (the URL provided by the client) is dropped, as well as scheme and host. Embedded runtime
+// expressions become path parameters. If no path remains, then "/<name>" is used.
+func getCallbackPath(expression string, name string, insoCompat bool) string {
+	path := expression
+	if loc := runtimeExpression.FindStringIndex(path); loc != nil && loc[0] == 0 {
+		path = path[loc[1]:]
+	} else if u, err := url.Parse(path); err == nil && u.Scheme != "" {
+		path = u.Path
+	}
+	path, _, _ = strings.Cut(path, "?")
+
+	path = runtimeExpression.ReplaceAllStringFunc(path, func(expr string) string {
+		// use the last element of the expression as the parameter name, eg. "id" for "{$request.path.id}"
+		expr = strings.Trim(expr, "{}")
+		name := expr[strings.LastIndexAny(expr, "$./#")+1:]
+		return "{" + openapitools.SanitizeRegexCapture(name, insoCompat) + "}"
+	})
+
+	if path == "" || path == "/" {
+		return "/" + openapitools.Slugify(insoCompat, name)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// getCallbackName returns the name for a callback from the x-kong-name of its path item, or the given name.
+func getCallbackName(pathItem *v3.PathItem, name string) (string, error) {
+	kongName, err := getKongName(pathItem.Extensions)
+	if err != nil {
+		return "", err
+	}
+	if kongName != "" {
+		return kongName, nil
+	}
+	return name, nil
+}
+
+// collectCallbackReceivers returns the webhooks, and the callbacks of all operations, in a deterministic
+// order. Returns an error if the names are not unique.
+func collectCallbackReceivers(doc v3.Document, insoCompat bool) ([]callbackReceiver, error) {
+	receivers := make([]callbackReceiver, 0)
+
+	if doc.Webhooks != nil {
+		for pair := doc.Webhooks.First(); pair != nil; pair = pair.Next() {
+			name, err := getCallbackName(pair.Value(), pair.Key())
+			if err != nil {
+				return nil, err
+			}
+			receivers = append(receivers, callbackReceiver{
+				name:     name,
+				path:     "/" + openapitools.Slugify(insoCompat, name),
+				pathItem: pair.Value(),
+				pointer:  "#/webhooks/" + escapeJSONPointer(pair.Key()),
+			})
+		}
+	}
+
+	if doc.Paths != nil {
+		for pathPair := doc.Paths.PathItems.First(); pathPair != nil; pathPair = pathPair.Next() {
+			for opPair := pathPair.Value().GetOperations().First(); opPair != nil; opPair = opPair.Next() {
+				callbacks := opPair.Value().Callbacks
+				if callbacks == nil {
+					continue
+				}
+				for cbPair := callbacks.First(); cbPair != nil; cbPair = cbPair.Next() {
+					if cbPair.Value() == nil || cbPair.Value().Expression == nil {
+						continue
+					}
+					for exprPair := cbPair.Value().Expression.First(); exprPair != nil; exprPair = exprPair.Next() {
+						name, err := getCallbackName(exprPair.Value(), cbPair.Key())
+						if err != nil {
+							return nil, err
+						}
+						receivers = append(receivers, callbackReceiver{
+							name:     name,
+							path:     getCallbackPath(exprPair.Key(), name, insoCompat),
+							pathItem: exprPair.Value(),
+							pointer: "#/paths/" + escapeJSONPointer(pathPair.Key()) + "/" + opPair.Key() +
+								"/callbacks/" + escapeJSONPointer(cbPair.Key()) + "/" + escapeJSONPointer(exprPair.Key()),
+						})
+					}
+				}
+			}
+		}
+	}
+
+	// the names are used for the routes, so they must be unique once slugified
+	sort.SliceStable(receivers, func(i, j int) bool {
+		return openapitools.Slugify(insoCompat, receivers[i].name) < openapitools.Slugify(insoCompat, receivers[j].name)
+	})
+	for i := 1; i < len(receivers); i++ {
+		if openapitools.Slugify(insoCompat, receivers[i].name) == openapitools.Slugify(insoCompat, receivers[i-1].name) {
+			return nil, fmt.Errorf("duplicate callback name '%s'; use 'x-kong-name' on the callback path item "+
+				"to make it unique", receivers[i].name)
+		}
+	}
+	return receivers, nil
+}
+
+// getCallbacksExtensions returns the directives in the document level 'x-kong-callbacks' object; its
+// 'servers', service/upstream defaults, and plugins. Returns nil if the object is not set.
+func getCallbacksExtensions(doc v3.Document) (*orderedmap.Map[string, *yaml.Node], error) {
+	if doc.Extensions == nil {
+		return nil, nil
+	}
+	node, ok := doc.Extensions.Get(callbacksExtension)
+	if !ok || node == nil {
+		return nil, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected '%s' to be an object", callbacksExtension)
+	}
+	extensions := orderedmap.New[string, *yaml.Node]()
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		extensions.Set(node.Content[i].Value, node.Content[i+1])
+	}
+	return extensions, nil
+}
+
+// getCallbacksServers returns the 'servers' from the 'x-kong-callbacks' directives, in the same format
+// as an OAS servers block. Returns nil if not set.
+func getCallbacksServers(extensions *orderedmap.Map[string, *yaml.Node]) ([]*v3.Server, error) {
+	if extensions == nil {
+		return nil, nil
+	}
+	node, ok := extensions.Get("servers")
+	if !ok || node == nil {
+		return nil, nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("expected '%s.servers' to be an array", callbacksExtension)
+	}
+	servers := make([]*v3.Server, 0, len(node.Content))
+	for i, entry := range node.Content {
+		var server struct {
+			URL       string    `yaml:"url"`
+			Variables yaml.Node `yaml:"variables"`
+		}
+		if entry.Kind != yaml.MappingNode || entry.Decode(&server) != nil || server.URL == "" {
+			return nil, fmt.Errorf("expected '%s.servers[%d]' to be an object with a 'url'", callbacksExtension, i)
+		}
+		variables := orderedmap.New[string, *v3.ServerVariable]()
+		if server.Variables.Kind != 0 && server.Variables.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("expected '%s.servers[%d].variables' to be an object", callbacksExtension, i)
+		}
+		for j := 0; j+1 < len(server.Variables.Content); j += 2 {
+			variable := &v3.ServerVariable{}
+			if err := server.Variables.Content[j+1].Decode(variable); err != nil {
+				return nil, fmt.Errorf("expected '%s.servers[%d].variables.%s' to be a server variable object",
+					callbacksExtension, i, server.Variables.Content[j].Value)
+			}
+			variables.Set(server.Variables.Content[j].Value, variable)
+		}
+		servers = append(servers, &v3.Server{URL: server.URL, Variables: variables})
+	}
+	return servers, nil
+}
+
+// createCallbacksService creates the service receiving the callbacks and webhooks. The 'servers' and
+// service/upstream defaults in 'x-kong-callbacks' take precedence over the document level ones. An
+// upstream is only returned if 'x-kong-callbacks' has its own servers or upstream defaults, otherwise
+// the service host is left empty, to be set to the document level host by the caller. Plugins are not
+// added. Also returns the 'x-kong-callbacks' directives, if any.
+func createCallbacksService(baseName string, doc v3.Document, docServiceDefaults []byte,
+	docUpstreamDefaults []byte, components *map[string]interface{}, tags []string, opts O2kOptions,
+) (map[string]interface{}, map[string]interface{}, *orderedmap.Map[string, *yaml.Node], error) {
+	extensions, err := getCallbacksExtensions(doc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	servers, err := getCallbacksServers(extensions)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	serviceDefaults, err := getServiceDefaults(extensions, components)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if serviceDefaults == nil {
+		serviceDefaults = docServiceDefaults
+	}
+	upstreamDefaults, err := getUpstreamDefaults(extensions, components)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	newUpstream := len(servers) > 0 || upstreamDefaults != nil
+	if upstreamDefaults == nil {
+		upstreamDefaults = docUpstreamDefaults
+	}
+	if len(servers) == 0 {
+		servers = doc.Servers
+	}
+
+	service, upstream, err := openapitools.CreateKongService(baseName, servers, serviceDefaults,
+		upstreamDefaults, tags, opts.UUIDNamespace, opts.SkipID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if upstream != nil && !newUpstream {
+		// the document level upstream is used
+		upstream = nil
+		delete(service, "host")
+	}
+	return service, upstream, extensions, nil
+}
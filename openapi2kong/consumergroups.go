@@ -0,0 +1,154 @@
+package openapi2kong
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kong/go-apiops/logbasics"
+	openapibase "github.com/pb33f/libopenapi/datamodel/high/base"
+	"github.com/pb33f/libopenapi/orderedmap"
+	"go.yaml.in/yaml/v4"
+)
+
+// consumerGroupsExtension is the document level extension mapping scopes to consumer group names.
+const consumerGroupsExtension = "x-kong-consumer-groups"
+
+// getConsumerGroupNames returns the consumer group names by scope, from the 'x-kong-consumer-groups'
+// extension. Scopes mapped to the same name are merged into one group, scopes mapped to an empty
+// name (or null) are ignored. Scopes that are not in the map use the scope as the group name.
+func getConsumerGroupNames(extensions *orderedmap.Map[string, *yaml.Node]) (map[string]string, error) {
+	names := make(map[string]string)
+	if extensions == nil {
+		return names, nil
+	}
+	node, ok := extensions.Get(consumerGroupsExtension)
+	if !ok || node == nil {
+		return names, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected '%s' to be an object", consumerGroupsExtension)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		value := node.Content[i+1]
+		if value.Kind != yaml.ScalarNode || (value.Tag != "!!str" && value.Tag != "!!null") {
+			return nil, fmt.Errorf("expected the entries of '%s' to be strings", consumerGroupsExtension)
+		}
+		if value.Tag == "!!null" {
+			names[node.Content[i].Value] = ""
+		} else {
+			names[node.Content[i].Value] = value.Value
+		}
+	}
+	return names, nil
+}
+
+// getAllowedGroups returns the consumer groups allowed by the security requirements, sorted by name.
+// Returns nil if access is not restricted by scopes; if anonymous access is allowed, or if any of the
+// requirements has no scopes. The 'acl' plugin allows consumers in any of the listed groups, so a
+// single requirement can only require a single group. Merge the scopes into one group if needed.
+func getAllowedGroups(
+	requirements []*openapibase.SecurityRequirement,
+	groupNames map[string]string,
+) ([]string, error) {
+	allowed := make(map[string]bool)
+	for _, requirement := range requirements {
+		if requirement.Requirements == nil || requirement.Requirements.Len() == 0 {
+			// anonymous access is allowed
+			return nil, nil
+		}
+
+		groups := make(map[string]bool)
+		for pair := requirement.Requirements.First(); pair != nil; pair = pair.Next() {
+			for _, scope := range pair.Value() {
+				name, found := groupNames[scope]
+				if !found {
+					name = scope
+				}
+				if name != "" {
+					groups[name] = true
+				}
+			}
+		}
+
+		switch len(groups) {
+		case 0:
+			// any authenticated consumer is allowed
+			return nil, nil
+		case 1:
+			for name := range groups {
+				allowed[name] = true
+			}
+		default:
+			names := make([]string, 0, len(groups))
+			for name := range groups {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("a security-requirement requiring multiple consumer groups (%s) cannot be "+
+				"enforced by the acl plugin, use '%s' to merge them into a single group",
+				strings.Join(names, ", "), consumerGroupsExtension)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return nil, nil
+	}
+	result := make([]string, 0, len(allowed))
+	for name := range allowed {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// generateACLPlugin returns the 'acl' plugin allowing the consumer groups.
+func generateACLPlugin(
+	groups []string,
+	uuidNamespace uuid.UUID,
+	baseName string,
+	tags []string,
+	skipID bool,
+) *map[string]interface{} {
+	logbasics.Debug("generating acl plugin", "operation", baseName, "groups", groups)
+	plugin := map[string]interface{}{
+		"name": "acl",
+		"config": map[string]interface{}{
+			"allow":                   groups,
+			"include_consumer_groups": true,
+		},
+		"tags": tags,
+	}
+	if !skipID {
+		plugin["id"] = createPluginID(uuidNamespace, baseName, plugin)
+	}
+	return &plugin
+}
+
+// createConsumerGroups creates the consumer group entities, sorted by name.
+func createConsumerGroups(
+	names map[string]bool,
+	tags []string,
+	uuidNamespace uuid.UUID,
+	skipID bool,
+) []interface{} {
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	groups := make([]interface{}, len(sortedNames))
+	for i, name := range sortedNames {
+		group := map[string]interface{}{
+			"name": name,
+			"tags": tags,
+		}
+		if !skipID {
+			group["id"] = uuid.NewSHA1(uuidNamespace, []byte(name+".consumer_group")).String()
+		}
+		groups[i] = group
+	}
+	return groups
+}
@@ -0,0 +1,126 @@
+package openapi2kong
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+)
+
+// cookieHeader is the header holding the cookies, which is validated instead of the individual
+// cookie parameters, since the request-validator plugin does not support cookie parameters.
+const cookieHeader = "Cookie"
+
+// Regex snippets for the values of cookies, by schema type. A cookie value cannot contain a ';'.
+const (
+	cookieValueAny     = `[^;]*`
+	cookieValueInteger = `-?[0-9]+`
+	cookieValueNumber  = `-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?`
+	cookieValueBoolean = `(true|false)`
+)
+
+// getCookieValueRegex returns the regex for the value of a cookie, based on its schema. Only the type,
+// and enum or const values are enforced. Other constraints are logged, and not validated.
+func getCookieValueRegex(name string, schema *base.Schema) string {
+	if schema == nil {
+		return cookieValueAny
+	}
+
+	values := schema.Enum
+	if schema.Const != nil {
+		values = append(values, schema.Const)
+	}
+	if len(values) > 0 {
+		alternatives := make([]string, 0, len(values))
+		for _, node := range values {
+			alternatives = append(alternatives, regexp.QuoteMeta(node.Value))
+		}
+		return "(" + strings.Join(alternatives, "|") + ")"
+	}
+
+	schemaType := ""
+	for _, t := range schema.Type {
+		if t != "null" {
+			schemaType = t
+			break
+		}
+	}
+	switch schemaType {
+	case "integer":
+		return cookieValueInteger
+	case "number":
+		return cookieValueNumber
+	case "boolean":
+		return cookieValueBoolean
+	case "string":
+		if schema.Pattern == "" && schema.Format == "" && schema.MinLength == nil && schema.MaxLength == nil {
+			return cookieValueAny
+		}
+	case "":
+		return cookieValueAny
+	}
+	logbasics.Info("only the type, and enum values of cookie parameters are validated",
+		"cookie", name, "type", schemaType)
+	return cookieValueAny
+}
+
+// generateCookieParameter returns a request-validator parameter validating the Cookie header, for the
+// given cookie parameters. Required cookies must be present with a valid value, and optional ones must
+// have a valid value if present. Returns nil if there is nothing to validate.
+func generateCookieParameter(parameters []*v3.Parameter) (map[string]interface{}, error) {
+	required := false
+	constraints := make([]interface{}, 0, len(parameters))
+	for _, parameter := range parameters {
+		var schema *base.Schema
+		if parameter.Schema != nil {
+			schema = parameter.Schema.Schema()
+		}
+		isRequired := parameter.Required != nil && *parameter.Required
+		if !isRequired && schema == nil {
+			// optional cookie without a schema, nothing to validate
+			continue
+		}
+
+		// the cookies are separated by '; ', and whitespace around the value is allowed
+		name := regexp.QuoteMeta(parameter.Name)
+		cookieRegex := `(^|;)\s*` + name + `=\s*` + getCookieValueRegex(parameter.Name, schema) + `\s*(;|$)`
+		if isRequired {
+			required = true
+			constraints = append(constraints, map[string]interface{}{
+				"pattern": cookieRegex,
+			})
+		} else {
+			constraints = append(constraints, map[string]interface{}{
+				"anyOf": []interface{}{
+					map[string]interface{}{"not": map[string]interface{}{"pattern": `(^|;)\s*` + name + `=`}},
+					map[string]interface{}{"pattern": cookieRegex},
+				},
+			})
+		}
+	}
+	if len(constraints) == 0 {
+		return nil, nil
+	}
+
+	schema := map[string]interface{}{
+		"type":  "string",
+		"allOf": constraints,
+	}
+	schemaJSON, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate schema for cookie parameters: %w", err)
+	}
+
+	return map[string]interface{}{
+		"in":       "header",
+		"name":     cookieHeader,
+		"style":    "simple",
+		"explode":  false,
+		"required": required,
+		"schema":   string(schemaJSON),
+	}, nil
+}
@@ -0,0 +1,108 @@
+package openapi2kong
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.yaml.in/yaml/v4"
+)
+
+// kongExtension is the '/components/x-kong' object, it is removed from the sanitized spec along with
+// the 'x-kong-...' directives.
+const kongExtension = "x-kong"
+
+// opaqueKeys are the keys of the OAS objects holding schemas or example values. Their contents are not
+// OAS objects, so any 'x-kong' keys in there are data, and are kept.
+var opaqueKeys = map[string]bool{
+	"schema":      true,
+	"schemas":     true,
+	"definitions": true, // Swagger 2.0 schemas
+	"items":       true, // Swagger 2.0 parameter schemas
+	"example":     true,
+	"examples":    true,
+	"default":     true,
+	"enum":        true,
+}
+
+// removeKongExtensions removes all 'x-kong' and 'x-kong-...' keys from the OAS objects in the node,
+// recursively. Schemas, examples, and the values of other extensions are left as is.
+func removeKongExtensions(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			removeKongExtensions(child)
+		}
+	case yaml.MappingNode:
+		content := make([]*yaml.Node, 0, len(node.Content))
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == kongExtension || strings.HasPrefix(key, kongExtension+"-") {
+				continue
+			}
+			content = append(content, node.Content[i], node.Content[i+1])
+			if !opaqueKeys[key] && !strings.HasPrefix(key, "x-") {
+				removeKongExtensions(node.Content[i+1])
+			}
+		}
+		node.Content = content
+	}
+}
+
+// SanitizeSpec returns the spec for publishing it in the developer portal at the DocumentPath, that the
+// generated document objects reference. The Overlays are applied, and the 'x-kong' extensions removed.
+// It is serialized as JSON if the DocumentPath has a '.json' extension, or as YAML otherwise.
+func SanitizeSpec(content []byte, opts O2kOptions) ([]byte, error) {
+	content, err := applyOverlays(content, opts.Overlays)
+	if err != nil {
+		return nil, err
+	}
+	return sanitizeSpec(content, strings.EqualFold(path.Ext(opts.DocumentPath), ".json"))
+}
+
+// sanitizeSpec returns the spec without the 'x-kong' extensions, serialized as JSON or YAML.
+func sanitizeSpec(content []byte, asJSON bool) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse the spec: %w", err)
+	}
+	removeKongExtensions(&document)
+
+	if asJSON {
+		var data interface{}
+		if err := document.Decode(&data); err != nil {
+			return nil, fmt.Errorf("failed to serialize the spec: %w", err)
+		}
+		result, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize the spec: %w", err)
+		}
+		return append(result, '\n'), nil
+	}
+
+	var result bytes.Buffer
+	encoder := yaml.NewEncoder(&result)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, fmt.Errorf("failed to serialize the spec: %w", err)
+	}
+	return result.Bytes(), nil
+}
+
+// addDocumentObjects adds a 'document_objects' entry referencing the spec by its path to each of the
+// services. The spec itself is published to the developer portal separately, see SanitizeSpec.
+func addDocumentObjects(services []interface{}, documentPath string, uuidNamespace uuid.UUID, skipID bool) {
+	for _, service := range getObjectList(services) {
+		documentObject := map[string]interface{}{
+			"path": documentPath,
+		}
+		if !skipID {
+			serviceName := service["name"].(string) // safe because all generated services have a name
+			documentObject["id"] = uuid.NewSHA1(uuidNamespace, []byte(serviceName+".document_object")).String()
+		}
+		service["document_objects"] = []interface{}{documentObject}
+	}
+}
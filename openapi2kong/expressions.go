@@ -0,0 +1,149 @@
+package openapi2kong
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-apiops/jsonbasics"
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+)
+
+// Router flavors supported for generating routes
+const (
+	RouterFlavorTraditional = "traditional" // routes with paths, methods, and headers
+	RouterFlavorExpressions = "expressions" // routes with an expression and priority
+)
+
+const (
+	// priority of expression routes for plain paths (no path parameters)
+	expressionPriorityPlain = 200
+	// priority offset for paths with parameters, OAS has plain paths take precedence
+	expressionPriorityPathParamsOffset = -2
+	// priority offset for routes matching headers, they take precedence over their fallback route
+	expressionPriorityHeadersOffset = 1
+)
+
+// validateRouterFlavor checks the router flavor, and returns the normalized value.
+func validateRouterFlavor(flavor string) (string, error) {
+	switch flavor {
+	case "", RouterFlavorTraditional:
+		return RouterFlavorTraditional, nil
+	case RouterFlavorExpressions:
+		return RouterFlavorExpressions, nil
+	}
+	return "", fmt.Errorf("unsupported router flavor '%s', expected '%s' or '%s'",
+		flavor, RouterFlavorTraditional, RouterFlavorExpressions)
+}
+
+// quoteExpressionString returns the value as a string literal for an expression.
+func quoteExpressionString(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// getHeaderExpressionField returns the expression field for a header, eg. "X-Version"
+// becomes "http.headers.x_version".
+func getHeaderExpressionField(headerName string) string {
+	return "http.headers." + strings.ReplaceAll(strings.ToLower(headerName), "-", "_")
+}
+
+// createRouteExpression returns the expression matching the method, path, and hosts. If pathRegex
+// is empty, then the path is matched exactly, otherwise the regex is used. Leading and trailing
+// wildcard hosts ("*.example.com" and "example.*") match on the host suffix and prefix respectively.
+func createRouteExpression(method string, path string, pathRegex string, hosts []string) string {
+	terms := []string{"http.method == " + quoteExpressionString(method)}
+
+	if pathRegex == "" {
+		terms = append(terms, "http.path == "+quoteExpressionString(path))
+	} else {
+		// raw string, so the regex needs no escaping
+		terms = append(terms, `http.path ~ r#"^`+pathRegex+`$"#`)
+	}
+
+	if len(hosts) > 0 {
+		alternatives := make([]string, len(hosts))
+		for i, host := range hosts {
+			if strings.HasPrefix(host, "*.") {
+				alternatives[i] = "http.host =^ " + quoteExpressionString(strings.TrimPrefix(host, "*"))
+			} else if strings.HasSuffix(host, ".*") {
+				alternatives[i] = "http.host ^= " + quoteExpressionString(strings.TrimSuffix(host, "*"))
+			} else {
+				alternatives[i] = "http.host == " + quoteExpressionString(host)
+			}
+		}
+		terms = append(terms, "("+strings.Join(alternatives, " || ")+")")
+	}
+
+	return strings.Join(terms, " && ")
+}
+
+// createHeaderExpression returns the expression matching the headers, where each header
+// must match one of its values. The headers map is as returned by constructHeaderAlternativesForRouting.
+func createHeaderExpression(headers map[string]any) string {
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	terms := make([]string, len(headerNames))
+	for i, name := range headerNames {
+		values := headers[name].([]any)
+		alternatives := make([]string, len(values))
+		for j, value := range values {
+			alternatives[j] = getHeaderExpressionField(name) + " == " + quoteExpressionString(fmt.Sprint(value))
+		}
+		terms[i] = "(" + strings.Join(alternatives, " || ") + ")"
+	}
+	return strings.Join(terms, " && ")
+}
+
+// constructHeaderAlternativesForRouting returns the headers with all their possible values. Unlike
+// constructHeaderCombinationsForRouting an expression can match all combinations at once,
+// so a single map is returned, in a slice for compatibility.
+func constructHeaderAlternativesForRouting(headers []*v3.Parameter) []map[string]any {
+	headerMap := make(map[string]any)
+	for _, header := range headers {
+		values := make([]any, 0)
+		for _, enumMember := range header.Schema.Schema().Enum {
+			values = append(values, enumMember.Value)
+		}
+		headerMap[header.Name] = values
+	}
+	return []map[string]any{headerMap}
+}
+
+// setRouteExpression replaces the traditional matching fields (paths, methods, hosts, etc.) on the
+// route with an expression and priority. The hosts from the route defaults are included in the
+// expression. A priority in the route defaults replaces the priority of plain paths, other routes
+// are relative to that. Returns the priority set.
+func setRouteExpression(
+	route map[string]interface{},
+	method string,
+	path string,
+	pathRegex string, // empty if the path has no parameters
+) (int64, error) {
+	hosts, err := jsonbasics.GetStringArrayField(route, "hosts")
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse 'hosts' from route defaults: %w", err)
+	}
+
+	priority := int64(expressionPriorityPlain)
+	if route["priority"] != nil {
+		if priority, err = jsonbasics.GetInt64Field(route, "priority"); err != nil {
+			return 0, fmt.Errorf("failed to parse 'priority' from route defaults: %w", err)
+		}
+	}
+	if pathRegex != "" {
+		priority += expressionPriorityPathParamsOffset
+	}
+
+	for _, field := range []string{"paths", "methods", "hosts", "headers", "regex_priority"} {
+		delete(route, field)
+	}
+	route["expression"] = createRouteExpression(method, path, pathRegex, hosts)
+	route["priority"] = priority
+
+	return priority, nil
+}
@@ -0,0 +1,303 @@
+package openapi2kong
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/routeconflicts"
+	"go.yaml.in/yaml/v4"
+)
+
+// ID strategies, determining what the generated IDs (UUIDv5) are based on
+const (
+	IDStrategyName        = "name"         // the entity names, which include the document, path, and operation names
+	IDStrategyOperationID = "operation-id" // the operationId for entities generated from an operation
+	IDStrategyExtension   = "extension"    // the 'x-kong-id' of the object the entities are generated from
+)
+
+// the extension on document, path, operation, or service group level, holding the ID for the entities
+const idExtension = "x-kong-id"
+
+// pinnableEntities are the entity types that can be referenced in an ID pin file.
+var pinnableEntities = []string{"consumer", "consumer_group", "plugin", "route", "service", "upstream"}
+
+// validateIDStrategy checks the ID strategy, and returns it, defaulting to "name".
+func validateIDStrategy(strategy string) (string, error) {
+	switch strategy {
+	case "":
+		return IDStrategyName, nil
+	case IDStrategyName, IDStrategyOperationID, IDStrategyExtension:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unsupported id strategy '%s', expected '%s', '%s', or '%s'", strategy,
+		IDStrategyName, IDStrategyOperationID, IDStrategyExtension)
+}
+
+// ParseIDPins parses an ID pin file (JSON or YAML). The file maps previously generated IDs to the entities
+// that should keep them, referenced as "<entity-type>:<name>", eg. "route:petstore_list-pets". Plugins are
+// referenced as "plugin:<owner-name>.plugin.<plugin-name>", like in the provenance file.
+func ParseIDPins(content []byte) (map[string]string, error) {
+	var pins map[string]string
+	if err := yaml.Unmarshal(content, &pins); err != nil {
+		return nil, errors.New("expected the id pins to be an object mapping ids to entities")
+	}
+	for id, entity := range pins {
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("expected pinned id '%s' to be a UUID", id)
+		}
+		entityType, name, _ := strings.Cut(entity, ":")
+		if name == "" || !isPinnableEntity(entityType) {
+			return nil, fmt.Errorf("expected the entity for pinned id '%s' to be '<entity-type>:<name>', "+
+				"with entity-type one of: %s", id, strings.Join(pinnableEntities, ", "))
+		}
+	}
+	return pins, nil
+}
+
+// isPinnableEntity returns true if the entity type can be referenced in an ID pin file.
+func isPinnableEntity(entity string) bool {
+	for _, e := range pinnableEntities {
+		if e == entity {
+			return true
+		}
+	}
+	return false
+}
+
+// identifiedEntity is a generated entity that has an ID.
+type identifiedEntity struct {
+	entity string                 // the entity type
+	name   string                 // the entity name, or username for consumers
+	object map[string]interface{} // the entity itself
+}
+
+// getIdentifiedEntities returns the entities in the conversion result, plugins are named
+// "<owner-name>.plugin.<plugin-name>", with ".<consumer>" appended for top-level plugins.
+func getIdentifiedEntities(result map[string]interface{}) []identifiedEntity {
+	entities := make([]identifiedEntity, 0)
+
+	var addEntities func(entity string, value interface{})
+	addEntities = func(entity string, value interface{}) {
+		for _, object := range getObjectList(value) {
+			nameKey := "name"
+			if entity == "consumer" {
+				nameKey = "username"
+			}
+			name, _ := object[nameKey].(string)
+			entities = append(entities, identifiedEntity{entity: entity, name: name, object: object})
+			for _, plugin := range getObjectList(object["plugins"]) {
+				pluginName, _ := plugin["name"].(string)
+				entities = append(entities, identifiedEntity{
+					entity: "plugin",
+					name:   name + ".plugin." + pluginName,
+					object: plugin,
+				})
+			}
+			if entity == "service" {
+				addEntities("route", object["routes"])
+			}
+		}
+	}
+	addEntities("service", result["services"])
+	addEntities("upstream", result["upstreams"])
+	addEntities("consumer", result["consumers"])
+	addEntities("consumer_group", result["consumer_groups"])
+
+	for _, plugin := range getObjectList(result["plugins"]) {
+		pluginName, _ := plugin["name"].(string)
+		for _, owner := range []string{"route", "service"} {
+			if ownerName, ok := plugin[owner].(string); ok {
+				name := ownerName + ".plugin." + pluginName
+				if consumer, ok := plugin["consumer"].(string); ok {
+					name = name + "." + consumer
+				}
+				entities = append(entities, identifiedEntity{entity: "plugin", name: name, object: plugin})
+				break
+			}
+		}
+	}
+	return entities
+}
+
+// getIDAnchor returns the stable base for the IDs of the entities generated from an OAS object, and
+// the explicit ID if the 'x-kong-id' is a UUID. Returns "" if the strategy has no anchor for the
+// object, in which case the name based IDs are kept. An operationId is only unique within its
+// document, so its anchor includes the document key.
+func getIDAnchor(
+	strategy string,
+	origin provenanceOrigin,
+	operationIDs map[string]string,
+	docKey string,
+) (string, string, error) {
+	switch strategy {
+	case IDStrategyOperationID:
+		if operationID := operationIDs[origin.source]; operationID != "" {
+			return docKey + ":operation:" + operationID, "", nil
+		}
+	case IDStrategyExtension:
+		// only the object the entity is generated from counts, the extension is not inherited
+		if len(origin.levels) == 0 || origin.levels[len(origin.levels)-1].pointer != origin.source {
+			return "", "", nil
+		}
+		node := findPolicyHint(origin.levels[len(origin.levels)-1:], idExtension)
+		if node == nil {
+			return "", "", nil
+		}
+		if node.Kind != yaml.ScalarNode || node.Value == "" {
+			return "", "", fmt.Errorf("expected '%s' in '%s' to be a non-empty string", idExtension, origin.source)
+		}
+		if _, err := uuid.Parse(node.Value); err == nil {
+			return "id:" + node.Value, node.Value, nil
+		}
+		return "id:" + node.Value, "", nil
+	}
+	return "", "", nil
+}
+
+// applyIDStrategy replaces the name based IDs with IDs based on the strategy. Entities that are generated
+// from the same OAS object share an anchor (eg. the operationId), and are identified by type, and their
+// position by name if there are multiple (eg. the routes per header value). The explicit 'x-kong-id' UUID
+// is used as-is for the service generated from the object, or else its route. The IDs of plugins and
+// document objects follow the ID of their owner. The docKey scopes the operation based IDs to the document.
+func applyIDStrategy(
+	result map[string]interface{},
+	strategy string,
+	tracker *provenanceTracker,
+	routeOrigins map[string]routeconflicts.Origin,
+	docKey string,
+	uuidNamespace uuid.UUID,
+) error {
+	if strategy == IDStrategyName {
+		return nil
+	}
+
+	// the operationIds by the JSON pointer of their operation
+	operationIDs := make(map[string]string)
+	for name, origin := range routeOrigins {
+		if recorded, found := tracker.origins["route:"+name]; found && origin.OperationID != "" {
+			operationIDs[recorded.source] = origin.OperationID
+		}
+	}
+
+	// group the entities by anchor and type, such that they can be numbered
+	type anchoredEntity struct {
+		identifiedEntity
+		explicitID string // the explicit UUID from 'x-kong-id', if any
+	}
+	groups := make(map[string][]anchoredEntity)
+	for _, entity := range getIdentifiedEntities(result) {
+		if entity.entity == "plugin" {
+			continue // plugins follow their owners
+		}
+		origin, found := tracker.origins[entity.entity+":"+entity.name]
+		if !found {
+			continue
+		}
+		anchor, explicitID, err := getIDAnchor(strategy, origin, operationIDs, docKey)
+		if err != nil {
+			return err
+		}
+		if anchor != "" {
+			key := anchor + "." + entity.entity
+			groups[key] = append(groups[key], anchoredEntity{entity, explicitID})
+		}
+	}
+
+	ownerKeys := make(map[string]string) // the new ID base by "<entity-type>:<name>"
+	for key, entities := range groups {
+		anchor := key[:strings.LastIndex(key, ".")]
+		sort.Slice(entities, func(i, j int) bool { return entities[i].name < entities[j].name })
+		for i, entity := range entities {
+			entityKey := key
+			if len(entities) > 1 {
+				entityKey = key + "." + strconv.Itoa(i)
+			}
+			id := uuid.NewSHA1(uuidNamespace, []byte(entityKey)).String()
+			_, hasService := groups[anchor+".service"]
+			if entity.explicitID != "" && len(entities) == 1 &&
+				(entity.entity == "service" || (entity.entity == "route" && !hasService)) {
+				id = entity.explicitID
+			}
+			logbasics.Debug("applying id strategy", "entity", entity.entity, "name", entity.name, "id", id)
+			entity.object["id"] = id
+			ownerKeys[entity.entity+":"+entity.name] = entityKey
+
+			for _, plugin := range getObjectList(entity.object["plugins"]) {
+				plugin["id"] = uuid.NewSHA1(uuidNamespace, []byte(entityKey+".plugin."+plugin["name"].(string))).String()
+			}
+			for _, documentObject := range getObjectList(entity.object["document_objects"]) {
+				documentObject["id"] = uuid.NewSHA1(uuidNamespace, []byte(entityKey+".document_object")).String()
+			}
+		}
+	}
+
+	// the top-level plugins reference their owners by name
+	for _, plugin := range getObjectList(result["plugins"]) {
+		for _, owner := range []string{"route", "service"} {
+			ownerName, ok := plugin[owner].(string)
+			if !ok {
+				continue
+			}
+			if ownerKey, found := ownerKeys[owner+":"+ownerName]; found {
+				key := ownerKey + ".plugin." + plugin["name"].(string)
+				if consumer, ok := plugin["consumer"].(string); ok {
+					key = key + "." + consumer
+				}
+				plugin["id"] = uuid.NewSHA1(uuidNamespace, []byte(key)).String()
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// applyIDPins sets the pinned IDs on their entities, and checks the IDs to be unique. Fails if a pinned
+// entity was not generated.
+func applyIDPins(result map[string]interface{}, pins map[string]string) error {
+	if len(pins) == 0 {
+		return nil
+	}
+
+	entities := getIdentifiedEntities(result)
+	byReference := make(map[string]identifiedEntity, len(entities))
+	for _, entity := range entities {
+		byReference[entity.entity+":"+entity.name] = entity
+	}
+
+	ids := make([]string, 0, len(pins))
+	for id := range pins {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	pinned := make(map[string]string) // the pinned ID by entity reference
+	for _, id := range ids {
+		reference := pins[id]
+		entity, found := byReference[reference]
+		if !found {
+			return fmt.Errorf("the entity '%s' for pinned id '%s' was not generated", reference, id)
+		}
+		if other, found := pinned[reference]; found {
+			return fmt.Errorf("the entity '%s' is pinned to both id '%s' and '%s'", reference, other, id)
+		}
+		logbasics.Debug("pinning id", "entity", reference, "id", id, "generated-id", entity.object["id"])
+		entity.object["id"] = id
+		pinned[reference] = id
+	}
+
+	owners := make(map[string]string) // the entity reference by ID
+	for _, entity := range entities {
+		id, _ := entity.object["id"].(string)
+		reference := entity.entity + ":" + entity.name
+		if other, found := owners[id]; found && id != "" {
+			return fmt.Errorf("the pinned ids result in id '%s' being used by both '%s' and '%s'", id, other, reference)
+		}
+		owners[id] = reference
+	}
+	return nil
+}
@@ -2,11 +2,32 @@ package openapi2kong
 
 import (
 	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/kong/go-apiops/logbasics"
 	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"github.com/pb33f/libopenapi/orderedmap"
 )
 
+// JSONschema versions supported by the request-validator plugin, in order of release
+const (
+	jsonSchemaDraft4      = "draft4"
+	jsonSchemaDraft7      = "draft7"
+	jsonSchemaDraft201909 = "draft201909"
+	jsonSchemaDraft202012 = "draft202012"
+)
+
+var jsonSchemaVersions = []string{jsonSchemaDraft4, jsonSchemaDraft7, jsonSchemaDraft201909, jsonSchemaDraft202012}
+
+// componentsSchemasRef is the prefix of references to the component schemas
+const componentsSchemasRef = "#/components/schemas/"
+
+// invalidDefinitionChars matches the characters to replace when creating definition names
+var invalidDefinitionChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
 // dereferenceSchema walks the schema and adds every subschema to the seenBefore map.
 // This is safe to recursive schemas.
 func dereferenceSchema(sr *base.SchemaProxy, seenBefore map[string]*base.SchemaProxy) {
@@ -38,12 +59,29 @@ func dereferenceSchema(sr *base.SchemaProxy, seenBefore map[string]*base.SchemaP
 		schema = schema.Next()
 	}
 
+	// OAS 3.1 (JSONschema 2020-12) has more keywords holding sub-schemas
+	for _, schema := range s.PrefixItems {
+		dereferenceSchema(schema, seenBefore)
+	}
+	for _, schemaMap := range []*orderedmap.Map[string, *base.SchemaProxy]{s.PatternProperties, s.DependentSchemas} {
+		for schema := schemaMap.First(); schema != nil; schema = schema.Next() {
+			dereferenceSchema(schema.Value(), seenBefore)
+		}
+	}
+	for _, schema := range []*base.SchemaProxy{s.Contains, s.If, s.Then, s.Else, s.PropertyNames, s.UnevaluatedItems} {
+		dereferenceSchema(schema, seenBefore)
+	}
+
 	dereferenceSchema(s.Not, seenBefore)
 
 	if s.AdditionalProperties != nil && s.AdditionalProperties.IsA() {
 		dereferenceSchema(s.AdditionalProperties.A, seenBefore)
 	}
 
+	if s.UnevaluatedProperties != nil && s.UnevaluatedProperties.IsA() {
+		dereferenceSchema(s.UnevaluatedProperties.A, seenBefore)
+	}
+
 	if s.Items != nil && s.Items.IsA() {
 		dereferenceSchema(s.Items.A, seenBefore)
 	}
@@ -51,10 +89,11 @@ func dereferenceSchema(sr *base.SchemaProxy, seenBefore map[string]*base.SchemaP
 
 // extractSchema will extract a schema, including all sub-schemas/references and
 // return it as a single JSONschema string. All components will be moved under the
-// "#/definitions/" key. Along with that, it will also return the schema as a
-// map, so that if any further operations or validations need to be done on the schema
-// they could be performed.
-func extractSchema(s *base.SchemaProxy) (string, map[string]interface{}) {
+// "#/definitions/" key. The schema is converted to the JSONschema version used by the
+// request-validator plugin, see convertSchema. Along with that, it will also return the
+// schema as a map, so that if any further operations or validations need to be done on
+// the schema they could be performed.
+func extractSchema(s *base.SchemaProxy, oas31 bool, version string) (string, map[string]interface{}) {
 	if s == nil || s.Schema() == nil {
 		return "", nil
 	}
@@ -87,12 +126,255 @@ func extractSchema(s *base.SchemaProxy) (string, map[string]interface{}) {
 			_ = json.Unmarshal(jConf, &copySchema)
 
 			// store under new key
-			definitions[strings.Replace(key, "#/components/schemas/", "", 1)] = copySchema
+			definitions[definitionName(key)] = copySchema
 		}
 		finalSchema["definitions"] = definitions
 	}
 
+	convertSchema(finalSchema, oas31, version)
+
 	result, _ := json.Marshal(finalSchema)
-	// update the $ref values; this is safe because plain " (double-quotes) would be escaped if in actual values
-	return strings.ReplaceAll(string(result), "\"$ref\":\"#/components/schemas/", "\"$ref\":\"#/definitions/"), finalSchema
+	return string(result), finalSchema
+}
+
+// ExtractSchema returns the schema as a JSONschema object, with all the schemas it references stored
+// under "definitions", like the request-validator schemas. The schema keeps the dialect of the document;
+// draft4 based for OAS 3.0, and 2020-12 for OAS 3.1. Returns nil if there is no schema.
+func ExtractSchema(s *base.SchemaProxy, oas31 bool) map[string]interface{} {
+	version := jsonSchemaDraft4
+	if oas31 {
+		version = jsonSchemaDraft202012
+	}
+	_, schema := extractSchema(s, oas31, version)
+	return schema
+}
+
+// definitionName returns the key under "#/definitions/" for a referenced schema. Component
+// schemas keep their name, schemas from other files get a name derived from the reference.
+func definitionName(ref string) string {
+	if strings.HasPrefix(ref, componentsSchemasRef) {
+		return strings.TrimPrefix(ref, componentsSchemasRef)
+	}
+	// eg. "../common/schemas.yaml#/Pet" becomes "common_schemas.yaml_Pet"
+	return strings.Trim(invalidDefinitionChars.ReplaceAllString(ref, "_"), "._")
+}
+
+// jsonSchemaVersionIndex returns the index of the version in jsonSchemaVersions, or -1 if not supported.
+func jsonSchemaVersionIndex(version string) int {
+	return slices.Index(jsonSchemaVersions, version)
+}
+
+// convertSchema converts the schema (as extracted by extractSchema) in place to the JSONschema version
+// given. OAS 3.0 schemas are draft4 based (with some OAS specifics), and OAS 3.1 schemas are
+// JSONschema 2020-12. Constructs that cannot be represented in the target version are removed,
+// and a warning is logged. Finally, references to "#/components/schemas/" are updated to point
+// to "#/definitions/".
+func convertSchema(schema map[string]interface{}, oas31 bool, version string) {
+	target := jsonSchemaVersionIndex(version)
+	if target == -1 {
+		target = jsonSchemaVersionIndex(JSONSchemaVersion)
+	}
+	c := schemaConverter{
+		oas31:   oas31,
+		version: jsonSchemaVersions[target],
+		target:  target,
+	}
+	c.convert(schema, "#")
+}
+
+// schemaConverter holds the state of a schema conversion, see convertSchema.
+type schemaConverter struct {
+	oas31   bool   // the source schema is JSONschema 2020-12 (OAS 3.1), otherwise OAS 3.0
+	version string // the target version
+	target  int    // the index of the target version in jsonSchemaVersions
+}
+
+// before returns true if the target version was released before the given version.
+func (c schemaConverter) before(version string) bool {
+	return c.target < jsonSchemaVersionIndex(version)
+}
+
+// drop removes a keyword that cannot be represented in the target version, and logs a warning.
+func (c schemaConverter) drop(schema map[string]interface{}, keyword string, pointer string) {
+	if _, found := schema[keyword]; !found {
+		return
+	}
+	delete(schema, keyword)
+	logbasics.Info("JSONschema keyword is not supported by the request-validator schema version, it will be ignored",
+		"keyword", keyword, "version", c.version, "location", pointer)
+}
+
+// convert converts a single schema object and recurses into its sub-schemas. The pointer is the
+// location of the schema, used for logging.
+func (c schemaConverter) convert(schema map[string]interface{}, pointer string) {
+	if ref, ok := schema["$ref"].(string); ok && (strings.HasPrefix(ref, componentsSchemasRef) ||
+		!strings.HasPrefix(ref, "#")) {
+		// nested paths (eg. "Pet/$defs/name") are stored as a single key in "#/definitions/"
+		schema["$ref"] = "#/definitions/" + strings.ReplaceAll(definitionName(ref), "/", "~1")
+	}
+
+	if c.oas31 {
+		c.convertFrom202012(schema, pointer)
+	} else if !c.before(jsonSchemaDraft7) {
+		// OAS 3.0 uses the draft4 boolean forms
+		for _, keyword := range []string{"exclusiveMinimum", "exclusiveMaximum"} {
+			limit := strings.ToLower(strings.TrimPrefix(keyword, "exclusive"))
+			if exclusive, ok := schema[keyword].(bool); ok {
+				if exclusive && schema[limit] != nil {
+					schema[keyword] = schema[limit]
+					delete(schema, limit)
+				} else {
+					delete(schema, keyword)
+				}
+			}
+		}
+	}
+
+	// recurse into the sub-schemas
+	for _, keyword := range []string{
+		"not", "if", "then", "else", "contains", "propertyNames", "additionalProperties",
+		"additionalItems", "unevaluatedItems", "unevaluatedProperties", "items",
+	} {
+		if subSchema, found := schema[keyword]; found {
+			schema[keyword] = c.convertSubSchema(subSchema, keyword, pointer+"/"+keyword)
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf", "prefixItems", "items"} {
+		if subSchemas, ok := schema[keyword].([]interface{}); ok {
+			for i, subSchema := range subSchemas {
+				subSchemas[i] = c.convertSubSchema(subSchema, keyword, fmt.Sprintf("%s/%s/%d", pointer, keyword, i))
+			}
+		}
+	}
+	for _, keyword := range []string{
+		"properties", "patternProperties", "dependentSchemas", "dependencies", "definitions", "$defs",
+	} {
+		if subSchemas, ok := schema[keyword].(map[string]interface{}); ok {
+			for name, subSchema := range subSchemas {
+				subSchemas[name] = c.convertSubSchema(subSchema, keyword, pointer+"/"+keyword+"/"+name)
+			}
+		}
+	}
+}
+
+// convertSubSchema converts the sub-schema found under the keyword, and returns the converted
+// sub-schema. Boolean schemas are replaced by their object equivalents if the target version
+// does not support them.
+func (c schemaConverter) convertSubSchema(subSchema interface{}, keyword string, pointer string) interface{} {
+	switch value := subSchema.(type) {
+	case map[string]interface{}:
+		c.convert(value, pointer)
+	case bool:
+		if c.before(jsonSchemaDraft7) && keyword != "additionalProperties" && keyword != "additionalItems" {
+			// draft4 has no boolean schemas, except for these 2 keywords
+			if value {
+				return map[string]interface{}{}
+			}
+			return map[string]interface{}{"not": map[string]interface{}{}}
+		}
+	}
+	return subSchema
+}
+
+// convertFrom202012 converts the keywords of a single JSONschema 2020-12 schema object to the
+// target version. Sub-schemas are not handled.
+func (c schemaConverter) convertFrom202012(schema map[string]interface{}, pointer string) {
+	// the validator version is set by the plugin, not by the schema
+	delete(schema, "$schema")
+	delete(schema, "$vocabulary")
+
+	if c.before(jsonSchemaDraft202012) {
+		// tuple validation used "items" (array) and "additionalItems" before 2020-12
+		if prefixItems, found := schema["prefixItems"]; found {
+			if items, found := schema["items"]; found {
+				schema["additionalItems"] = items
+			}
+			schema["items"] = prefixItems
+			delete(schema, "prefixItems")
+		}
+		c.drop(schema, "$dynamicRef", pointer)
+		c.drop(schema, "$dynamicAnchor", pointer)
+	}
+
+	if c.before(jsonSchemaDraft201909) {
+		if defs, ok := schema["$defs"].(map[string]interface{}); ok {
+			definitions, _ := schema["definitions"].(map[string]interface{})
+			if definitions == nil {
+				definitions = make(map[string]interface{})
+				schema["definitions"] = definitions
+			}
+			for name, def := range defs {
+				definitions[name] = def
+			}
+			delete(schema, "$defs")
+		}
+
+		// "dependentRequired" and "dependentSchemas" were combined in "dependencies"
+		for _, keyword := range []string{"dependentRequired", "dependentSchemas"} {
+			if dependent, ok := schema[keyword].(map[string]interface{}); ok {
+				dependencies, _ := schema["dependencies"].(map[string]interface{})
+				if dependencies == nil {
+					dependencies = make(map[string]interface{})
+					schema["dependencies"] = dependencies
+				}
+				for name, dependency := range dependent {
+					dependencies[name] = dependency
+				}
+				delete(schema, keyword)
+			}
+		}
+
+		// before 2019-09 all siblings of "$ref" are ignored, so move the "$ref" into an "allOf". The
+		// "definitions" are only the targets of references, so they do not count as siblings.
+		siblings := len(schema) - 1
+		if _, found := schema["definitions"]; found {
+			siblings--
+		}
+		if ref, found := schema["$ref"]; found && siblings > 0 {
+			allOf, _ := schema["allOf"].([]interface{})
+			schema["allOf"] = append(allOf, map[string]interface{}{"$ref": ref})
+			delete(schema, "$ref")
+		}
+
+		for _, keyword := range []string{
+			"unevaluatedProperties", "unevaluatedItems", "minContains", "maxContains", "$anchor",
+		} {
+			c.drop(schema, keyword, pointer)
+		}
+	}
+
+	if c.before(jsonSchemaDraft7) {
+		if constant, found := schema["const"]; found {
+			schema["enum"] = []interface{}{constant}
+			delete(schema, "const")
+		}
+
+		// numeric "exclusiveMinimum/Maximum" are a boolean modifier of "minimum/maximum" in draft4
+		for _, keyword := range []string{"exclusiveMinimum", "exclusiveMaximum"} {
+			limit := strings.ToLower(strings.TrimPrefix(keyword, "exclusive"))
+			if exclusive, ok := schema[keyword].(float64); ok {
+				current, hasLimit := schema[limit].(float64)
+				switch {
+				case !hasLimit || (keyword == "exclusiveMinimum" && exclusive >= current) ||
+					(keyword == "exclusiveMaximum" && exclusive <= current):
+					schema[limit] = exclusive
+					schema[keyword] = true
+				default:
+					// the inclusive limit is the stricter one
+					delete(schema, keyword)
+				}
+			}
+		}
+
+		// annotations only, but unknown to draft4
+		for _, keyword := range []string{
+			"examples", "$comment", "contentMediaType", "contentEncoding", "contentSchema",
+		} {
+			delete(schema, keyword)
+		}
+
+		for _, keyword := range []string{"if", "then", "else", "contains", "propertyNames"} {
+			c.drop(schema, keyword, pointer)
+		}
+	}
 }
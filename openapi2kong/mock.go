@@ -0,0 +1,375 @@
+package openapi2kong
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"go.yaml.in/yaml/v4"
+)
+
+// Mock modes supported for generating mock routes
+const (
+	MockRequestTermination = "request-termination" // serve an example using the request-termination plugin
+	MockMocking            = "mocking"             // serve examples using the mocking plugin (Enterprise)
+)
+
+const (
+	mockExtension = "x-kong-mock"
+	// the host for services in mock mode, mocked requests are never proxied
+	mockServiceHost = "localhost"
+	// maximum depth for generating an example from a schema, to break recursion
+	mockMaxSchemaDepth = 10
+)
+
+// mockConfig is the 'x-kong-mock' extension of an operation, to select what to serve.
+type mockConfig struct {
+	Code    string `yaml:"code"`    // the response code to serve, eg. "404" or "default"
+	Example string `yaml:"example"` // the name of the example to serve (from 'examples')
+}
+
+// validateMockMode checks the mock mode.
+func validateMockMode(mode string) error {
+	switch mode {
+	case "", MockRequestTermination, MockMocking:
+		return nil
+	}
+	return fmt.Errorf("unsupported mock mode '%s', expected '%s' or '%s'", mode, MockRequestTermination, MockMocking)
+}
+
+// getMockConfig returns the 'x-kong-mock' extension of the operation, or an empty config if not set.
+func getMockConfig(operation *v3.Operation) (*mockConfig, error) {
+	config := &mockConfig{}
+	if operation.Extensions == nil {
+		return config, nil
+	}
+	node, ok := operation.Extensions.Get(mockExtension)
+	if !ok || node == nil {
+		return config, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected '%s' to be an object", mockExtension)
+	}
+	if err := node.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to parse '%s': %w", mockExtension, err)
+	}
+	return config, nil
+}
+
+// getMockStatusCode returns the HTTP status code to serve for a response code from the spec. The
+// 'default' response, and ranges like '2XX' get the first code of their range.
+func getMockStatusCode(code string) (int, error) {
+	if code == "default" {
+		return 200, nil
+	}
+	if len(code) == 3 && strings.HasSuffix(strings.ToUpper(code), "XX") {
+		code = code[:1] + "00"
+	}
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || statusCode < 100 || statusCode > 599 {
+		return 0, fmt.Errorf("invalid response code '%s'", code)
+	}
+	return statusCode, nil
+}
+
+// selectMockResponse returns the response code to serve, and the response. The code from the
+// config is used if set, otherwise the lowest success code, or the first one defined.
+func selectMockResponse(operation *v3.Operation, config *mockConfig) (string, *v3.Response, error) {
+	if operation.Responses == nil {
+		return "", nil, fmt.Errorf("no responses defined")
+	}
+
+	codes := make([]string, 0)
+	responses := make(map[string]*v3.Response)
+	if operation.Responses.Codes != nil {
+		for pair := operation.Responses.Codes.First(); pair != nil; pair = pair.Next() {
+			codes = append(codes, pair.Key())
+			responses[pair.Key()] = pair.Value()
+		}
+	}
+	if operation.Responses.Default != nil {
+		codes = append(codes, "default")
+		responses["default"] = operation.Responses.Default
+	}
+
+	if config.Code != "" {
+		response, found := responses[config.Code]
+		if !found {
+			return "", nil, fmt.Errorf("response code '%s' from '%s' is not defined in the responses",
+				config.Code, mockExtension)
+		}
+		return config.Code, response, nil
+	}
+
+	if len(codes) == 0 {
+		return "", nil, fmt.Errorf("no responses defined")
+	}
+	successCodes := make([]string, 0)
+	for _, code := range codes {
+		if strings.HasPrefix(code, "2") {
+			successCodes = append(successCodes, code)
+		}
+	}
+	if len(successCodes) > 0 {
+		sort.Strings(successCodes)
+		return successCodes[0], responses[successCodes[0]], nil
+	}
+	return codes[0], responses[codes[0]], nil
+}
+
+// selectMockContent returns the content-type and media type to serve, JSON is preferred.
+// Returns empty values if the response has no content.
+func selectMockContent(response *v3.Response) (string, *v3.MediaType) {
+	if response.Content == nil || response.Content.Len() == 0 {
+		return "", nil
+	}
+	for pair := response.Content.First(); pair != nil; pair = pair.Next() {
+		if isJSONContentType(pair.Key()) {
+			return pair.Key(), pair.Value()
+		}
+	}
+	first := response.Content.First()
+	return first.Key(), first.Value()
+}
+
+// isJSONContentType returns true for JSON content types, eg. "application/problem+json".
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// decodeExample decodes a YAML node into a JSON compatible value.
+func decodeExample(node *yaml.Node) (interface{}, error) {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to parse example: %w", err)
+	}
+	return value, nil
+}
+
+// getMockExample returns the example value to serve from the media type. The named example is
+// used if given, otherwise the first example, or one generated from the schema.
+func getMockExample(mediaType *v3.MediaType, exampleName string) (interface{}, error) {
+	if exampleName != "" {
+		if mediaType.Examples != nil {
+			if example, found := mediaType.Examples.Get(exampleName); found && example.Value != nil {
+				return decodeExample(example.Value)
+			}
+		}
+		return nil, fmt.Errorf("example '%s' from '%s' is not defined", exampleName, mockExtension)
+	}
+
+	if mediaType.Example != nil {
+		return decodeExample(mediaType.Example)
+	}
+	if mediaType.Examples != nil {
+		for pair := mediaType.Examples.First(); pair != nil; pair = pair.Next() {
+			if pair.Value().Value != nil {
+				return decodeExample(pair.Value().Value)
+			}
+		}
+	}
+	if mediaType.Schema != nil {
+		return generateSchemaExample(mediaType.Schema.Schema(), 0)
+	}
+	return nil, nil
+}
+
+// generateSchemaExample generates an example value from a schema. Examples, defaults, and enums in
+// the schema are used where available.
+func generateSchemaExample(schema *base.Schema, depth int) (interface{}, error) {
+	if schema == nil || depth > mockMaxSchemaDepth {
+		return nil, nil
+	}
+
+	for _, node := range []*yaml.Node{schema.Example, schema.Const, schema.Default} {
+		if node != nil {
+			return decodeExample(node)
+		}
+	}
+	if len(schema.Examples) > 0 {
+		return decodeExample(schema.Examples[0])
+	}
+	if len(schema.Enum) > 0 {
+		return decodeExample(schema.Enum[0])
+	}
+
+	if len(schema.AllOf) > 0 {
+		// merge the sub-schema examples, if they are objects
+		merged := make(map[string]interface{})
+		for _, proxy := range schema.AllOf {
+			example, err := generateSchemaExample(proxy.Schema(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if object, ok := example.(map[string]interface{}); ok {
+				for key, value := range object {
+					merged[key] = value
+				}
+			}
+		}
+		return merged, nil
+	}
+	for _, alternatives := range [][]*base.SchemaProxy{schema.OneOf, schema.AnyOf} {
+		if len(alternatives) > 0 {
+			return generateSchemaExample(alternatives[0].Schema(), depth+1)
+		}
+	}
+
+	schemaType := ""
+	for _, t := range schema.Type {
+		if t != "null" {
+			schemaType = t
+			break
+		}
+	}
+	if schemaType == "" && schema.Properties != nil {
+		schemaType = "object"
+	}
+
+	switch schemaType {
+	case "object":
+		object := make(map[string]interface{})
+		if schema.Properties != nil {
+			for pair := schema.Properties.First(); pair != nil; pair = pair.Next() {
+				value, err := generateSchemaExample(pair.Value().Schema(), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				object[pair.Key()] = value
+			}
+		}
+		return object, nil
+	case "array":
+		if schema.Items != nil && schema.Items.IsA() {
+			item, err := generateSchemaExample(schema.Items.A.Schema(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		return []interface{}{}, nil
+	case "integer":
+		if schema.Minimum != nil {
+			return int64(*schema.Minimum), nil
+		}
+		return 0, nil
+	case "number":
+		if schema.Minimum != nil {
+			return *schema.Minimum, nil
+		}
+		return 0, nil
+	case "boolean":
+		return true, nil
+	case "string":
+		switch schema.Format {
+		case "date":
+			return "2024-01-01", nil
+		case "date-time":
+			return "2024-01-01T00:00:00Z", nil
+		case "email":
+			return "user@example.com", nil
+		case "uuid":
+			return "00000000-0000-0000-0000-000000000000", nil
+		case "uri", "url":
+			return "https://example.com", nil
+		}
+		return "string", nil
+	}
+	return nil, nil
+}
+
+// generateMockPlugin returns the plugin that serves the mock response for the operation. For the
+// mocking plugin the spec is included, since the plugin generates the responses itself. It is the
+// same spec for all routes, without the 'x-kong' extensions.
+func generateMockPlugin(
+	mode string,
+	spec string,
+	operation *v3.Operation,
+	uuidNamespace uuid.UUID,
+	baseName string,
+	tags []string,
+	skipID bool,
+) (*map[string]interface{}, error) {
+	config, err := getMockConfig(operation)
+	if err != nil {
+		return nil, err
+	}
+	code, response, err := selectMockResponse(operation, config)
+	if err != nil {
+		return nil, err
+	}
+	statusCode, err := getMockStatusCode(code)
+	if err != nil {
+		return nil, err
+	}
+	logbasics.Debug("generating mock plugin", "operation", baseName, "mode", mode, "code", code)
+
+	pluginConfig := make(map[string]interface{})
+	if mode == MockMocking {
+		if config.Example != "" {
+			return nil, fmt.Errorf("selecting an example with '%s' is not supported by the '%s' plugin",
+				mockExtension, MockMocking)
+		}
+		pluginConfig["api_specification"] = spec
+		pluginConfig["included_status_codes"] = []int{statusCode}
+	} else {
+		pluginConfig["status_code"] = statusCode
+		contentType, mediaType := selectMockContent(response)
+		if mediaType != nil {
+			example, err := getMockExample(mediaType, config.Example)
+			if err != nil {
+				return nil, err
+			}
+			body, isString := example.(string)
+			if !isString || isJSONContentType(contentType) {
+				encoded, err := json.Marshal(example)
+				if err != nil {
+					return nil, fmt.Errorf("failed to serialize example: %w", err)
+				}
+				body = string(encoded)
+			}
+			pluginConfig["content_type"] = contentType
+			pluginConfig["body"] = body
+		} else if config.Example != "" {
+			return nil, fmt.Errorf("example '%s' from '%s' is not defined, the response has no content",
+				config.Example, mockExtension)
+		}
+	}
+
+	plugin := map[string]interface{}{
+		"name":   mode,
+		"config": pluginConfig,
+		"tags":   tags,
+	}
+	if !skipID {
+		plugin["id"] = createPluginID(uuidNamespace, baseName, plugin)
+	}
+	return &plugin, nil
+}
+
+// hasPlugin returns true if the list contains a plugin by the name.
+func hasPlugin(list *[]*map[string]interface{}, name string) bool {
+	for _, plugin := range *list {
+		if (*plugin)["name"] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setMockService updates a service to not depend on any upstream, since mocked requests are
+// never proxied.
+func setMockService(service map[string]interface{}) {
+	service["host"] = mockServiceHost
+	service["port"] = 80
+	service["protocol"] = "http"
+	service["path"] = "/"
+}
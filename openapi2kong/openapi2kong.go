@@ -3,17 +3,21 @@ package openapi2kong
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 
 	"github.com/google/uuid"
-	"github.com/kong/go-apiops/filebasics"
 	"github.com/kong/go-apiops/jsonbasics"
 	"github.com/kong/go-apiops/logbasics"
 	"github.com/kong/go-apiops/openapitools"
+	"github.com/kong/go-apiops/overlay"
+	"github.com/kong/go-apiops/routeconflicts"
 	"github.com/pb33f/libopenapi"
 	"github.com/pb33f/libopenapi/datamodel"
 	openapibase "github.com/pb33f/libopenapi/datamodel/high/base"
@@ -43,20 +47,79 @@ type O2kOptions struct {
 	InsoCompat bool
 	// Skip ID generation (UUIDs)
 	SkipID bool
-	// Enable OIDC plugin generation
+	// Enable security plugin generation (openid-connect, key-auth, basic-auth, etc.)
 	OIDC bool
-	// Ignore security errors (non-OIDC and AND/OR logic)
+	// Ignore security errors (unsupported schemes and security-requirements)
 	IgnoreSecurityErrors bool
+	// Fail on unsupported security-requirements of operations, instead of skipping those operations
+	StrictSecurity bool
 	// Ignore circular references
 	IgnoreCircularRefs bool
 	// Generate separate routes for each header enum even if required: false
 	TreatAllHeadersAsRequired bool
 	// Skip generation of separate routes for header parameter enums
 	SkipRouteByHeader bool
+	// Router flavor to generate routes for; "traditional" (default) or "expressions". The
+	// expressions flavor generates an 'expression' and 'priority' instead of paths, methods,
+	// and headers, and matches all header enum values in a single route.
+	RouterFlavor string
 	// Enable service reuse: when multiple paths have identical server configurations
 	// and no path-level plugins, they will share a single Kong service instead of
 	// creating duplicate services. This reduces resource bloat in Kong.
 	ReuseServices bool
+	// Base directory for resolving relative file references ($ref: ./schemas/pet.yaml),
+	// typically the directory of the spec file. If empty, only local references are resolved.
+	BasePath string
+	// Filesystem for resolving relative file references, rooted at the directory of the spec
+	// file. Takes precedence over BasePath.
+	FS fs.FS
+	// Local mirrors of remote references, maps a URL prefix to a local path. Conversion is
+	// offline-only, so remote references without a mirror are rejected.
+	RemoteRefMirrors map[string]string
+	// Fail the conversion if generated routes shadow each other, or are ambiguous. Conflicts
+	// are always logged. Only traditional routes are analyzed, so it cannot be combined with
+	// the "expressions" router flavor.
+	FailOnRouteConflicts bool
+	// Generate an 'oas-validation' plugin on each route, validating the responses against the
+	// schemas of the operation. An 'x-kong-plugin-oas-validation' extension is used as the base config.
+	ValidateResponses bool
+	// Mock mode; "request-termination" or "mocking". If set, each route gets a plugin serving
+	// a response from the operation's examples/schemas (selected by 'x-kong-mock'), and services
+	// do not depend on any upstream. Empty to disable.
+	Mock string
+	// Generate routes for receiving the OAS 3.1 'webhooks', and the 'callbacks' of operations, on a
+	// dedicated service. The requestBody schemas of the callbacks feed the request-validator. The document
+	// level 'x-kong-callbacks' object can hold the servers, defaults, and plugins for that service.
+	Callbacks bool
+	// Service grouping strategy; "document" (default), "path", "tag", or "extension". With "path" each path
+	// gets a service. With "tag" and "extension" a service is created per OAS tag (the first tag of an
+	// operation), or per 'x-kong-service-group' value, for operations that would otherwise be on the
+	// document level service. The tag objects, or 'x-kong-service-groups' entries can hold the defaults
+	// and plugins for the group services.
+	ServiceGrouping string
+	// Generate a consumer group per scope required by the security requirements, and an 'acl' plugin on each
+	// route allowing the groups of the operation. The document level 'x-kong-consumer-groups' maps scopes to
+	// group names, to rename or merge groups. Use this to enforce scopes without openid-connect.
+	ConsumerGroups bool
+	// Infer 'rate-limiting', 'proxy-cache', and 'response-transformer' plugins from hints in the spec;
+	// rate limit extensions, 'Cache-Control' response headers, and deprecated operations. The mapping
+	// table can be configured in '/components/x-kong/policy-inference'. Explicit plugins take precedence.
+	InferPolicies bool
+	// Path of the spec in the developer portal. If set, then each service gets a 'document_objects' entry
+	// referencing it. Empty to not generate document objects. See SanitizeSpec for the spec to publish.
+	DocumentPath string
+	// ID strategy; "name" (default), "operation-id", or "extension". With "operation-id" the IDs of the
+	// entities generated from an operation are based on its operationId, instead of the names, scoped to
+	// the document by the DocName or else 'info.title'. With "extension" they are based on the 'x-kong-id'
+	// of the document, path, operation, or service group the entities are generated from; a UUID value
+	// is used as-is for its service, or else its route.
+	IDStrategy string
+	// ID pins, mapping previously generated IDs to the entities that should keep them, as
+	// "<entity-type>:<name>". Use this to keep IDs stable when refactoring the spec. See ParseIDPins.
+	IDPins map[string]string
+	// OpenAPI Overlay documents (JSON or YAML) to apply to the spec before converting, in order. Use these
+	// to keep the 'x-kong-...' directives out of the spec itself.
+	Overlays [][]byte
 }
 
 // setDefaults sets the defaults for the OpenAPI2Kong operation.
@@ -144,144 +207,6 @@ func getUpstreamDefaults(
 	return openapitools.GetXKongObject(extensions, "x-kong-upstream-defaults", components)
 }
 
-// getOIDCdefaults returns a JSON string containing the defaults from the SecurityRequirements. The type must
-// be "openIdConnect" or an error is returned. If there are no security requirements, it returns the "inherited" value.
-// If the extension is not there it will return an empty map. If the entry is not a
-// Json object, it will return an error.
-func getOIDCdefaults(
-	requirements []*openapibase.SecurityRequirement, // the security requirements to parse
-	doc v3.Document, // the complete OAS document
-	inherited []byte, // the inherited OIDC defaults
-	ignoreSecurityErrors bool, // ignore unsupported security requirements (return "inherited" instead of error)
-) ([]byte, error) {
-	// Collect the OAS specific properties
-	var (
-		// requirements openapi3.SecurityRequirements // the security requirements to parse
-		schemeName string             // the name of the security-scheme
-		scopes     []string           // the scopes required for the security-scheme
-		scheme     *v3.SecurityScheme // the security-scheme object
-	)
-	{
-		if len(requirements) == 0 || ignoreSecurityErrors {
-			// no security requirements or nothing is defined
-			// so return inherited (can be nil)
-			return inherited, nil
-		}
-
-		if len(requirements) > 1 && !ignoreSecurityErrors {
-			return nil, fmt.Errorf("only a single security-requirement is supported")
-		}
-
-		requirement := requirements[0].Requirements
-		if requirement.Len() == 0 || ignoreSecurityErrors {
-			return inherited, nil // there is nothing defined, so return inherited (can be nil)
-		}
-
-		if requirement.Len() > 1 && !ignoreSecurityErrors {
-			// multiple schemes are a logical AND, which is not supported
-			return nil, fmt.Errorf("within a security-requirement only a single security-scheme is supported")
-		}
-
-		// requirement has only 1 entry
-		// So, we won't iterate
-		reqPair := requirement.First()
-		schemeName = reqPair.Key()
-		scopes = reqPair.Value()
-
-		schemes := doc.Components.SecuritySchemes
-		scheme, _ = schemes.Get(schemeName)
-
-		if scheme == nil {
-			if !ignoreSecurityErrors {
-				return nil, fmt.Errorf("no security-schemes with name '%s' found in components", schemeName)
-			}
-			return inherited, nil
-		}
-
-		// Check if scheme type is openIdConnect (case-insensitive, accepting camelCase, kebab-case and snake_case)
-		normalizedType := strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(scheme.Type, "_", ""), "-", ""))
-		if normalizedType != "openidconnect" {
-			// non-OIDC security directives are not supported
-			if !ignoreSecurityErrors {
-				return nil, fmt.Errorf("only security-schemes of type 'openIdConnect' are supported")
-			}
-			return inherited, nil
-		}
-	}
-
-	// Construct the base plugin object from x-kong-security...
-	var (
-		pluginBase   map[string]interface{} // the plugin object
-		pluginConfig map[string]interface{} // the plugin.config object
-	)
-	{
-		kongComponents, err := openapitools.GetXKongComponents(doc)
-		if err != nil {
-			return nil, err
-		}
-
-		// grab the base plugin config from the x-kong-... directive
-		pluginBaseData, err := openapitools.GetXKongObject(
-			scheme.Extensions, "x-kong-security-openid-connect", kongComponents)
-		if err != nil {
-			return nil, err
-		}
-		if pluginBaseData == nil {
-			// no x-kong-... plugin config, so create an empty one
-			pluginBase = make(map[string]interface{})
-		} else {
-			pluginBase, _ = filebasics.Deserialize(pluginBaseData)
-		}
-
-		// ensure we have a plugin.config object
-		if pluginBase["config"] == nil {
-			pluginBase["config"] = make(map[string]interface{})
-		}
-		pluginConfig, err = jsonbasics.ToObject(pluginBase["config"])
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Collect all required scopes, from OAS and x-kong-security..
-	var ScopesRequired []string
-	{
-		var err error
-		ScopesRequired, err = jsonbasics.GetStringArrayField(pluginConfig, "scopes_required")
-		if err != nil {
-			return nil, err
-		}
-
-		// merge the scopes from the security-requirement with the scopes from the plugin config
-		for _, scope1 := range scopes {
-			duplicate := false
-			for _, scope2 := range ScopesRequired {
-				if scope1 == scope2 {
-					duplicate = true
-					break
-				}
-			}
-			if !duplicate {
-				ScopesRequired = append(ScopesRequired, scope1)
-			}
-		}
-		// sort scopesRequired array for deterministic output
-		sort.Strings(ScopesRequired)
-	}
-
-	// construct the final plugin
-	pluginBase["name"] = "openid-connect"
-	pluginConfig["scopes_required"] = ScopesRequired
-	if scheme.OpenIdConnectUrl != "" && pluginConfig["issuer"] == nil {
-		// only set the issuer if it wasn't already set in the plugin config, because the
-		// x-kong-... specifies the Kong behaviour, the OAS specifies the service-behind-kong
-		// behaviour. So the former should win.
-		pluginConfig["issuer"] = scheme.OpenIdConnectUrl
-	}
-
-	return filebasics.Serialize(pluginBase, filebasics.OutputFormatJSON)
-}
-
 // create plugin id
 func createPluginID(uuidNamespace uuid.UUID, baseName string, config map[string]interface{}) string {
 	pluginName := config["name"].(string) // safe because it was previously parsed
@@ -380,14 +305,24 @@ func getPluginsList(
 // and return it as a JSON string, along with the updated plugin list. If there
 // is none, the returned config will be the currentConfig.
 func getValidatorPlugin(list *[]*map[string]interface{}, currentConfig []byte) ([]byte, *[]*map[string]interface{}) {
-	// search for the request-validator plugin
+	return extractPluginConfig(list, "request-validator", currentConfig)
+}
+
+// extractPluginConfig will remove the named plugin config from the plugin list
+// and return it as a JSON string, along with the updated plugin list. If there
+// is none, the returned config will be the currentConfig.
+func extractPluginConfig(
+	list *[]*map[string]interface{},
+	name string,
+	currentConfig []byte,
+) ([]byte, *[]*map[string]interface{}) {
 	if list == nil {
 		return currentConfig, list
 	}
 
 	for i, plugin := range *list {
 		pluginName := (*plugin)["name"].(string) // safe because it was previously parsed
-		if pluginName == "request-validator" {
+		if pluginName == name {
 			// found it. Serialize to JSON and remove from list
 			jsonConfig, _ := json.Marshal(plugin)
 			l := append((*list)[:i], (*list)[i+1:]...)
@@ -395,7 +330,7 @@ func getValidatorPlugin(list *[]*map[string]interface{}, currentConfig []byte) (
 		}
 	}
 
-	// no validator config found, so current config remains valid
+	// no config found, so current config remains valid
 	return currentConfig, list
 }
 
@@ -616,6 +551,66 @@ func (c *serviceCache) set(key string, service map[string]interface{}) {
 	c.services[key] = service
 }
 
+// convertPathToRegex converts an OAS path into a regex for a Kong route (without the "~" prefix and
+// "$" anchor), with the path parameters as named captures. Returns the regex, and the regex_priority
+// for the route.
+func convertPathToRegex(pathKey string, operation *v3.Operation, pathitem *v3.PathItem, insoCompat bool,
+) (string, int, error) {
+	// Escape path contents for regex creation
+	convertedPath := pathKey
+	charsToEscape := []string{"(", ")", ".", "+", "?", "*", "[", "$"}
+	for _, char := range charsToEscape {
+		convertedPath = strings.ReplaceAll(convertedPath, char, "\\"+char)
+	}
+
+	// convert path parameters to regex captures
+	re, _ := regexp.Compile("{([^}]+)}")
+	regexPriority := regexPriorityPlain
+	if matches := re.FindAllStringSubmatch(convertedPath, -1); matches != nil {
+		regexPriority = regexPriorityWithPathParams
+		for _, match := range matches {
+			varName := match[1]
+			// match single segment; '/', '?', and '#' can mark the end of a segment
+			// see https://github.com/OAI/OpenAPI-Specification/issues/291#issuecomment-316593913
+			captureName := openapitools.SanitizeRegexCapture(varName, insoCompat)
+			if len(captureName) >= 32 {
+				return "", 0, fmt.Errorf("path-parameter name exceeds 32 characters: '%s' (sanitized to '%s')",
+					varName, captureName)
+			}
+			regexMatch := "(?<" + captureName + ">[^#?/]+)"
+			paramSchema := findParameterSchema(operation.Parameters, pathitem.Parameters, varName)
+			// Check if the parameter has a minLength defined, if 0, allow empty string
+			if paramSchema != nil && paramSchema.MinLength != nil && *paramSchema.MinLength == 0 {
+				regexMatch = "(?<" + captureName + ">[^#?/]*)"
+			}
+			placeHolder := "{" + varName + "}"
+			logbasics.Debug("replacing path parameter", "parameter", placeHolder, "regex", regexMatch)
+			convertedPath = strings.Replace(convertedPath, placeHolder, regexMatch, 1)
+		}
+	}
+	return convertedPath, regexPriority, nil
+}
+
+// setRegexPriority sets the regex_priority of the route, unless provided in the route defaults. A provided
+// value represents the plain path, so a path with parameters gets a lower one.
+func setRegexPriority(route map[string]interface{}, regexPriority int) error {
+	if _, found := route["regex_priority"]; !found {
+		route["regex_priority"] = regexPriority
+	} else {
+		// a regex_priority was provided in the defaults
+		currentRegexPrio, err := jsonbasics.GetInt64Field(route, "regex_priority")
+		if err != nil {
+			return fmt.Errorf("failed to parse 'regex_priority' from route defaults: %w", err)
+		}
+		// the default in x-kong-route-defaults represents the plain path, path-parameter path needs to be lower
+		if regexPriority == regexPriorityWithPathParams {
+			// this is a path with parameters, so we need to lower the priority
+			route["regex_priority"] = currentRegexPrio - 1
+		}
+	}
+	return nil
+}
+
 // MustConvert is the same as Convert, but will panic if an error is returned.
 func MustConvert(content []byte, opts O2kOptions) map[string]interface{} {
 	result, err := Convert(content, opts)
@@ -625,11 +620,101 @@ func MustConvert(content []byte, opts O2kOptions) map[string]interface{} {
 	return result
 }
 
+// checkRouteConflicts analyzes the generated routes for conflicts and logs them. Returns an
+// error listing the conflicts if failOnConflicts is set.
+func checkRouteConflicts(
+	result map[string]interface{},
+	routeOrigins map[string]routeconflicts.Origin,
+	failOnConflicts bool,
+) error {
+	// the analyzer works on plain JSON data, so convert the typed result
+	conflicts, err := routeconflicts.Analyze(jsonbasics.DeepCopyObject(result), routeOrigins)
+	if err != nil {
+		return fmt.Errorf("failed to analyze the routes for conflicts: %w", err)
+	}
+
+	errs := make([]error, len(conflicts))
+	for i, conflict := range conflicts {
+		logbasics.Info("route conflict: " + conflict.String())
+		errs[i] = errors.New(conflict.String())
+	}
+	if failOnConflicts && len(conflicts) > 0 {
+		return fmt.Errorf("found %d route conflicts: %w", len(conflicts), errors.Join(errs...))
+	}
+	return nil
+}
+
+// applyOverlays returns the content with the overlays applied, in order.
+func applyOverlays(content []byte, overlayContents [][]byte) ([]byte, error) {
+	if len(overlayContents) == 0 {
+		return content, nil
+	}
+	overlays := make([]*overlay.Overlay, len(overlayContents))
+	for i, overlayContent := range overlayContents {
+		var err error
+		if overlays[i], err = overlay.Parse(overlayContent); err != nil {
+			return nil, fmt.Errorf("failed to parse overlay %d: %w", i, err)
+		}
+	}
+	return overlay.ApplyToContent(content, overlays...)
+}
+
 // Convert converts an OpenAPI spec to a Kong declarative file.
 func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
+	return convert(content, opts, nil, make(map[string]routeconflicts.Origin))
+}
+
+// ConvertWithProvenance is the same as Convert, but also returns the provenance of the generated
+// entities; the OAS objects, and 'x-kong-...' extensions they were generated from.
+func ConvertWithProvenance(content []byte, opts O2kOptions) (map[string]interface{}, Provenance, error) {
+	tracker := newProvenanceTracker(opts.Tags != nil)
+	result, err := convert(content, opts, tracker, make(map[string]routeconflicts.Origin))
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, tracker.build(result), nil
+}
+
+// convert converts an OpenAPI spec to a Kong declarative file. The origins of the entities are
+// recorded in the tracker, if given. The OAS operations of the routes are recorded in routeOrigins,
+// by route name.
+func convert(
+	content []byte,
+	opts O2kOptions,
+	tracker *provenanceTracker,
+	routeOrigins map[string]routeconflicts.Origin,
+) (map[string]interface{}, error) {
 	opts.setDefaults()
 	logbasics.Debug("received OpenAPI2Kong options", "options", opts)
 
+	routerFlavor, err := validateRouterFlavor(opts.RouterFlavor)
+	if err != nil {
+		return nil, err
+	}
+	if routerFlavor == RouterFlavorExpressions && opts.FailOnRouteConflicts {
+		// the conflicts analysis only covers traditional routes, so it would always pass
+		return nil, fmt.Errorf("failing on route conflicts is not supported with the '%s' router flavor",
+			RouterFlavorExpressions)
+	}
+	if err = validateMockMode(opts.Mock); err != nil {
+		return nil, err
+	}
+	serviceGrouping, err := validateServiceGrouping(opts.ServiceGrouping)
+	if err != nil {
+		return nil, err
+	}
+	idStrategy, err := validateIDStrategy(opts.IDStrategy)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SkipID && (idStrategy != IDStrategyName || len(opts.IDPins) > 0) {
+		return nil, errors.New("an id strategy, or id pins, cannot be combined with skipping ids")
+	}
+	if tracker == nil && idStrategy != IDStrategyName {
+		// the strategy needs the origins of the entities
+		tracker = newProvenanceTracker(opts.Tags != nil)
+	}
+
 	// set up output document
 	result := make(map[string]interface{})
 	result[formatVersionKey] = formatVersionValue
@@ -637,25 +722,34 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 	upstreams := make([]interface{}, 0)
 
 	var (
-		err              error
 		removeDocService bool // set to true if no docServers are present;
 		// it's used in case services is empty
 		doc            v3.Document             // the OAS3 document we're operating on
 		kongComponents *map[string]interface{} // contents of OAS key `/components/x-kong/`
 		kongTags       []string                // tags to attach to Kong entities
+		oas31          bool                    // the document is OAS 3.1+, so its schemas are JSONschema 2020-12
 		nameConcatChar string                  // character to use for concatenating names
 
-		docBaseName         string                     // the slugified basename for the document
-		docServers          []*v3.Server               // servers block on document level
-		docServiceDefaults  []byte                     // JSON string representation of service-defaults on document level
-		docService          map[string]interface{}     // service entity in use on document level
-		docUpstreamDefaults []byte                     // JSON string representation of upstream-defaults on document level
-		docUpstream         map[string]interface{}     // upstream entity in use on document level
-		docRouteDefaults    []byte                     // JSON string representation of route-defaults on document level
-		docPluginList       *[]*map[string]interface{} // array of plugin configs, sorted by plugin name
-		docValidatorConfig  []byte                     // JSON string representation of validator config to generate
-		docOIDCdefaults     []byte                     // JSON string representation of OIDC config to generate
-		foreignKeyPlugins   *[]*map[string]interface{} // top-level array of plugin configs, sorted by plugin name+id
+		docBaseName           string                     // the slugified basename for the document
+		docIDKey              string                     // the stable document key for the operation based IDs
+		docServers            []*v3.Server               // servers block on document level
+		docServiceDefaults    []byte                     // JSON string representation of service-defaults on document level
+		docService            map[string]interface{}     // service entity in use on document level
+		docUpstreamDefaults   []byte                     // JSON string representation of upstream-defaults on document level
+		docUpstream           map[string]interface{}     // upstream entity in use on document level
+		docRouteDefaults      []byte                     // JSON string representation of route-defaults on document level
+		docRouteGranularity   string                     // route granularity on document level
+		docPluginList         *[]*map[string]interface{} // array of plugin configs, sorted by plugin name
+		docValidatorConfig    []byte                     // JSON string representation of validator config to generate
+		docRespValidatorCfg   []byte                     // JSON string representation of response validator config
+		docSecurityPlugins    *[]*map[string]interface{} // array of security plugin configs, sorted by plugin name
+		docSecurityOnRoutes   bool                       // doc-level security plugins go on routes, not services
+		anonymousConsumer     string                     // username of the consumer for anonymous access
+		anonymousConsumerUsed bool                       // a security plugin references the anonymous consumer
+		securityErrors        []error                    // unsupported security requirements found on operations
+		consumerGroupNames    map[string]string          // consumer group names by scope, from x-kong-consumer-groups
+		consumerGroups        map[string]bool            // the consumer groups allowed by the acl plugins
+		foreignKeyPlugins     *[]*map[string]interface{} // top-level array of plugin configs, sorted by plugin name+id
 
 		pathBaseName         string                     // the slugified basename for the path
 		pathServers          []*v3.Server               // servers block on current path level
@@ -664,8 +758,13 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 		pathUpstreamDefaults []byte                     // JSON string representation of upstream-defaults on path level
 		pathUpstream         map[string]interface{}     // upstream entity in use on path level
 		pathRouteDefaults    []byte                     // JSON string representation of route-defaults on path level
+		pathRouteGranularity string                     // route granularity on path level
+		pathRouteCandidates  []routeCandidate           // routes of the path operations, that can be merged
 		pathPluginList       *[]*map[string]interface{} // array of plugin configs, sorted by plugin name
 		pathValidatorConfig  []byte                     // JSON string representation of validator config to generate
+		pathRespValidatorCfg []byte                     // JSON string representation of response validator config
+		pathHasValidator     bool                       // the path level defines a validator config itself
+		pathHasRespValidator bool                       // the path level defines a response validator config itself
 
 		operationBaseName         string                     // the slugified basename for the operation
 		operationServers          []*v3.Server               // servers block on current operation level
@@ -676,6 +775,7 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 		operationRouteDefaults    []byte                     // JSON string representation of route-defaults on ops level
 		operationPluginList       *[]*map[string]interface{} // array of plugin configs, sorted by plugin name
 		operationValidatorConfig  []byte                     // JSON string representation of validator config to generate
+		operationRespValidatorCfg []byte                     // JSON string representation of response validator config
 	)
 
 	if opts.InsoCompat {
@@ -690,6 +790,12 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 	// (all plugins go to routes). Mixing them would cause plugin duplication.
 	pathSvcCache := newServiceCache()
 	opSvcCache := newServiceCache()
+	serviceGroups := make(map[string]*serviceGroup) // services per slugified group name, when grouping by tag/extension
+
+	// Apply the overlays before anything else, they may add the x-kong-... directives
+	if content, err = applyOverlays(content, opts.Overlays); err != nil {
+		return nil, err
+	}
 
 	// Load and parse the OAS file
 	openapiDoc, err := libopenapi.NewDocument(content)
@@ -697,20 +803,43 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 		return nil, fmt.Errorf("error parsing OAS3 file: [%w]", err)
 	}
 
+	// Swagger 2.0 documents are upgraded to OAS3 first, and then converted as usual
+	if openapiDoc.GetSpecInfo().SpecFormat == datamodel.OAS2 {
+		logbasics.Info("upgrading Swagger 2.0 document to OpenAPI 3")
+		if content, err = upgradeSwagger2(content); err != nil {
+			return nil, fmt.Errorf("failed to upgrade Swagger 2.0 document: %w", err)
+		}
+		if openapiDoc, err = libopenapi.NewDocument(content); err != nil {
+			return nil, fmt.Errorf("error parsing upgraded Swagger 2.0 file: [%w]", err)
+		}
+	}
+
 	// Check if circular references must be ignored
+	docConfig := &datamodel.DocumentConfiguration{} // an empty configuration matches the libopenapi defaults
 	if opts.IgnoreCircularRefs {
-		docConfig := datamodel.NewDocumentConfiguration()
+		docConfig = datamodel.NewDocumentConfiguration()
 		docConfig.IgnoreArrayCircularReferences = true
 		docConfig.IgnorePolymorphicCircularReferences = true
-		openapiDoc.SetConfiguration(docConfig)
 	}
 
+	// Configure the resolving of references to other files, always offline
+	referenceErrors, err := openapitools.ConfigureReferences(docConfig, openapitools.ReferenceOptions{
+		BasePath:      opts.BasePath,
+		FS:            opts.FS,
+		RemoteMirrors: opts.RemoteRefMirrors,
+	})
+	if err != nil {
+		return nil, err
+	}
+	openapiDoc.SetConfiguration(docConfig)
+
 	// var errors []error
 	v3Model, errs := openapiDoc.BuildV3Model()
 
 	// if anything went wrong when building the v3 model,
 	// an error will be returned
 	if errs != nil {
+		errs = errors.Join(referenceErrors(), errs)
 		logbasics.Error(errs, "error while building v3 document model \n")
 		return nil, fmt.Errorf("cannot create v3 model from document: %w", errs)
 	}
@@ -719,6 +848,9 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 		doc = v3Model.Model
 	}
 
+	// OAS 3.1 schemas are JSONschema 2020-12, OAS 3.0 schemas are draft4 based
+	oas31 = !strings.HasPrefix(doc.Version, "3.0")
+
 	//
 	//
 	//  Handle OAS Document level
@@ -758,10 +890,33 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 	docBaseName = openapitools.Slugify(opts.InsoCompat, docBaseName)
 	logbasics.Info("document name (namespace for UUID generation)", "name", docBaseName)
 
+	// the key scoping the operation based IDs does not follow 'x-kong-name', so renaming the
+	// entities keeps their IDs, precedence: specified -> Info.Title -> document name
+	docIDKey = docBaseName
+	if opts.DocName == "" && doc.Info != nil && doc.Info.Title != "" {
+		docIDKey = openapitools.Slugify(opts.InsoCompat, doc.Info.Title)
+	}
+
 	if kongComponents, err = openapitools.GetXKongComponents(doc); err != nil {
 		return nil, err
 	}
 
+	var mockSpec string // the spec for the mocking plugins, without the 'x-kong' extensions
+	if opts.Mock == MockMocking {
+		sanitized, err := sanitizeSpec(content, false)
+		if err != nil {
+			return nil, err
+		}
+		mockSpec = string(sanitized)
+	}
+
+	var policies *policyMapping // the mapping table for policy inference, nil if disabled
+	if opts.InferPolicies {
+		if policies, err = getPolicyMapping(kongComponents); err != nil {
+			return nil, err
+		}
+	}
+
 	// for defaults we keep strings, so deserializing them provides a copy right away
 	if docServiceDefaults, err = getServiceDefaults(doc.Extensions, kongComponents); err != nil {
 		return nil, err
@@ -772,6 +927,9 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 	if docRouteDefaults, err = openapitools.GetRouteDefaults(doc.Extensions, kongComponents); err != nil {
 		return nil, err
 	}
+	if docRouteGranularity, err = getRouteGranularity(doc.Extensions, RouteGranularityOperation); err != nil {
+		return nil, err
+	}
 
 	// create the top-level docService and (optional) docUpstream
 	docService, docUpstream, err = openapitools.CreateKongService(docBaseName, docServers, docServiceDefaults,
@@ -779,6 +937,9 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create service/upstream from document root: %w", err)
 	}
+	docLevel := provenanceLevel{pointer: "#", extensions: doc.Extensions}
+	tracker.addEntity("service", docService, docLevel.pointer, docLevel)
+	tracker.addEntity("upstream", docUpstream, docLevel.pointer, docLevel)
 
 	services = append(services, docService)
 	// if there are no document-level servers defined
@@ -810,21 +971,51 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 		return nil, fmt.Errorf("failed to create plugins list from document root: %w", err)
 	}
 
-	// get the OIDC stuff from top level, bail out if the requirements are unsupported
+	// an inferred rate limit on the document level is placed like the doc-level plugins, on the services
+	docRateLimiting, err := inferRateLimitingPlugin(policies, []provenanceLevel{docLevel}, opts.UUIDNamespace,
+		docBaseName, kongTags, opts.SkipID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to infer policies for the document: %w", err)
+	}
+	docPluginList = insertPlugin(docPluginList, docRateLimiting)
+
+	// get the security plugins from top level, bail out if the requirements are unsupported
 	if opts.OIDC {
-		docOIDCdefaults, err = getOIDCdefaults(doc.Security, doc, nil, opts.IgnoreSecurityErrors)
+		anonymousConsumer = docBaseName + nameConcatChar + "anonymous"
+		docSecurityPlugins, err = getSecurityPlugins(doc.Security, doc, anonymousConsumer, nil,
+			opts.IgnoreSecurityErrors)
 		if err != nil {
 			return nil, err
 		}
-		if docOIDCdefaults != nil {
-			// we have OIDC defaults, so we need to add the plugin to the doc-level list
-			pluginConfig, _ := filebasics.Deserialize(docOIDCdefaults)
-			docPluginList = insertPlugin(docPluginList, &pluginConfig)
+		docSecurityOnRoutes = needRouteLevelSecurity(doc, docSecurityPlugins, anonymousConsumer)
+		anonymousConsumerUsed = usesAnonymousConsumer(docSecurityPlugins, anonymousConsumer)
+		if docSecurityPlugins != nil && !docSecurityOnRoutes {
+			// we have security plugins, so we need to add them to the doc-level list
+			for _, plugin := range *docSecurityPlugins {
+				pluginConfig := jsonbasics.DeepCopyObject(*plugin)
+				docPluginList = insertPlugin(docPluginList, &pluginConfig)
+			}
+		}
+	}
+
+	if opts.ConsumerGroups {
+		consumerGroups = make(map[string]bool)
+		if consumerGroupNames, err = getConsumerGroupNames(doc.Extensions); err != nil {
+			return nil, err
 		}
 	}
 
 	// Extract the request-validator config from the plugin list
 	docValidatorConfig, docPluginList = getValidatorPlugin(docPluginList, docValidatorConfig)
+	if opts.ValidateResponses {
+		// response validation is opt-in, by default with an empty config
+		docRespValidatorCfg, _ = json.Marshal(map[string]interface{}{
+			"name": responseValidatorPlugin,
+			"tags": kongTags,
+		})
+		docRespValidatorCfg, docPluginList = extractPluginConfig(docPluginList, responseValidatorPlugin,
+			docRespValidatorCfg)
+	}
 
 	// move consumer bound plugins to doc level plugins list (multiple foreign keys)
 	foreignKeyPlugins, docPluginList = getForeignKeyPlugins(
@@ -889,6 +1080,16 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			pathBaseName = docBaseName
 		}
 		logbasics.Debug("path name (namespace for UUID generation)", "name", pathBaseName)
+		pathLevel := provenanceLevel{pointer: "#/paths/" + escapeJSONPointer(pathKey), extensions: pathitem.Extensions}
+
+		// an inferred rate limit on the path level is placed like the path-level plugins, on the path service
+		// if it has one, or else on the routes of the path
+		var pathRateLimiting *map[string]interface{}
+		pathRateLimiting, err = inferRateLimitingPlugin(policies, []provenanceLevel{docLevel, pathLevel},
+			opts.UUIDNamespace, pathBaseName, kongTags, opts.SkipID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to infer policies for path '%s': %w", pathKey, err)
+		}
 
 		// Set up the defaults on the Path level
 		newPathService := false
@@ -900,6 +1101,10 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 		} else {
 			newPathService = true
 		}
+		if serviceGrouping == ServiceGroupingPath {
+			// every path gets its own service
+			newPathService = true
+		}
 
 		newUpstream := false
 		if pathUpstreamDefaults, err = getUpstreamDefaults(pathitem.Extensions, kongComponents); err != nil {
@@ -918,6 +1123,10 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 		if pathRouteDefaults == nil {
 			pathRouteDefaults = docRouteDefaults
 		}
+		if pathRouteGranularity, err = getRouteGranularity(pathitem.Extensions, docRouteGranularity); err != nil {
+			return nil, fmt.Errorf("path '%s': %w", pathKey, err)
+		}
+		pathRouteCandidates = make([]routeCandidate, 0)
 
 		// if there is no path level servers block, use the document one
 		pathServers = pathitem.Servers
@@ -935,7 +1144,7 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 		// 3. The path has no path-level plugins (to avoid plugin conflicts)
 		// 4. An identical service configuration already exists in the cache
 		var reusedPathService bool
-		if opts.ReuseServices && newPathService && !hasPathLevelPlugins(pathitem.Extensions) {
+		if opts.ReuseServices && newPathService && !hasPathLevelPlugins(pathitem.Extensions) && pathRateLimiting == nil {
 			serviceKey := generateServiceKey(pathServers, pathServiceDefaults, pathUpstreamDefaults)
 			if cachedService, found := pathSvcCache.get(serviceKey); found {
 				logbasics.Debug("reusing existing service for path", "path", pathKey, "service", cachedService["name"])
@@ -960,6 +1169,7 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			if err != nil {
 				return nil, fmt.Errorf("failed to create service/updstream from path '%s': %w", path, err)
 			}
+			tracker.addEntity("service", pathService, pathLevel.pointer, docLevel, pathLevel)
 
 			// collect path plugins, including the doc-level plugins since we have a new service entity
 			pathPluginList, err = getPluginsList(pathitem.Extensions, nil, docPluginList,
@@ -967,9 +1177,15 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			if err != nil {
 				return nil, fmt.Errorf("failed to create plugins list from path item: %w", err)
 			}
+			pathPluginList = insertPlugin(pathPluginList, pathRateLimiting)
 
 			// Extract the request-validator config from the plugin list
 			pathValidatorConfig, pathPluginList = getValidatorPlugin(pathPluginList, docValidatorConfig)
+			pathRespValidatorCfg = docRespValidatorCfg
+			if opts.ValidateResponses {
+				pathRespValidatorCfg, pathPluginList = extractPluginConfig(pathPluginList, responseValidatorPlugin,
+					docRespValidatorCfg)
+			}
 
 			// move consumer bound plugins to doc level plugins list (multiple foreign keys)
 			foreignKeyPlugins, pathPluginList = getForeignKeyPlugins(
@@ -983,6 +1199,7 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 				if newUpstream {
 					// we need it, so store and use it
 					upstreams = append(upstreams, pathUpstream)
+					tracker.addEntity("upstream", pathUpstream, pathLevel.pointer, docLevel, pathLevel)
 				} else {
 					// we don't need it, so update service to point to 'upper' upstream
 					pathService["host"] = docService["host"]
@@ -990,16 +1207,17 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			}
 
 			// Cache the new service for potential reuse by subsequent paths (only if enabled and no plugins)
-			if opts.ReuseServices && !hasPathLevelPlugins(pathitem.Extensions) {
+			if opts.ReuseServices && !hasPathLevelPlugins(pathitem.Extensions) && pathRateLimiting == nil {
 				serviceKey := generateServiceKey(pathServers, pathServiceDefaults, pathUpstreamDefaults)
 				pathSvcCache.set(serviceKey, pathService)
 			}
 		} else if reusedPathService {
-			// Service reuse is gated on !hasPathLevelPlugins, so there are no
+			// Service reuse is gated on !hasPathLevelPlugins (and no inferred rate limit), so there are no
 			// path-level plugins to collect. Just reset to empty/inherited values.
 			emptyList := make([]*map[string]interface{}, 0)
 			pathPluginList = &emptyList
 			pathValidatorConfig = docValidatorConfig
+			pathRespValidatorCfg = docRespValidatorCfg
 		} else {
 			// no new path-level service entity required, so stick to the doc-level one
 			pathService = docService
@@ -1010,9 +1228,18 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			if err != nil {
 				return nil, fmt.Errorf("failed to create plugins list from path item: %w", err)
 			}
+			pathPluginList = insertPlugin(pathPluginList, pathRateLimiting)
+			// operations might move to a group service, which has its own validator configs
+			pathHasValidator = hasPlugin(pathPluginList, "request-validator")
+			pathHasRespValidator = hasPlugin(pathPluginList, responseValidatorPlugin)
 
 			// Extract the request-validator config from the plugin list
 			pathValidatorConfig, pathPluginList = getValidatorPlugin(pathPluginList, docValidatorConfig)
+			pathRespValidatorCfg = docRespValidatorCfg
+			if opts.ValidateResponses {
+				pathRespValidatorCfg, pathPluginList = extractPluginConfig(pathPluginList, responseValidatorPlugin,
+					docRespValidatorCfg)
+			}
 		}
 
 		//
@@ -1042,10 +1269,16 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			}
 
 			methodKey = strings.ToUpper(methodKey)
+			operationLevel := provenanceLevel{
+				pointer:    pathLevel.pointer + "/" + strings.ToLower(methodKey),
+				extensions: operation.Extensions,
+			}
+			operationLevels := []provenanceLevel{docLevel, pathLevel, operationLevel} // the levels applying to the route
 
 			logbasics.Info("processing operation", "method", methodKey, "path", path, "id", operation.OperationId)
 
-			var operationRoutes []interface{} // the routes array we need to add to
+			var operationRoutes []interface{}                  // the routes array we need to add to
+			var operationTerminations []map[string]interface{} // consumer bound security plugins for the route
 
 			// determine operation name, precedence: specified -> operation-ID -> method-name
 			if operationBaseName, err = getKongName(operation.Extensions); err != nil {
@@ -1077,6 +1310,35 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			}
 			logbasics.Debug("operation base name (namespace for UUID generation)", "name", operationBaseName)
 
+			// resolve the security first, an operation with unsupported requirements is skipped before any
+			// of its entities are created, or in strict mode collected such that all of them can be reported
+			var operationSecurityPlugins *[]*map[string]interface{}
+			if opts.OIDC {
+				operationSecurityPlugins, err = getSecurityPlugins(operation.Security, doc, anonymousConsumer,
+					docSecurityPlugins, opts.IgnoreSecurityErrors)
+				if err != nil {
+					securityErrors = skipInsecureOperation(securityErrors, methodKey, pathKey, operation.OperationId,
+						err, opts.StrictSecurity)
+					continue
+				}
+			}
+			var allowedGroups []string // the consumer groups allowed by the required scopes
+			if opts.ConsumerGroups && findPolicyHint(operationLevels, "x-kong-plugin-acl") == nil {
+				requirements := operation.Security
+				if requirements == nil {
+					requirements = doc.Security
+				}
+				allowedGroups, err = getAllowedGroups(requirements, consumerGroupNames)
+				if err != nil {
+					if !opts.IgnoreSecurityErrors {
+						securityErrors = skipInsecureOperation(securityErrors, methodKey, pathKey, operation.OperationId,
+							err, opts.StrictSecurity)
+						continue
+					}
+					logbasics.Info("ignoring unsupported security-requirement", "error", err.Error())
+				}
+			}
+
 			// Set up the defaults on the Operation level
 			newOperationService := false
 			if operationServiceDefaults, err = getServiceDefaults(operation.Extensions, kongComponents); err != nil {
@@ -1144,12 +1406,14 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 				if err != nil {
 					return nil, fmt.Errorf("failed to create service/updstream from operation '%s %s': %w", pathKey, methodKey, err)
 				}
+				tracker.addEntity("service", operationService, operationLevel.pointer, operationLevels...)
 				services = append(services, operationService)
 				if operationUpstream != nil {
 					// we have a new upstream, but do we need it?
 					if newUpstream {
 						// we need it, so store and use it
 						upstreams = append(upstreams, operationUpstream)
+						tracker.addEntity("upstream", operationUpstream, operationLevel.pointer, operationLevels...)
 					} else {
 						// we don't need it, so update service to point to 'upper' upstream
 						operationService["host"] = pathService["host"]
@@ -1168,6 +1432,66 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 				operationRoutes = operationService["routes"].([]interface{})
 			}
 
+			// operations on the doc-level service move to the service of their group, if any
+			validatorBaseConfig, respValidatorBaseConfig := pathValidatorConfig, pathRespValidatorCfg
+			onDocService := !newOperationService && !reusedOperationService && !newPathService && !reusedPathService
+			if onDocService && (serviceGrouping == ServiceGroupingTag || serviceGrouping == ServiceGroupingExtension) {
+				groupName, err := getServiceGroupName(serviceGrouping, operation, pathitem)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get service group of operation '%s %s': %w", methodKey, pathKey, err)
+				}
+				if groupName != "" {
+					// keyed like the service name, since eg. tags "pets" and "Pets" end up on the same service
+					groupKey := openapitools.Slugify(opts.InsoCompat, groupName)
+					group, found := serviceGroups[groupKey]
+					if !found {
+						group, err = createServiceGroup(groupName, serviceGrouping, doc, docBaseName+nameConcatChar,
+							kongComponents, kongTags, opts)
+						if err != nil {
+							return nil, fmt.Errorf("failed to create service for group '%s': %w", groupName, err)
+						}
+						serviceGroups[groupKey] = group
+						services = append(services, group.service)
+						groupLevel := provenanceLevel{pointer: group.pointer, extensions: group.extensions}
+						tracker.addEntity("service", group.service, group.pointer, docLevel, groupLevel)
+						if group.upstream != nil {
+							// the group has its own upstream defaults
+							upstreams = append(upstreams, group.upstream)
+							tracker.addEntity("upstream", group.upstream, group.pointer, docLevel, groupLevel)
+						} else if group.service["host"] == nil {
+							// use the doc-level host/upstream
+							group.service["host"] = docService["host"]
+						}
+
+						// the doc-level plugins are included since it is a new service entity
+						groupPlugins, err := getPluginsList(group.extensions, nil, docPluginList, opts.UUIDNamespace,
+							group.service["name"].(string), kongComponents, kongTags, opts.SkipID)
+						if err != nil {
+							return nil, fmt.Errorf("failed to create plugins list for group '%s': %w", groupName, err)
+						}
+						group.validatorConfig, groupPlugins = getValidatorPlugin(groupPlugins, docValidatorConfig)
+						group.respValidatorConfig = docRespValidatorCfg
+						if opts.ValidateResponses {
+							group.respValidatorConfig, groupPlugins = extractPluginConfig(groupPlugins,
+								responseValidatorPlugin, docRespValidatorCfg)
+						}
+						foreignKeyPlugins, groupPlugins = getForeignKeyPlugins(
+							foreignKeyPlugins, groupPlugins, "service", group.service["name"].(string))
+						group.service["plugins"] = groupPlugins
+					}
+					operationService = group.service
+					operationRoutes = operationService["routes"].([]interface{})
+					operationLevels = []provenanceLevel{docLevel,
+						{pointer: group.pointer, extensions: group.extensions}, pathLevel, operationLevel}
+					if !pathHasValidator {
+						validatorBaseConfig = group.validatorConfig
+					}
+					if !pathHasRespValidator {
+						respValidatorBaseConfig = group.respValidatorConfig
+					}
+				}
+			}
+
 			// collect operation plugins
 			if !newOperationService && !newPathService && !reusedPathService && !reusedOperationService {
 				// we're operating on the doc-level service entity, so we need the plugins
@@ -1179,8 +1503,10 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 				// from the document, path, and operation.
 				operationPluginList, _ = getPluginsList(doc.Extensions, nil, nil, opts.UUIDNamespace,
 					operationBaseName, kongComponents, kongTags, opts.SkipID)
+				operationPluginList = insertPlugin(operationPluginList, docRateLimiting)
 				operationPluginList, _ = getPluginsList(pathitem.Extensions, nil, operationPluginList, opts.UUIDNamespace,
 					operationBaseName, kongComponents, kongTags, opts.SkipID)
+				operationPluginList = insertPlugin(operationPluginList, pathRateLimiting)
 				operationPluginList, err = getPluginsList(operation.Extensions, nil, operationPluginList, opts.UUIDNamespace,
 					operationBaseName, kongComponents, kongTags, opts.SkipID)
 			} else if newPathService {
@@ -1199,28 +1525,84 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			}
 
 			if opts.OIDC {
-				// get the OIDC stuff from operation level, bail out if the requirements are unsupported
-				operationOIDCplugin, err := getOIDCdefaults(operation.Security, doc, docOIDCdefaults, opts.IgnoreSecurityErrors)
-				if err != nil {
-					return nil, err
+				// add the plugins to the route if they differ from the doc-level ones, or if the doc-level
+				// ones are not available on the service entity
+				if docSecurityOnRoutes || newOperationService || reusedOperationService ||
+					!equalSecurityPlugins(operationSecurityPlugins, docSecurityPlugins) {
+					if operationSecurityPlugins != nil {
+						for _, plugin := range *operationSecurityPlugins {
+							pluginConfig := jsonbasics.DeepCopyObject(*plugin)
+							if pluginConfig["consumer"] != nil {
+								// consumer bound (request-termination for anonymous access), so it goes to the
+								// top-level list, and must be duplicated for the header routes created below
+								pluginConfig["route"] = operationBaseName
+								*foreignKeyPlugins = append(*foreignKeyPlugins, &pluginConfig)
+								operationTerminations = append(operationTerminations, pluginConfig)
+							} else {
+								operationPluginList = insertPlugin(operationPluginList, &pluginConfig)
+							}
+						}
+					}
+					if usesAnonymousConsumer(operationSecurityPlugins, anonymousConsumer) {
+						anonymousConsumerUsed = true
+					}
 				}
-				if string(operationOIDCplugin) != string(docOIDCdefaults) {
-					// we have OIDC config different from the doc-level one, so we need to add the plugin to the Operation
-					pluginConfig, _ := filebasics.Deserialize(operationOIDCplugin)
-					operationPluginList = insertPlugin(operationPluginList, &pluginConfig)
+			}
+
+			// restrict access to the consumer groups of the required scopes, unless an acl plugin is defined
+			if opts.ConsumerGroups && findPolicyHint(operationLevels, "x-kong-plugin-acl") == nil {
+				for _, group := range allowedGroups {
+					consumerGroups[group] = true
+				}
+				if allowedGroups != nil {
+					operationPluginList = insertPlugin(operationPluginList,
+						generateACLPlugin(allowedGroups, opts.UUIDNamespace, operationBaseName, kongTags, opts.SkipID))
 				}
 			}
 
 			// Extract the request-validator config from the plugin list, generate it and reinsert
-			operationValidatorConfig, operationPluginList = getValidatorPlugin(operationPluginList, pathValidatorConfig)
+			operationValidatorConfig, operationPluginList = getValidatorPlugin(operationPluginList, validatorBaseConfig)
 			validatorPlugin, err := generateValidatorPlugin(operationValidatorConfig, operation, pathitem, opts.UUIDNamespace,
-				operationBaseName, opts.SkipID, opts.InsoCompat)
+				operationBaseName, opts.SkipID, opts.InsoCompat, oas31)
 			if err != nil {
 				return nil, fmt.Errorf("failed to create validator plugin: %w", err)
 			}
 
 			operationPluginList = insertPlugin(operationPluginList, validatorPlugin)
 
+			// Extract the response validator config from the plugin list, generate it and reinsert
+			operationRespValidatorCfg = respValidatorBaseConfig
+			if opts.ValidateResponses {
+				operationRespValidatorCfg, operationPluginList = extractPluginConfig(operationPluginList,
+					responseValidatorPlugin, respValidatorBaseConfig)
+			}
+			operationPluginList = insertPlugin(operationPluginList, generateResponseValidatorPlugin(
+				operationRespValidatorCfg, doc, pathKey, methodKey, pathitem, operation, opts.UUIDNamespace, operationBaseName,
+				opts.SkipID, oas31))
+
+			// add the mock plugin, unless the operation defines one itself
+			if opts.Mock != "" && !hasPlugin(operationPluginList, opts.Mock) {
+				mockPlugin, err := generateMockPlugin(opts.Mock, mockSpec, operation, opts.UUIDNamespace,
+					operationBaseName, kongTags, opts.SkipID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to create mock plugin for operation '%s %s': %w", methodKey, pathKey, err)
+				}
+				operationPluginList = insertPlugin(operationPluginList, mockPlugin)
+			}
+
+			// add the plugins inferred from the hints in the spec, unless defined explicitly
+			if policies != nil {
+				inferredPlugins, err := inferPolicyPlugins(policies, operation, methodKey, operationLevels,
+					opts.UUIDNamespace, operationBaseName, kongTags, opts.SkipID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to infer policies for operation '%s %s': %w", methodKey, pathKey, err)
+				}
+				for _, plugin := range inferredPlugins {
+					// a rate limit on the operation level replaces the one inferred on the path level
+					operationPluginList = insertPlugin(operationPluginList, plugin)
+				}
+			}
+
 			// construct the route
 			var route map[string]interface{}
 			if operationRouteDefaults != nil {
@@ -1237,61 +1619,60 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			// attach the collected plugins configs to the route
 			route["plugins"] = operationPluginList
 
-			// Escape path contents for regex creation
-			convertedPath := pathKey
-			charsToEscape := []string{"(", ")", ".", "+", "?", "*", "[", "$"}
-			for _, char := range charsToEscape {
-				convertedPath = strings.ReplaceAll(convertedPath, char, "\\"+char)
+			convertedPath, regexPriority, err := convertPathToRegex(pathKey, operation, pathitem, opts.InsoCompat)
+			if err != nil {
+				return nil, err
 			}
-
-			// convert path parameters to regex captures
-			re, _ := regexp.Compile("{([^}]+)}")
-			regexPriority := regexPriorityPlain
-			if matches := re.FindAllStringSubmatch(convertedPath, -1); matches != nil {
-				regexPriority = regexPriorityWithPathParams
-				for _, match := range matches {
-					varName := match[1]
-					// match single segment; '/', '?', and '#' can mark the end of a segment
-					// see https://github.com/OAI/OpenAPI-Specification/issues/291#issuecomment-316593913
-					captureName := openapitools.SanitizeRegexCapture(varName, opts.InsoCompat)
-					if len(captureName) >= 32 {
-						return nil, fmt.Errorf("path-parameter name exceeds 32 characters: '%s' (sanitized to '%s')",
-							varName, captureName)
-					}
-					regexMatch := "(?<" + captureName + ">[^#?/]+)"
-					paramSchema := findParameterSchema(operation.Parameters, pathitem.Parameters, varName)
-					// Check if the parameter has a minLength defined, if 0, allow empty string
-					if paramSchema != nil && paramSchema.MinLength != nil && *paramSchema.MinLength == 0 {
-						regexMatch = "(?<" + captureName + ">[^#?/]*)"
-					}
-					placeHolder := "{" + varName + "}"
-					logbasics.Debug("replacing path parameter", "parameter", placeHolder, "regex", regexMatch)
-					convertedPath = strings.Replace(convertedPath, placeHolder, regexMatch, 1)
-				}
+			if route["paths"] == nil {
+				route["paths"] = []string{"~" + convertedPath + "$"}
+			} else if routerFlavor == RouterFlavorExpressions {
+				return nil, fmt.Errorf("operation '%s %s': 'paths' in the route defaults are not supported "+
+					"by the expressions router", methodKey, pathKey)
+			} else {
+				// provided paths replace the generated regex, eg. to keep a prefix match
+				logbasics.Debug("using the paths from the route defaults", "method", methodKey, "path", pathKey)
 			}
-			route["paths"] = []string{"~" + convertedPath + "$"}
 			if !opts.SkipID {
 				route["id"] = uuid.NewSHA1(opts.UUIDNamespace, []byte(operationBaseName+".route")).String()
 			}
 			route["name"] = operationBaseName
+			routeOrigins[operationBaseName] = routeconflicts.Origin{
+				Method:      methodKey,
+				Path:        pathKey,
+				OperationID: operation.OperationId,
+			}
+			tracker.add("route", operationBaseName, operationLevel.pointer, operationLevels...)
 			route["methods"] = []string{methodKey}
 			route["tags"] = kongTags
-			if _, found := route["regex_priority"]; !found {
-				route["regex_priority"] = regexPriority
-			} else {
-				// a regex_priority was provided in the defaults
-				currentRegexPrio, err := jsonbasics.GetInt64Field(route, "regex_priority")
+			if err = setRegexPriority(route, regexPriority); err != nil {
+				return nil, err
+			}
+			if _, found := route["strip_path"]; !found {
+				route["strip_path"] = false // Default to false since we do not want to strip full-regex paths by default
+			}
+			if route["hosts"] == nil {
+				// servers with x-kong-server-variable-mode "hosts" are matched by their hostnames
+				hosts, err := openapitools.GetServerHosts(operationServers)
 				if err != nil {
-					return nil, fmt.Errorf("failed to parse 'regex_priority' from route defaults: %w", err)
+					return nil, fmt.Errorf("failed to get the hosts for operation '%s %s': %w", methodKey, pathKey, err)
 				}
-				// the default in x-kong-route-defaults represents the plain path, path-parameter path needs to be lower
-				if regexPriority == regexPriorityWithPathParams {
-					// this is a path with parameters, so we need to lower the priority
-					route["regex_priority"] = currentRegexPrio - 1
+				if len(hosts) > 0 {
+					hostList := make([]interface{}, len(hosts))
+					for i, host := range hosts {
+						hostList[i] = host
+					}
+					route["hosts"] = hostList
 				}
 			}
-			if _, found := route["strip_path"]; !found {
-				route["strip_path"] = false // Default to false since we do not want to strip full-regex paths by default
+			var routePriority int64 // priority of the route, only used with expressions
+			if routerFlavor == RouterFlavorExpressions {
+				pathRegex := "" // plain paths are matched exactly
+				if regexPriority == regexPriorityWithPathParams {
+					pathRegex = convertedPath
+				}
+				if routePriority, err = setRouteExpression(route, methodKey, pathKey, pathRegex); err != nil {
+					return nil, err
+				}
 			}
 
 			headerParams := findHeaderParamsForRouting(operation.Parameters, pathitem.Parameters, opts.TreatAllHeadersAsRequired)
@@ -1299,12 +1680,23 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			if len(headerParams) > 0 && !opts.SkipRouteByHeader {
 				// This operation has header parameters, we need to create different routes based on the header values
 				headerValueCombinations := constructHeaderCombinationsForRouting(headerParams)
+				if routerFlavor == RouterFlavorExpressions {
+					// a single route matches all header values
+					headerValueCombinations = constructHeaderAlternativesForRouting(headerParams)
+				}
 
 				newRoutes := make([]interface{}, 0)
 				for i, combination := range headerValueCombinations {
 					clonedRoute := jsonbasics.DeepCopyObject(route)
-					clonedRoute["headers"] = combination
+					if routerFlavor == RouterFlavorExpressions {
+						clonedRoute["expression"] = route["expression"].(string) + " && " + createHeaderExpression(combination)
+						clonedRoute["priority"] = routePriority + expressionPriorityHeadersOffset
+					} else {
+						clonedRoute["headers"] = combination
+					}
 					clonedRoute["name"] = fmt.Sprintf("%s_%v", operationBaseName, i)
+					routeOrigins[clonedRoute["name"].(string)] = routeOrigins[operationBaseName]
+					tracker.add("route", clonedRoute["name"].(string), operationLevel.pointer, operationLevels...)
 					if !opts.SkipID {
 						clonedRoute["id"] = uuid.NewSHA1(opts.UUIDNamespace, []byte(clonedRoute["name"].(string))).String()
 
@@ -1330,6 +1722,11 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 							}
 						}
 					}
+					for _, termination := range operationTerminations {
+						clonedTermination := jsonbasics.DeepCopyObject(termination)
+						clonedTermination["route"] = clonedRoute["name"]
+						*foreignKeyPlugins = append(*foreignKeyPlugins, &clonedTermination)
+					}
 					newRoutes = append(newRoutes, clonedRoute)
 				}
 				operationRoutes = append(operationRoutes, newRoutes...)
@@ -1339,7 +1736,234 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 			// In case they exist, this acts as a fallback route without header based routing.
 			operationRoutes = append(operationRoutes, route)
 			operationService["routes"] = operationRoutes
+
+			if (len(headerParams) == 0 || opts.SkipRouteByHeader) && len(operationTerminations) == 0 {
+				// a single route, so it can be merged into the route of the path
+				pathRouteCandidates = append(pathRouteCandidates, routeCandidate{
+					service: operationService,
+					route:   route,
+					method:  methodKey,
+					levels:  operationLevels,
+				})
+			}
+		}
+
+		if pathRouteGranularity == RouteGranularityPath {
+			mergePathRoutes(pathRouteCandidates, pathKey, pathBaseName, foreignKeyPlugins, tracker, routeOrigins,
+				opts.UUIDNamespace, opts.SkipID)
+		}
+	}
+
+	if len(securityErrors) > 0 {
+		return nil, fmt.Errorf("unsupported security requirements: %w", errors.Join(securityErrors...))
+	}
+
+	//
+	//
+	//  Handle callbacks and webhooks
+	//
+	//
+
+	var callbackService map[string]interface{} // service entity receiving the callbacks and webhooks, if any
+	if opts.Callbacks {
+		receivers, err := collectCallbackReceivers(doc, opts.InsoCompat)
+		if err != nil {
+			return nil, err
+		}
+		if len(receivers) > 0 {
+			// a dedicated service, since the requests are made by the API, and not by its clients
+			callbackBaseName := docBaseName + nameConcatChar + "callbacks"
+			var callbackUpstream map[string]interface{}
+			var callbackExtensions *orderedmap.Map[string, *yaml.Node]
+			callbackService, callbackUpstream, callbackExtensions, err = createCallbacksService(callbackBaseName, doc,
+				docServiceDefaults, docUpstreamDefaults, kongComponents, kongTags, opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create service for callbacks: %w", err)
+			}
+			callbackLevels := []provenanceLevel{docLevel}
+			if callbackExtensions != nil {
+				callbackLevels = append(callbackLevels,
+					provenanceLevel{pointer: "#/" + callbacksExtension, extensions: callbackExtensions})
+			}
+			tracker.addEntity("service", callbackService, callbackLevels[len(callbackLevels)-1].pointer,
+				callbackLevels...)
+			if callbackUpstream != nil {
+				// 'x-kong-callbacks' has its own servers or upstream defaults
+				upstreams = append(upstreams, callbackUpstream)
+				tracker.addEntity("upstream", callbackUpstream, callbackLevels[len(callbackLevels)-1].pointer,
+					callbackLevels...)
+			} else if callbackService["host"] == nil {
+				// use the doc-level host/upstream
+				callbackService["host"] = docService["host"]
+			}
+
+			// only the doc-level plugins, not the security plugins, since the API calls the callbacks
+			callbackPluginList, err := getPluginsList(doc.Extensions, componentExtensions, nil, opts.UUIDNamespace,
+				callbackBaseName, kongComponents, kongTags, opts.SkipID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create plugins list for callbacks: %w", err)
+			}
+			if callbackExtensions != nil {
+				callbackPluginList, err = getPluginsList(callbackExtensions, nil, callbackPluginList, opts.UUIDNamespace,
+					callbackBaseName, kongComponents, kongTags, opts.SkipID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to create plugins list for callbacks: %w", err)
+				}
+			}
+			var callbackValidatorConfig []byte
+			callbackValidatorConfig, callbackPluginList = getValidatorPlugin(callbackPluginList, docValidatorConfig)
+			_, callbackPluginList = extractPluginConfig(callbackPluginList, responseValidatorPlugin, nil)
+			foreignKeyPlugins, callbackPluginList = getForeignKeyPlugins(
+				foreignKeyPlugins, callbackPluginList, "service", callbackService["name"].(string))
+			callbackService["plugins"] = callbackPluginList
+
+			callbackRoutes := callbackService["routes"].([]interface{})
+			for _, receiver := range receivers {
+				logbasics.Info("processing callback", "name", receiver.name, "path", receiver.path)
+
+				operations := receiver.pathItem.GetOperations()
+				sortedMethods := make([]string, 0, operations.Len())
+				for method := operations.First(); method != nil; method = method.Next() {
+					sortedMethods = append(sortedMethods, method.Key())
+				}
+				sort.Strings(sortedMethods)
+
+				for _, methodKey := range sortedMethods {
+					operation, _ := operations.Get(methodKey)
+					methodKey = strings.ToUpper(methodKey)
+
+					// build as "doc-callback-method"
+					methodName := methodKey
+					if opts.InsoCompat {
+						methodName = strings.ToLower(methodKey)
+					}
+					routeName := docBaseName + nameConcatChar + openapitools.Slugify(opts.InsoCompat, receiver.name, methodName)
+
+					// collect the plugins from the callback path item and operation
+					routePluginList, err := getPluginsList(receiver.pathItem.Extensions, nil, nil, opts.UUIDNamespace,
+						routeName, kongComponents, kongTags, opts.SkipID)
+					if err != nil {
+						return nil, fmt.Errorf("failed to create plugins list from callback '%s': %w", receiver.name, err)
+					}
+					routePluginList, err = getPluginsList(operation.Extensions, nil, routePluginList, opts.UUIDNamespace,
+						routeName, kongComponents, kongTags, opts.SkipID)
+					if err != nil {
+						return nil, fmt.Errorf("failed to create plugins list from callback '%s': %w", receiver.name, err)
+					}
+
+					// generate the validator from the callback requestBody and parameters
+					var validatorConfig []byte
+					validatorConfig, routePluginList = getValidatorPlugin(routePluginList, callbackValidatorConfig)
+					validatorPlugin, err := generateValidatorPlugin(validatorConfig, operation, receiver.pathItem,
+						opts.UUIDNamespace, routeName, opts.SkipID, opts.InsoCompat, oas31)
+					if err != nil {
+						return nil, fmt.Errorf("failed to create validator plugin for callback '%s': %w", receiver.name, err)
+					}
+					routePluginList = insertPlugin(routePluginList, validatorPlugin)
+
+					// callbacks are not in the 'paths' served by the mocking plugin, so always use request-termination
+					if opts.Mock != "" && !hasPlugin(routePluginList, MockRequestTermination) {
+						mockPlugin, err := generateMockPlugin(MockRequestTermination, "", operation, opts.UUIDNamespace,
+							routeName, kongTags, opts.SkipID)
+						if err != nil {
+							return nil, fmt.Errorf("failed to create mock plugin for callback '%s': %w", receiver.name, err)
+						}
+						routePluginList = insertPlugin(routePluginList, mockPlugin)
+					}
+
+					foreignKeyPlugins, routePluginList = getForeignKeyPlugins(
+						foreignKeyPlugins, routePluginList, "route", routeName)
+
+					// construct the route, the defaults are taken from operation, path item, or document
+					routeDefaults, err := openapitools.GetRouteDefaults(operation.Extensions, kongComponents)
+					if err != nil {
+						return nil, err
+					}
+					if routeDefaults == nil {
+						if routeDefaults, err = openapitools.GetRouteDefaults(receiver.pathItem.Extensions,
+							kongComponents); err != nil {
+							return nil, err
+						}
+					}
+					if routeDefaults == nil {
+						routeDefaults = docRouteDefaults
+					}
+					route := make(map[string]interface{})
+					if routeDefaults != nil {
+						_ = json.Unmarshal(routeDefaults, &route)
+						delete(route, "service") // always clear foreign keys to services, not allowed
+					}
+					route["plugins"] = routePluginList
+
+					convertedPath, regexPriority, err := convertPathToRegex(receiver.path, operation, receiver.pathItem,
+						opts.InsoCompat)
+					if err != nil {
+						return nil, err
+					}
+					route["paths"] = []string{"~" + convertedPath + "$"}
+					if !opts.SkipID {
+						route["id"] = uuid.NewSHA1(opts.UUIDNamespace, []byte(routeName+".route")).String()
+					}
+					route["name"] = routeName
+					routeOrigins[routeName] = routeconflicts.Origin{
+						Method:      methodKey,
+						Path:        receiver.path,
+						OperationID: operation.OperationId,
+					}
+					receiverLevel := provenanceLevel{pointer: receiver.pointer, extensions: receiver.pathItem.Extensions}
+					callbackLevel := provenanceLevel{
+						pointer:    receiver.pointer + "/" + strings.ToLower(methodKey),
+						extensions: operation.Extensions,
+					}
+					tracker.add("route", routeName, callbackLevel.pointer,
+						append(slices.Clone(callbackLevels), receiverLevel, callbackLevel)...)
+					route["methods"] = []string{methodKey}
+					route["tags"] = kongTags
+					if err = setRegexPriority(route, regexPriority); err != nil {
+						return nil, err
+					}
+					if _, found := route["strip_path"]; !found {
+						route["strip_path"] = false
+					}
+					if routerFlavor == RouterFlavorExpressions {
+						pathRegex := "" // plain paths are matched exactly
+						if regexPriority == regexPriorityWithPathParams {
+							pathRegex = convertedPath
+						}
+						if _, err = setRouteExpression(route, methodKey, receiver.path, pathRegex); err != nil {
+							return nil, err
+						}
+					}
+					callbackRoutes = append(callbackRoutes, route)
+				}
+			}
+			callbackService["routes"] = callbackRoutes
+		}
+	}
+
+	if opts.Mock != "" {
+		// mocked requests are never proxied, so do not depend on any upstream
+		for _, service := range services {
+			setMockService(service.(map[string]interface{}))
+		}
+		if callbackService != nil {
+			setMockService(callbackService)
 		}
+		upstreams = make([]interface{}, 0)
+	}
+
+	if callbackService != nil {
+		err = checkServiceNames(append(slices.Clone(services), callbackService))
+	} else {
+		err = checkServiceNames(services)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(serviceGroups) > 0 && len(docService["routes"].([]interface{})) == 0 {
+		// all operations moved to the services of their groups, so the doc-level service is not needed
+		removeDocService = true
 	}
 
 	// export arrays with services, upstreams, and plugins to the final object
@@ -1349,7 +1973,26 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 	} else {
 		result["services"] = services
 	}
+	if callbackService != nil {
+		// added separately, since it does not replace the doc-level service
+		result["services"] = append(result["services"].([]interface{}), callbackService)
+	}
 	result["upstreams"] = upstreams
+	if opts.DocumentPath != "" {
+		addDocumentObjects(result["services"].([]interface{}), opts.DocumentPath, opts.UUIDNamespace, opts.SkipID)
+	}
+	if anonymousConsumerUsed {
+		result["consumers"] = []interface{}{
+			createAnonymousConsumer(anonymousConsumer, kongTags, opts.UUIDNamespace, opts.SkipID),
+		}
+		tracker.add("consumer", anonymousConsumer, "#/components/securitySchemes", docLevel)
+	}
+	if len(consumerGroups) > 0 {
+		result["consumer_groups"] = createConsumerGroups(consumerGroups, kongTags, opts.UUIDNamespace, opts.SkipID)
+		for name := range consumerGroups {
+			tracker.add("consumer_group", name, "#/components/securitySchemes", docLevel)
+		}
+	}
 	if len(*foreignKeyPlugins) > 0 {
 
 		// getSortKey returns a string that can be used to sort the plugins by name, service, route, and consumer (all
@@ -1387,6 +2030,17 @@ func Convert(content []byte, opts O2kOptions) (map[string]interface{}, error) {
 		result["plugins"] = foreignKeyPlugins
 	}
 
+	if err := applyIDStrategy(result, idStrategy, tracker, routeOrigins, docIDKey, opts.UUIDNamespace); err != nil {
+		return nil, err
+	}
+	if err := applyIDPins(result, opts.IDPins); err != nil {
+		return nil, err
+	}
+
+	if err := checkRouteConflicts(result, routeOrigins, opts.FailOnRouteConflicts); err != nil {
+		return nil, err
+	}
+
 	// we're done!
 	logbasics.Debug("finished processing document")
 	return result, nil
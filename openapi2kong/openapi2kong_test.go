@@ -1,6 +1,7 @@
 package openapi2kong
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/fs"
@@ -9,7 +10,9 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"testing/fstest"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"go.yaml.in/yaml/v4"
 )
@@ -42,10 +45,13 @@ func Test_Openapi2kong_InvalidPaths(t *testing.T) {
 	// Define expected error messages for different test files
 	expectedErrors := map[string]string{
 		"no-paths.yaml":                       "must have `.paths` in the root of the document",
-		"multiple-security-requirements.yaml": "only a single security-requirement is supported",
-		"multiple-security-schemes.yaml":      "within a security-requirement only a single security-scheme is supported",
-		"unsupported-security-type.yaml":      "only security-schemes of type 'openIdConnect' are supported",
+		"multiple-security-requirements.yaml": "cannot be combined with other security-requirements (logical OR)",
+		"multiple-security-schemes.yaml":      "security-schemes 'apiKey1' and 'apiKey2' both require the 'key-auth' plugin",
+		"unsupported-security-type.yaml":      "security-schemes of type 'http' with scheme 'digest' are not supported",
+		"unsupported-apikey-cookie.yaml":      "security-schemes of type 'apiKey' in a cookie are not supported",
 		"missing-security-scheme.yaml":        "no security-schemes with name 'nonExistentScheme' found in components",
+		"duplicate-callback-names.yaml":       "duplicate callback name 'onEvent'",
+		"invalid-callbacks-servers.yaml":      "expected 'x-kong-callbacks.servers[0]' to be an object with a 'url'",
 	}
 
 	for _, file := range files {
@@ -53,8 +59,9 @@ func Test_Openapi2kong_InvalidPaths(t *testing.T) {
 		t.Run(fileNameIn, func(t *testing.T) {
 			dataIn, _ := os.ReadFile(filepath.Join(dir, fileNameIn))
 			_, err := Convert(dataIn, O2kOptions{
-				Tags: []string{"OAS3_import", "OAS3file_" + fileNameIn},
-				OIDC: true,
+				Tags:      []string{"OAS3_import", "OAS3file_" + fileNameIn},
+				OIDC:      true,
+				Callbacks: true,
 			})
 
 			if err == nil {
@@ -87,6 +94,15 @@ func Test_Openapi2kong(t *testing.T) {
 			// Test option configuration
 			allHeadersRequired := false
 			reuseServices := false
+			routerFlavor := ""
+			mock := ""
+			validateResponses := false
+			callbacks := false
+			serviceGrouping := ""
+			inferPolicies := false
+			consumerGroups := false
+			documentPath := ""
+			idStrategy := ""
 
 			var config map[string]any
 			yaml.Unmarshal(dataIn, &config)
@@ -97,6 +113,33 @@ func Test_Openapi2kong(t *testing.T) {
 				if val, ok := testConfig["reuseServices"]; ok {
 					reuseServices = val.(bool)
 				}
+				if val, ok := testConfig["routerFlavor"]; ok {
+					routerFlavor = val.(string)
+				}
+				if val, ok := testConfig["mock"]; ok {
+					mock = val.(string)
+				}
+				if val, ok := testConfig["validateResponses"]; ok {
+					validateResponses = val.(bool)
+				}
+				if val, ok := testConfig["callbacks"]; ok {
+					callbacks = val.(bool)
+				}
+				if val, ok := testConfig["serviceGrouping"]; ok {
+					serviceGrouping = val.(string)
+				}
+				if val, ok := testConfig["inferPolicies"]; ok {
+					inferPolicies = val.(bool)
+				}
+				if val, ok := testConfig["consumerGroups"]; ok {
+					consumerGroups = val.(bool)
+				}
+				if val, ok := testConfig["documentPath"]; ok {
+					documentPath = val.(string)
+				}
+				if val, ok := testConfig["idStrategy"]; ok {
+					idStrategy = val.(string)
+				}
 			}
 
 			dataOut, err := Convert(dataIn, O2kOptions{
@@ -104,6 +147,15 @@ func Test_Openapi2kong(t *testing.T) {
 				OIDC:                      true,
 				TreatAllHeadersAsRequired: allHeadersRequired,
 				ReuseServices:             reuseServices,
+				RouterFlavor:              routerFlavor,
+				Mock:                      mock,
+				ValidateResponses:         validateResponses,
+				Callbacks:                 callbacks,
+				ServiceGrouping:           serviceGrouping,
+				InferPolicies:             inferPolicies,
+				ConsumerGroups:            consumerGroups,
+				DocumentPath:              documentPath,
+				IDStrategy:                idStrategy,
 			})
 			if err != nil {
 				t.Error(fmt.Sprintf("'%s' didn't expect error: %%w", fixturePath+fileNameIn), err)
@@ -237,3 +289,1095 @@ func Test_Openapi2kong_SkipRouteByHeader(t *testing.T) {
 		}
 	}
 }
+
+func Test_Openapi2kong_Swagger2SecurityDefinitions(t *testing.T) {
+	testDataString := `
+swagger: "2.0"
+info:
+  title: Swagger security test
+  version: v1
+securityDefinitions:
+  basic:
+    type: basic
+  key:
+    type: apiKey
+    name: X-API-KEY
+    in: header
+  oauth:
+    type: oauth2
+    flow: accessCode
+    authorizationUrl: https://example.com/authorize
+    tokenUrl: https://example.com/token
+    scopes:
+      read: read access
+    x-kong-security-openid-connect:
+      config:
+        issuer: https://example.com
+paths: {}
+`
+	upgraded, err := upgradeSwagger2([]byte(testDataString))
+	assert.NoError(t, err)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(upgraded, &doc))
+	schemes := doc["components"].(map[string]interface{})["securitySchemes"]
+	expected := `{
+		"basic": { "type": "http", "scheme": "basic" },
+		"key": { "type": "apiKey", "name": "X-API-KEY", "in": "header" },
+		"oauth": {
+			"type": "oauth2",
+			"flows": {
+				"authorizationCode": {
+					"authorizationUrl": "https://example.com/authorize",
+					"tokenUrl": "https://example.com/token",
+					"scopes": { "read": "read access" }
+				}
+			},
+			"x-kong-security-openid-connect": { "config": { "issuer": "https://example.com" } }
+		}
+	}`
+	actual, _ := json.Marshal(schemes)
+	assert.JSONEq(t, expected, string(actual))
+}
+
+func Test_Openapi2kong_Swagger2Parameters(t *testing.T) {
+	testDataString := `
+swagger: "2.0"
+info:
+  title: Swagger parameters test
+  version: v1
+parameters:
+  limit:
+    name: limit
+    in: query
+    type: integer
+    maximum: 100
+paths:
+  /pets/{id}:
+    parameters:
+      - name: id
+        in: path
+        required: true
+        type: string
+    get:
+      parameters:
+        - $ref: "#/parameters/limit"
+        - name: tags
+          in: query
+          type: array
+          items:
+            type: string
+          collectionFormat: multi
+      responses:
+        "200":
+          description: OK
+`
+	upgraded, err := upgradeSwagger2([]byte(testDataString))
+	assert.NoError(t, err)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(upgraded, &doc))
+	expected := `{
+		"parameters": [
+			{ "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }
+		],
+		"get": {
+			"parameters": [
+				{ "$ref": "#/components/parameters/limit" },
+				{
+					"name": "tags",
+					"in": "query",
+					"style": "form",
+					"explode": true,
+					"schema": { "type": "array", "items": { "type": "string" } }
+				}
+			],
+			"responses": { "200": { "description": "OK" } }
+		}
+	}`
+	actual, _ := json.Marshal(doc["paths"].(map[string]interface{})["/pets/{id}"])
+	assert.JSONEq(t, expected, string(actual))
+
+	expected = `{
+		"limit": { "name": "limit", "in": "query", "schema": { "type": "integer", "maximum": 100 } }
+	}`
+	actual, _ = json.Marshal(doc["components"].(map[string]interface{})["parameters"])
+	assert.JSONEq(t, expected, string(actual))
+}
+
+func Test_Openapi2kong_Swagger2Responses(t *testing.T) {
+	testDataString := `
+swagger: "2.0"
+info:
+  title: Swagger responses test
+  version: v1
+produces:
+  - application/json
+  - application/xml
+definitions:
+  Pet:
+    type: object
+responses:
+  NotFound:
+    description: not found
+paths:
+  /pets:
+    get:
+      responses:
+        "200":
+          description: OK
+          headers:
+            X-Rate-Limit:
+              type: integer
+              description: calls per hour
+          schema:
+            $ref: "#/definitions/Pet"
+          examples:
+            application/json:
+              name: fluffy
+        "404":
+          $ref: "#/responses/NotFound"
+`
+	upgraded, err := upgradeSwagger2([]byte(testDataString))
+	assert.NoError(t, err)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(upgraded, &doc))
+	expected := `{
+		"200": {
+			"description": "OK",
+			"headers": {
+				"X-Rate-Limit": { "description": "calls per hour", "schema": { "type": "integer" } }
+			},
+			"content": {
+				"application/json": {
+					"schema": { "$ref": "#/components/schemas/Pet" },
+					"example": { "name": "fluffy" }
+				},
+				"application/xml": {
+					"schema": { "$ref": "#/components/schemas/Pet" }
+				}
+			}
+		},
+		"404": { "$ref": "#/components/responses/NotFound" }
+	}`
+	pathItem := doc["paths"].(map[string]interface{})["/pets"].(map[string]interface{})
+	actual, _ := json.Marshal(pathItem["get"].(map[string]interface{})["responses"])
+	assert.JSONEq(t, expected, string(actual))
+
+	expected = `{ "NotFound": { "description": "not found" } }`
+	actual, _ = json.Marshal(doc["components"].(map[string]interface{})["responses"])
+	assert.JSONEq(t, expected, string(actual))
+}
+
+func Test_Openapi2kong_Swagger2RequestBody(t *testing.T) {
+	testDataString := `
+swagger: "2.0"
+info:
+  title: Swagger request body test
+  version: v1
+consumes:
+  - application/json
+parameters:
+  pet:
+    name: pet
+    in: body
+    required: true
+    schema:
+      $ref: "#/definitions/Pet"
+definitions:
+  Pet:
+    type: object
+    properties:
+      tag:
+        type: string
+        x-nullable: true
+paths:
+  /pets:
+    post:
+      parameters:
+        - name: pet
+          in: body
+          description: the pet to add
+          required: true
+          schema:
+            $ref: "#/definitions/Pet"
+      responses:
+        "201":
+          description: created
+    put:
+      parameters:
+        - $ref: "#/parameters/pet"
+      responses:
+        "200":
+          description: OK
+`
+	upgraded, err := upgradeSwagger2([]byte(testDataString))
+	assert.NoError(t, err)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(upgraded, &doc))
+	pathItem := doc["paths"].(map[string]interface{})["/pets"].(map[string]interface{})
+
+	expected := `{
+		"description": "the pet to add",
+		"required": true,
+		"content": {
+			"application/json": { "schema": { "$ref": "#/components/schemas/Pet" } }
+		}
+	}`
+	post := pathItem["post"].(map[string]interface{})
+	assert.Nil(t, post["parameters"])
+	actual, _ := json.Marshal(post["requestBody"])
+	assert.JSONEq(t, expected, string(actual))
+
+	expected = `{ "$ref": "#/components/requestBodies/pet" }`
+	actual, _ = json.Marshal(pathItem["put"].(map[string]interface{})["requestBody"])
+	assert.JSONEq(t, expected, string(actual))
+
+	expected = `{
+		"requestBodies": {
+			"pet": {
+				"required": true,
+				"content": {
+					"application/json": { "schema": { "$ref": "#/components/schemas/Pet" } }
+				}
+			}
+		},
+		"schemas": {
+			"Pet": {
+				"type": "object",
+				"properties": { "tag": { "type": "string", "nullable": true } }
+			}
+		}
+	}`
+	actual, _ = json.Marshal(doc["components"])
+	assert.JSONEq(t, expected, string(actual))
+}
+
+func Test_Openapi2kong_Swagger2ExternalRefs(t *testing.T) {
+	testDataString := `
+swagger: "2.0"
+info:
+  title: Swagger external references test
+  version: v1
+paths:
+  /pets:
+    get:
+      responses:
+        "200":
+          description: OK
+          schema:
+            $ref: "definitions.yaml#/definitions/Pet"
+`
+	_, err := upgradeSwagger2([]byte(testDataString))
+	assert.EqualError(t, err, "external reference 'definitions.yaml#/definitions/Pet' is not supported "+
+		"in Swagger 2.0 documents, upgrade the document and its referenced files to OpenAPI 3 first")
+}
+
+func Test_Openapi2kong_ExternalRefs(t *testing.T) {
+	dir := filepath.Join(fixturePath, "external-refs")
+	dataIn, _ := os.ReadFile(filepath.Join(dir, "spec", "openapi.yaml"))
+	mirrors := map[string]string{
+		"https://schemas.example.com/common/v1/": "../mirror/",
+	}
+
+	t.Run("resolves relative references from the base path", func(t *testing.T) {
+		dataOut, err := Convert(dataIn, O2kOptions{
+			Tags:             []string{"OAS3_import", "OAS3file_external-refs"},
+			BasePath:         filepath.Join(dir, "spec"),
+			RemoteRefMirrors: mirrors,
+		})
+		if err != nil {
+			t.Fatalf("didn't expect error: %v", err)
+		}
+		JSONOut, _ := json.MarshalIndent(dataOut, "", "  ")
+		os.WriteFile(filepath.Join(dir, "openapi.generated.json"), JSONOut, 0o600)
+		JSONExpected, _ := os.ReadFile(filepath.Join(dir, "openapi.expected.json"))
+		assert.JSONEq(t, string(JSONExpected), string(JSONOut))
+	})
+
+	t.Run("resolves relative references from a filesystem", func(t *testing.T) {
+		spec := []byte(`openapi: 3.0.3
+info:
+  title: External references
+  version: v1
+x-kong-plugin-request-validator: {}
+paths:
+  /pets:
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: './schemas/pet.yaml'
+      responses:
+        '200':
+          description: OK
+`)
+		fsys := fstest.MapFS{
+			"schemas/pet.yaml": &fstest.MapFile{Data: []byte("type: object\nrequired: [name]\n" +
+				"properties:\n  name:\n    type: string\n  error:\n" +
+				"    $ref: 'https://schemas.example.com/common/v1/errors.yaml#/Error'\n")},
+			"mirror/errors.yaml": &fstest.MapFile{Data: []byte("Error:\n  type: object\n")},
+		}
+		dataOut, err := Convert(spec, O2kOptions{
+			FS:               fsys,
+			RemoteRefMirrors: map[string]string{"https://schemas.example.com/common/v1/": "mirror/"},
+		})
+		if err != nil {
+			t.Fatalf("didn't expect error: %v", err)
+		}
+		JSONOut, _ := json.Marshal(dataOut)
+		assert.Contains(t, string(JSONOut), `\"required\":[\"name\"]`)
+	})
+
+	t.Run("rejects remote references escaping their mirror", func(t *testing.T) {
+		spec := []byte(`openapi: 3.0.3
+info:
+  title: External references
+  version: v1
+x-kong-plugin-request-validator: {}
+paths:
+  /pets:
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: 'https://schemas.example.com/common/v1/../secret.yaml#/Error'
+      responses:
+        '200':
+          description: OK
+`)
+		fsys := fstest.MapFS{
+			"mirror/errors.yaml": &fstest.MapFile{Data: []byte("Error:\n  type: object\n")},
+			"secret.yaml":        &fstest.MapFile{Data: []byte("Error:\n  type: string\n")},
+		}
+		_, err := Convert(spec, O2kOptions{
+			FS:               fsys,
+			RemoteRefMirrors: map[string]string{"https://schemas.example.com/common/v1/": "mirror/"},
+		})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "resolves outside of its local mirror 'mirror/'")
+		}
+	})
+
+	t.Run("rejects remote references without a mirror", func(t *testing.T) {
+		_, err := Convert(dataIn, O2kOptions{
+			BasePath: filepath.Join(dir, "spec"),
+		})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "remote reference 'https://schemas.example.com/common/v1/errors.yaml' "+
+				"is not allowed")
+		}
+	})
+}
+
+func Test_Openapi2kong_RouteConflicts(t *testing.T) {
+	spec := []byte(`openapi: 3.0.3
+info:
+  title: Conflicts
+  version: v1
+paths:
+  /pets/mine:
+    get:
+      operationId: my-pets
+      responses:
+        '200':
+          description: OK
+  /pets/{petId}:
+    get:
+      operationId: get-pet
+      responses:
+        '200':
+          description: OK
+  /pets/{name}:
+    get:
+      operationId: get-pet-by-name
+      responses:
+        '200':
+          description: OK
+`)
+
+	t.Run("logs conflicts by default", func(t *testing.T) {
+		_, err := Convert(spec, O2kOptions{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("fails on conflicts if set", func(t *testing.T) {
+		_, err := Convert(spec, O2kOptions{FailOnRouteConflicts: true})
+		assert.EqualError(t, err, "found 1 route conflicts: "+
+			"route 'conflicts_get-pet-by-name' of service 'conflicts' (GET /pets/{name}, operation 'get-pet-by-name') "+
+			"is shadowed by "+
+			"route 'conflicts_get-pet' of service 'conflicts' (GET /pets/{petId}, operation 'get-pet')")
+	})
+
+	t.Run("fails with the expressions router", func(t *testing.T) {
+		// expression routes are not analyzed, so the check would always pass
+		_, err := Convert(spec, O2kOptions{FailOnRouteConflicts: true, RouterFlavor: RouterFlavorExpressions})
+		assert.EqualError(t, err, "failing on route conflicts is not supported with the 'expressions' router flavor")
+	})
+}
+
+func Test_Openapi2kong_Provenance(t *testing.T) {
+	spec := []byte(`openapi: 3.0.3
+info:
+  title: Provenance
+  version: v1
+servers:
+- url: https://api.example.com
+x-kong-plugin-cors:
+  $ref: '#/components/x-kong/plugins/cors'
+paths:
+  /pets/{id}:
+    x-kong-route-defaults:
+      preserve_host: true
+    get:
+      operationId: get-pet
+      x-kong-plugin-rate-limiting:
+        consumer: alice
+        config:
+          minute: 10
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '200':
+          description: OK
+components:
+  x-kong:
+    plugins:
+      cors:
+        config:
+          origins: ['*']
+`)
+
+	result, provenance, err := ConvertWithProvenance(spec, O2kOptions{SkipID: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotNil(t, result["services"])
+	assert.Equal(t, Provenance{
+		"provenance": {
+			Entity: "service",
+			Name:   "provenance",
+			Source: "#",
+		},
+		"provenance.plugin.cors": {
+			Entity:     "plugin",
+			Name:       "cors",
+			Source:     "#/x-kong-plugin-cors",
+			Extensions: []string{"#/components/x-kong/plugins/cors", "#/x-kong-plugin-cors"},
+		},
+		"provenance_get-pet": {
+			Entity:     "route",
+			Name:       "provenance_get-pet",
+			Source:     "#/paths/~1pets~1{id}/get",
+			Extensions: []string{"#/paths/~1pets~1{id}/x-kong-route-defaults"},
+		},
+		"provenance_get-pet.plugin.rate-limiting.alice": {
+			Entity:     "plugin",
+			Name:       "rate-limiting",
+			Source:     "#/paths/~1pets~1{id}/get/x-kong-plugin-rate-limiting",
+			Extensions: []string{"#/paths/~1pets~1{id}/get/x-kong-plugin-rate-limiting"},
+		},
+	}, provenance)
+
+	t.Run("keys entities by ID", func(t *testing.T) {
+		result, provenance, err := ConvertWithProvenance(spec, O2kOptions{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		service := result["services"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "service", provenance[service["id"].(string)].Entity)
+		route := service["routes"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "#/paths/~1pets~1{id}/get", provenance[route["id"].(string)].Source)
+		assert.Len(t, provenance, 4)
+	})
+}
+
+func Test_Openapi2kong_Overlays(t *testing.T) {
+	spec := []byte(`openapi: 3.0.3
+info:
+  title: Pets
+  version: v1
+servers:
+- url: https://pets.example.com
+paths:
+  /pets:
+    get:
+      operationId: list-pets
+      responses:
+        '200':
+          description: OK
+`)
+	overlays := [][]byte{
+		[]byte(`overlay: 1.0.0
+info:
+  title: Kong settings
+  version: 1.0.0
+actions:
+- target: $
+  update:
+    x-kong-name: petstore
+- target: $.paths.*.get
+  update:
+    x-kong-plugin-key-auth:
+      config:
+        key_names: [apikey]
+`),
+		[]byte(`{"overlay": "1.0.0", "actions": [{"target": "$.paths.*.get", "update": {"x-kong-name": "all-pets"}}]}`),
+	}
+
+	result, err := Convert(spec, O2kOptions{SkipID: true, Overlays: overlays})
+	if !assert.NoError(t, err) {
+		return
+	}
+	service := result["services"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "petstore", service["name"])
+	route := service["routes"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "petstore_pets_all-pets", route["name"])
+	plugins := *route["plugins"].(*[]*map[string]interface{})
+	if assert.Len(t, plugins, 1) {
+		assert.Equal(t, "key-auth", (*plugins[0])["name"])
+	}
+
+	t.Run("fails on an invalid overlay", func(t *testing.T) {
+		_, err := Convert(spec, O2kOptions{Overlays: [][]byte{[]byte(`actions: []`)}})
+		assert.EqualError(t, err, "failed to parse overlay 0: not an overlay document, the 'overlay' version is missing")
+	})
+}
+
+func Test_Openapi2kong_ConsumerGroupsErrors(t *testing.T) {
+	spec := []byte(`openapi: 3.0.3
+info:
+  title: Pets
+  version: v1
+servers:
+- url: https://pets.example.com
+paths:
+  /pets:
+    post:
+      operationId: create-pet
+      security:
+      - apiKey: [pets:read, pets:write]
+      responses:
+        '201':
+          description: Created
+components:
+  securitySchemes:
+    apiKey:
+      type: apiKey
+      name: apikey
+      in: header
+`)
+
+	result, err := Convert(spec, O2kOptions{SkipID: true, ConsumerGroups: true})
+	if assert.NoError(t, err) {
+		// the operation is skipped, such that no unprotected route is generated
+		service := result["services"].([]interface{})[0].(map[string]interface{})
+		assert.Empty(t, service["routes"])
+	}
+
+	t.Run("fails in strict mode", func(t *testing.T) {
+		_, err := Convert(spec, O2kOptions{SkipID: true, ConsumerGroups: true, StrictSecurity: true})
+		assert.EqualError(t, err, "unsupported security requirements: operation 'POST /pets': a security-requirement "+
+			"requiring multiple consumer groups (pets:read, pets:write) cannot be enforced by the acl plugin, "+
+			"use 'x-kong-consumer-groups' to merge them into a single group")
+	})
+
+	t.Run("ignores the error if requested", func(t *testing.T) {
+		result, err := Convert(spec, O2kOptions{SkipID: true, ConsumerGroups: true, IgnoreSecurityErrors: true})
+		if assert.NoError(t, err) {
+			assert.Nil(t, result["consumer_groups"])
+		}
+	})
+
+	t.Run("fails on an invalid x-kong-consumer-groups", func(t *testing.T) {
+		invalid := append([]byte("x-kong-consumer-groups: [readers]\n"), spec...)
+		_, err := Convert(invalid, O2kOptions{SkipID: true, ConsumerGroups: true})
+		assert.EqualError(t, err, "expected 'x-kong-consumer-groups' to be an object")
+	})
+}
+
+func Test_Openapi2kong_SkipInsecureOperations(t *testing.T) {
+	spec := []byte(`openapi: 3.0.3
+info:
+  title: Pets
+  version: v1
+servers:
+- url: https://pets.example.com
+paths:
+  /pets:
+    get:
+      operationId: list-pets
+      responses:
+        '200':
+          description: OK
+    post:
+      operationId: create-pet
+      servers:
+      - url: https://a.pets.example.com
+      - url: https://b.pets.example.com
+      security:
+      - digest: []
+      responses:
+        '201':
+          description: Created
+  /owners:
+    get:
+      operationId: list-owners
+      tags: [owners]
+      security:
+      - digest: []
+      responses:
+        '200':
+          description: OK
+components:
+  securitySchemes:
+    digest:
+      type: http
+      scheme: digest
+`)
+
+	// the skipped operations leave no services or upstreams without routes behind
+	result, err := Convert(spec, O2kOptions{SkipID: true, OIDC: true, ServiceGrouping: ServiceGroupingTag})
+	if assert.NoError(t, err) {
+		services := result["services"].([]interface{})
+		if assert.Len(t, services, 1) {
+			service := services[0].(map[string]interface{})
+			assert.Equal(t, "pets", service["name"])
+			assert.Len(t, service["routes"], 1)
+		}
+		assert.Empty(t, result["upstreams"])
+	}
+}
+
+func Test_Openapi2kong_ServiceGroupNames(t *testing.T) {
+	spec := []byte(`openapi: 3.0.3
+info:
+  title: t
+  version: v1
+servers:
+- url: https://pets.example.com
+paths:
+  /pets:
+    servers:
+    - url: https://other.example.com
+    get:
+      operationId: list-pets
+      responses:
+        '200':
+          description: OK
+  /dogs:
+    get:
+      operationId: list-dogs
+      tags: [pets]
+      responses:
+        '200':
+          description: OK
+`)
+
+	_, err := Convert(spec, O2kOptions{SkipID: true, ServiceGrouping: ServiceGroupingTag})
+	assert.EqualError(t, err, "multiple services are named 't_pets'; use 'x-kong-name' to make the names unique")
+}
+
+func Test_Openapi2kong_DocumentObjects(t *testing.T) {
+	spec := []byte(`openapi: 3.0.3
+info:
+  title: Pets
+  version: v1
+x-kong-plugin-cors: {}
+servers:
+- url: https://pets.example.com
+paths:
+  /pets:
+    get:
+      x-kong-route-defaults:
+        strip_path: false
+      x-logo:
+        x-kong-name: vendor data
+      responses:
+        '200':
+          description: OK
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  x-kong-name:
+                    type: string
+              example:
+                x-kong-name: example data
+`)
+
+	result, err := Convert(spec, O2kOptions{SkipID: true, DocumentPath: "pets.json"})
+	if assert.NoError(t, err) {
+		service := result["services"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, []interface{}{map[string]interface{}{"path": "pets.json"}}, service["document_objects"])
+	}
+
+	t.Run("generates no document objects without a path", func(t *testing.T) {
+		result, err := Convert(spec, O2kOptions{SkipID: true})
+		if assert.NoError(t, err) {
+			service := result["services"].([]interface{})[0].(map[string]interface{})
+			assert.Nil(t, service["document_objects"])
+		}
+	})
+
+	t.Run("sanitizes the spec, keeping schemas and examples", func(t *testing.T) {
+		document, err := SanitizeSpec(spec, O2kOptions{DocumentPath: "pets.json"})
+		if assert.NoError(t, err) {
+			assert.JSONEq(t, `{
+				"openapi": "3.0.3",
+				"info": { "title": "Pets", "version": "v1" },
+				"servers": [{ "url": "https://pets.example.com" }],
+				"paths": { "/pets": { "get": {
+					"x-logo": { "x-kong-name": "vendor data" },
+					"responses": { "200": {
+						"description": "OK",
+						"content": { "application/json": {
+							"schema": { "type": "object", "properties": { "x-kong-name": { "type": "string" } } },
+							"example": { "x-kong-name": "example data" }
+						} }
+					} }
+				} } }
+			}`, string(document))
+		}
+	})
+
+	t.Run("sanitizes the spec with the overlays applied, as YAML", func(t *testing.T) {
+		document, err := SanitizeSpec(spec, O2kOptions{
+			DocumentPath: "pets.yaml",
+			Overlays: [][]byte{[]byte(`overlay: 1.0.0
+info:
+  title: rename
+  version: v1
+actions:
+- target: $.info
+  update:
+    title: Pet store
+    x-kong-name: pets
+`)},
+		})
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(document), "info:\n  title: Pet store\n  version: v1\n")
+			assert.NotContains(t, string(document), "x-kong-name: pets")
+		}
+	})
+}
+
+// createTestSpec returns a spec with a single operation, for the tests converting multiple specs, or
+// the same spec multiple times.
+func createTestSpec(title string, path string, operationID string) []byte {
+	return []byte(`openapi: 3.0.3
+info:
+  title: ` + title + `
+  version: v1
+servers:
+- url: https://` + title + `.example.com
+- url: https://` + title + `2.example.com
+security:
+- apiKey: [read]
+paths:
+  ` + path + `:
+    get:
+      operationId: ` + operationID + `
+      x-kong-plugin-file-log:
+        config:
+          path: /dev/stderr
+      responses:
+        '200':
+          description: OK
+components:
+  securitySchemes:
+    apiKey:
+      type: apiKey
+      name: apikey
+      in: header
+`)
+}
+
+func Test_Openapi2kong_ConvertAll(t *testing.T) {
+	opts := O2kOptions{OIDC: true, ConsumerGroups: true, Tags: []string{"batch"}}
+
+	result, err := ConvertAll(map[string][]byte{
+		"pets.yaml":  createTestSpec("pets", "/pets", "list"),
+		"users.yaml": createTestSpec("users", "/users", "list"),
+	}, opts)
+	if !assert.NoError(t, err) {
+		return
+	}
+	services := result["services"].([]interface{})
+	if assert.Len(t, services, 2) {
+		assert.Equal(t, "pets", services[0].(map[string]interface{})["name"])
+		assert.Equal(t, "users", services[1].(map[string]interface{})["name"])
+	}
+	assert.Len(t, result["upstreams"], 2)
+	// the consumer group for the scope is shared by both documents
+	assert.Len(t, result["consumer_groups"], 1)
+
+	// the result is the same as converting the documents one at a time
+	single, err := Convert(createTestSpec("users", "/users", "list"), opts)
+	if assert.NoError(t, err) {
+		assert.Equal(t, single["services"].([]interface{})[0], services[1])
+	}
+
+	t.Run("merges the consumer groups by name", func(t *testing.T) {
+		result, err := ConvertAll(map[string][]byte{
+			"pets.yaml":  append([]byte("x-kong-tags: [pets, shared]\n"), createTestSpec("pets", "/pets", "list")...),
+			"users.yaml": append([]byte("x-kong-tags: [users, shared]\n"), createTestSpec("users", "/users", "list")...),
+		}, O2kOptions{OIDC: true, ConsumerGroups: true})
+		if assert.NoError(t, err) && assert.Len(t, result["consumer_groups"], 1) {
+			group := result["consumer_groups"].([]interface{})[0].(map[string]interface{})
+			assert.Equal(t, "read", group["name"])
+			assert.Equal(t, []string{"pets", "shared", "users"}, group["tags"])
+		}
+	})
+
+	t.Run("fails on colliding names", func(t *testing.T) {
+		_, err := ConvertAll(map[string][]byte{
+			"pets.yaml":  createTestSpec("pets", "/pets", "list"),
+			"pets2.yaml": createTestSpec("pets", "/more-pets", "list"),
+		}, opts)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "service name 'pets' is generated by both 'pets.yaml' and 'pets2.yaml'")
+			assert.Contains(t, err.Error(), "upstream name 'pets.upstream' is generated by both")
+		}
+	})
+
+	t.Run("fails on route conflicts between documents", func(t *testing.T) {
+		_, err := ConvertAll(map[string][]byte{
+			"pets.yaml":  createTestSpec("pets", "/pets", "list"),
+			"users.yaml": createTestSpec("users", "/pets", "list"),
+		}, O2kOptions{FailOnRouteConflicts: true})
+		assert.ErrorContains(t, err, "found 1 route conflicts between documents")
+		assert.ErrorContains(t, err, "(from 'pets.yaml' and 'users.yaml')")
+	})
+
+	t.Run("reports the failing documents", func(t *testing.T) {
+		_, err := ConvertAll(map[string][]byte{
+			"pets.yaml":    createTestSpec("pets", "/pets", "list"),
+			"invalid.yaml": []byte("openapi: 3.0.3\ninfo:\n  title: invalid\n  version: v1\n"),
+		}, O2kOptions{})
+		assert.ErrorContains(t, err, "failed to convert 'invalid.yaml'")
+	})
+
+	t.Run("fails with a document name", func(t *testing.T) {
+		_, err := ConvertAll(map[string][]byte{"pets.yaml": createTestSpec("pets", "/pets", "list")},
+			O2kOptions{DocName: "x"})
+		assert.EqualError(t, err, "a document name cannot be used when converting multiple specs, "+
+			"since every document needs its own")
+	})
+}
+
+func Test_Openapi2kong_IDPins(t *testing.T) {
+	getRoute := func(result map[string]interface{}) map[string]interface{} {
+		service := result["services"].([]interface{})[0].(map[string]interface{})
+		return service["routes"].([]interface{})[0].(map[string]interface{})
+	}
+
+	t.Run("keeps the operation based ids when the path changes", func(t *testing.T) {
+		opts := O2kOptions{IDStrategy: IDStrategyOperationID}
+		before, err := Convert(createTestSpec("pets", "/pets", "list-pets"), opts)
+		if !assert.NoError(t, err) {
+			return
+		}
+		after, err := Convert(createTestSpec("pets", "/v2/pets", "list-pets"), opts)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, getRoute(before)["id"], getRoute(after)["id"])
+		assert.Equal(t, getRoute(before)["plugins"], getRoute(after)["plugins"])
+		assert.Equal(t, uuid.NewSHA1(uuid.NameSpaceDNS, []byte("pets:operation:list-pets.route")).String(),
+			getRoute(after)["id"])
+	})
+
+	t.Run("scopes the operation based ids to their document", func(t *testing.T) {
+		result, err := ConvertAll(map[string][]byte{
+			"pets.yaml":  createTestSpec("pets", "/pets", "list"),
+			"users.yaml": createTestSpec("users", "/users", "list"),
+		}, O2kOptions{IDStrategy: IDStrategyOperationID})
+		if !assert.NoError(t, err) {
+			return
+		}
+		services := result["services"].([]interface{})
+		if assert.Len(t, services, 2) {
+			petsRoute := services[0].(map[string]interface{})["routes"].([]interface{})[0].(map[string]interface{})
+			usersRoute := services[1].(map[string]interface{})["routes"].([]interface{})[0].(map[string]interface{})
+			assert.NotEqual(t, petsRoute["id"], usersRoute["id"])
+			assert.Equal(t, uuid.NewSHA1(uuid.NameSpaceDNS, []byte("users:operation:list.route")).String(),
+				usersRoute["id"])
+		}
+	})
+
+	t.Run("keeps the operation based ids when the document is renamed", func(t *testing.T) {
+		opts := O2kOptions{IDStrategy: IDStrategyOperationID}
+		spec := createTestSpec("pets", "/pets", "list-pets")
+		before, err := Convert(spec, opts)
+		if !assert.NoError(t, err) {
+			return
+		}
+		renamed := bytes.Replace(spec, []byte("openapi: 3.0.3\n"), []byte("openapi: 3.0.3\nx-kong-name: animals\n"), 1)
+		after, err := Convert(renamed, opts)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "animals_list-pets", getRoute(after)["name"])
+		assert.Equal(t, getRoute(before)["id"], getRoute(after)["id"])
+	})
+
+	t.Run("pins the ids of renamed entities", func(t *testing.T) {
+		before, err := Convert(createTestSpec("pets", "/pets", "list-pets"), O2kOptions{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		routeID := getRoute(before)["id"].(string)
+		pluginID := (*getRoute(before)["plugins"].(*[]*map[string]interface{}))[0]
+		pins, err := ParseIDPins([]byte(routeID + ": route:pets_get-pets\n" +
+			(*pluginID)["id"].(string) + ": plugin:pets_get-pets.plugin.file-log\n"))
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		after, err := Convert(createTestSpec("pets", "/pets", "get-pets"), O2kOptions{IDPins: pins})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "pets_get-pets", getRoute(after)["name"])
+		assert.Equal(t, routeID, getRoute(after)["id"])
+		assert.Equal(t, getRoute(before)["plugins"], getRoute(after)["plugins"])
+	})
+
+	t.Run("pins the ids in a batch", func(t *testing.T) {
+		pins := map[string]string{"c0ffee00-0000-4000-8000-000000000000": "service:pets"}
+		result, err := ConvertAll(map[string][]byte{"pets.yaml": createTestSpec("pets", "/pets", "list-pets")},
+			O2kOptions{IDPins: pins})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "c0ffee00-0000-4000-8000-000000000000",
+			result["services"].([]interface{})[0].(map[string]interface{})["id"])
+	})
+
+	t.Run("fails on invalid pins", func(t *testing.T) {
+		_, err := ParseIDPins([]byte("not-a-uuid: route:pets_list-pets"))
+		assert.EqualError(t, err, "expected pinned id 'not-a-uuid' to be a UUID")
+		_, err = ParseIDPins([]byte("c0ffee00-0000-4000-8000-000000000000: pets_list-pets"))
+		assert.EqualError(t, err, "expected the entity for pinned id 'c0ffee00-0000-4000-8000-000000000000' to be "+
+			"'<entity-type>:<name>', with entity-type one of: consumer, consumer_group, plugin, route, service, upstream")
+		_, err = ParseIDPins([]byte("- route:pets_list-pets"))
+		assert.EqualError(t, err, "expected the id pins to be an object mapping ids to entities")
+	})
+
+	t.Run("fails on pins that do not apply", func(t *testing.T) {
+		spec := createTestSpec("pets", "/pets", "list-pets")
+		_, err := Convert(spec, O2kOptions{IDPins: map[string]string{
+			"c0ffee00-0000-4000-8000-000000000000": "route:pets_get-pets",
+		}})
+		assert.EqualError(t, err, "the entity 'route:pets_get-pets' for pinned id "+
+			"'c0ffee00-0000-4000-8000-000000000000' was not generated")
+
+		_, err = Convert(spec, O2kOptions{IDPins: map[string]string{
+			"c0ffee00-0000-4000-8000-000000000000": "route:pets_list-pets",
+			"c0ffee00-0000-4000-8000-000000000001": "route:pets_list-pets",
+		}})
+		assert.EqualError(t, err, "the entity 'route:pets_list-pets' is pinned to both id "+
+			"'c0ffee00-0000-4000-8000-000000000000' and 'c0ffee00-0000-4000-8000-000000000001'")
+
+		serviceID := uuid.NewSHA1(uuid.NameSpaceDNS, []byte("pets.service")).String()
+		_, err = Convert(spec, O2kOptions{IDPins: map[string]string{serviceID: "route:pets_list-pets"}})
+		assert.EqualError(t, err, "the pinned ids result in id '"+serviceID+"' being used by both "+
+			"'service:pets' and 'route:pets_list-pets'")
+
+		_, err = Convert(spec, O2kOptions{SkipID: true, IDStrategy: IDStrategyOperationID})
+		assert.EqualError(t, err, "an id strategy, or id pins, cannot be combined with skipping ids")
+		_, err = Convert(spec, O2kOptions{IDStrategy: "random"})
+		assert.EqualError(t, err, "unsupported id strategy 'random', expected 'name', 'operation-id', or 'extension'")
+	})
+}
+
+func Test_Openapi2kong_RouteGranularity(t *testing.T) {
+	spec := []byte(`openapi: 3.0.3
+info:
+  title: Pets
+  version: v1
+servers:
+- url: https://pets.example.com
+paths:
+  /pets:
+    x-kong-route-granularity: path
+    get:
+      responses:
+        '200':
+          description: OK
+    post:
+      responses:
+        '201':
+          description: Created
+`)
+
+	t.Run("matches all methods in the expression", func(t *testing.T) {
+		result, err := Convert(spec, O2kOptions{SkipID: true, RouterFlavor: RouterFlavorExpressions})
+		if assert.NoError(t, err) {
+			routes := result["services"].([]interface{})[0].(map[string]interface{})["routes"].([]interface{})
+			if assert.Len(t, routes, 1) {
+				route := routes[0].(map[string]interface{})
+				assert.Equal(t, "pets_pets", route["name"])
+				assert.Equal(t, `(http.method == "GET" || http.method == "POST") && http.path == "/pets"`,
+					route["expression"])
+			}
+		}
+	})
+
+	t.Run("records the path as provenance", func(t *testing.T) {
+		_, provenance, err := ConvertWithProvenance(spec, O2kOptions{SkipID: true})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "#/paths/~1pets", provenance["pets_pets"].Source)
+			assert.Equal(t, []string{"#/paths/~1pets/x-kong-route-granularity"}, provenance["pets_pets"].Extensions)
+		}
+	})
+
+	t.Run("fails on an invalid granularity", func(t *testing.T) {
+		invalid := []byte(strings.Replace(string(spec), "granularity: path", "granularity: method", 1))
+		_, err := Convert(invalid, O2kOptions{SkipID: true})
+		assert.EqualError(t, err, "path '/pets': expected 'x-kong-route-granularity' to be either 'operation' or 'path'")
+	})
+}
+
+func Test_Openapi2kong_RouteDefaultsPaths(t *testing.T) {
+	spec := []byte(`openapi: 3.0.3
+info:
+  title: Pets
+  version: v1
+servers:
+- url: https://pets.example.com
+paths:
+  /pets:
+    get:
+      operationId: list-pets
+      x-kong-route-defaults:
+        paths: [/pets]
+      responses:
+        '200':
+          description: OK
+`)
+
+	// the provided paths replace the generated regex, keeping the prefix match
+	result, err := Convert(spec, O2kOptions{SkipID: true})
+	if assert.NoError(t, err) {
+		service := result["services"].([]interface{})[0].(map[string]interface{})
+		route := service["routes"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, []interface{}{"/pets"}, route["paths"])
+	}
+
+	t.Run("fails with the expressions router", func(t *testing.T) {
+		_, err := Convert(spec, O2kOptions{SkipID: true, RouterFlavor: RouterFlavorExpressions})
+		assert.EqualError(t, err, "operation 'GET /pets': 'paths' in the route defaults are not supported "+
+			"by the expressions router")
+	})
+}
@@ -0,0 +1,372 @@
+package openapi2kong
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kong/go-apiops/logbasics"
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"go.yaml.in/yaml/v4"
+)
+
+const (
+	// the key in '/components/x-kong' holding the mapping table for policy inference
+	policyInferenceKey = "policy-inference"
+
+	// the plugins generated by policy inference
+	rateLimitingPlugin        = "rate-limiting"
+	proxyCachePlugin          = "proxy-cache"
+	responseTransformerPlugin = "response-transformer"
+)
+
+// rateLimitingWindows are the windows supported by the rate-limiting plugin.
+var rateLimitingWindows = []string{"second", "minute", "hour", "day", "month", "year"}
+
+// policyMapping is the mapping table for policy inference, see getPolicyMapping.
+type policyMapping struct {
+	RateLimiting    map[string]string // extensions holding a request limit, mapped to the window of the limit
+	ProxyCache      bool              // 'Cache-Control' response headers with a max-age configure proxy-cache
+	Deprecation     bool              // deprecated operations get 'Deprecation' and 'Sunset' headers
+	SunsetExtension string            // the extension holding the sunset date of a deprecated operation
+}
+
+// defaultPolicyMapping returns the mapping table used if '/components/x-kong/policy-inference' is not set.
+func defaultPolicyMapping() *policyMapping {
+	return &policyMapping{
+		RateLimiting: map[string]string{
+			"x-ratelimit-limit": "minute",
+			"x-rate-limit":      "minute",
+		},
+		ProxyCache:      true,
+		Deprecation:     true,
+		SunsetExtension: "x-sunset",
+	}
+}
+
+// getPolicyMapping returns the mapping table for policy inference. The defaults are updated with the
+// entries set in '/components/x-kong/policy-inference'. A rate limit extension mapped to an empty
+// window is removed from the table.
+func getPolicyMapping(components *map[string]interface{}) (*policyMapping, error) {
+	mapping := defaultPolicyMapping()
+	if components == nil || (*components)[policyInferenceKey] == nil {
+		return mapping, nil
+	}
+
+	var config struct {
+		RateLimiting    *map[string]string `json:"rate-limiting"`
+		ProxyCache      *bool              `json:"proxy-cache"`
+		Deprecation     *bool              `json:"deprecation"`
+		SunsetExtension *string            `json:"sunset-extension"`
+	}
+	configJSON, _ := json.Marshal((*components)[policyInferenceKey])
+	if err := json.Unmarshal(configJSON, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse '/components/x-kong/%s': %w", policyInferenceKey, err)
+	}
+
+	if config.RateLimiting != nil {
+		for extension, window := range *config.RateLimiting {
+			if window == "" {
+				delete(mapping.RateLimiting, extension)
+				continue
+			}
+			if !isRateLimitingWindow(window) {
+				return nil, fmt.Errorf("unsupported window '%s' for '%s' in '/components/x-kong/%s', expected one of: %s",
+					window, extension, policyInferenceKey, strings.Join(rateLimitingWindows, ", "))
+			}
+			mapping.RateLimiting[extension] = window
+		}
+	}
+	if config.ProxyCache != nil {
+		mapping.ProxyCache = *config.ProxyCache
+	}
+	if config.Deprecation != nil {
+		mapping.Deprecation = *config.Deprecation
+	}
+	if config.SunsetExtension != nil {
+		mapping.SunsetExtension = *config.SunsetExtension
+	}
+	return mapping, nil
+}
+
+// isRateLimitingWindow returns true if the window is supported by the rate-limiting plugin.
+func isRateLimitingWindow(window string) bool {
+	for _, w := range rateLimitingWindows {
+		if w == window {
+			return true
+		}
+	}
+	return false
+}
+
+// findPolicyHint returns the extension from the most specific level that has it, or nil if not found.
+func findPolicyHint(levels []provenanceLevel, key string) *yaml.Node {
+	for i := len(levels) - 1; i >= 0; i-- {
+		if levels[i].extensions == nil {
+			continue
+		}
+		if node, ok := levels[i].extensions.Get(key); ok && node != nil {
+			return node
+		}
+	}
+	return nil
+}
+
+// parseRateLimit returns the limit as an integer, or an error if it isn't a positive integer.
+func parseRateLimit(extension string, node *yaml.Node) (int, error) {
+	limit, err := strconv.Atoi(node.Value)
+	if node.Kind != yaml.ScalarNode || err != nil || limit <= 0 {
+		return 0, fmt.Errorf("expected the limits in '%s' to be positive integers", extension)
+	}
+	return limit, nil
+}
+
+// inferRateLimiting returns the rate-limiting config from the rate limit extensions. The value is either
+// a limit for the window from the mapping table, or an object with limits per window. The most specific
+// level wins. Returns nil if there are no rate limit extensions.
+func inferRateLimiting(mapping *policyMapping, levels []provenanceLevel) (map[string]interface{}, error) {
+	extensions := make([]string, 0, len(mapping.RateLimiting))
+	for extension := range mapping.RateLimiting {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+
+	for i := len(levels) - 1; i >= 0; i-- {
+		for _, extension := range extensions {
+			node := findPolicyHint(levels[i:i+1], extension)
+			if node == nil {
+				continue
+			}
+
+			config := make(map[string]interface{})
+			if node.Kind != yaml.MappingNode {
+				limit, err := parseRateLimit(extension, node)
+				if err != nil {
+					return nil, err
+				}
+				config[mapping.RateLimiting[extension]] = limit
+				return config, nil
+			}
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				window := node.Content[j].Value
+				if !isRateLimitingWindow(window) {
+					return nil, fmt.Errorf("unsupported window '%s' in '%s', expected one of: %s",
+						window, extension, strings.Join(rateLimitingWindows, ", "))
+				}
+				limit, err := parseRateLimit(extension, node.Content[j+1])
+				if err != nil {
+					return nil, err
+				}
+				config[window] = limit
+			}
+			return config, nil
+		}
+	}
+	return nil, nil
+}
+
+// getHeaderValue returns the documented value of a header; its example, or the default, const,
+// example, or first enum value of its schema. Returns "" if there is none.
+func getHeaderValue(header *v3.Header) string {
+	if header.Example != nil && header.Example.Kind == yaml.ScalarNode {
+		return header.Example.Value
+	}
+	if header.Schema == nil || header.Schema.Schema() == nil {
+		return ""
+	}
+	schema := header.Schema.Schema()
+	for _, node := range []*yaml.Node{schema.Default, schema.Const, schema.Example} {
+		if node != nil && node.Kind == yaml.ScalarNode {
+			return node.Value
+		}
+	}
+	if len(schema.Enum) > 0 && schema.Enum[0] != nil {
+		return schema.Enum[0].Value
+	}
+	return ""
+}
+
+// getCacheTTL returns the time to live from a 'Cache-Control' value; 's-maxage' takes precedence
+// over 'max-age'. Returns 0 if the response must not be cached by a shared cache.
+func getCacheTTL(cacheControl string) int {
+	maxAge, sharedMaxAge := 0, 0
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "no-store", "no-cache", "private":
+			return 0
+		case "max-age":
+			maxAge, _ = strconv.Atoi(strings.Trim(strings.TrimSpace(value), `"`))
+		case "s-maxage":
+			sharedMaxAge, _ = strconv.Atoi(strings.Trim(strings.TrimSpace(value), `"`))
+		}
+	}
+	if sharedMaxAge > 0 {
+		return sharedMaxAge
+	}
+	return maxAge
+}
+
+// inferProxyCache returns the proxy-cache config from the 'Cache-Control' headers of the responses.
+// Only GET and HEAD operations are cached. If responses have different lifetimes, then the shortest
+// one is used. Returns nil if there is nothing to cache.
+func inferProxyCache(operation *v3.Operation, method string) map[string]interface{} {
+	if (method != http.MethodGet && method != http.MethodHead) ||
+		operation.Responses == nil || operation.Responses.Codes == nil {
+		return nil
+	}
+
+	ttl := 0
+	codes := make([]int, 0)
+	for pair := operation.Responses.Codes.First(); pair != nil; pair = pair.Next() {
+		code, err := strconv.Atoi(pair.Key())
+		if err != nil || pair.Value().Headers == nil {
+			continue // ranges like "2XX" cannot be configured
+		}
+		for header := pair.Value().Headers.First(); header != nil; header = header.Next() {
+			if !strings.EqualFold(header.Key(), "Cache-Control") {
+				continue
+			}
+			if responseTTL := getCacheTTL(getHeaderValue(header.Value())); responseTTL > 0 {
+				codes = append(codes, code)
+				if ttl == 0 || responseTTL < ttl {
+					ttl = responseTTL
+				}
+			}
+		}
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	sort.Ints(codes)
+
+	return map[string]interface{}{
+		"strategy":       "memory",
+		"cache_ttl":      ttl,
+		"request_method": []string{method},
+		"response_code":  codes,
+	}
+}
+
+// formatSunset returns the sunset date as an HTTP-date. Dates in RFC3339 format, or only the date
+// ("2006-01-02") are converted, other values are returned as-is.
+func formatSunset(sunset string) string {
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, sunset); err == nil {
+			return t.UTC().Format(http.TimeFormat)
+		}
+	}
+	return sunset
+}
+
+// inferDeprecation returns the response-transformer config adding the 'Deprecation' header to the
+// responses of deprecated operations, and the 'Sunset' header if the sunset extension is set.
+// Returns nil if the operation isn't deprecated.
+func inferDeprecation(
+	mapping *policyMapping,
+	operation *v3.Operation,
+	levels []provenanceLevel,
+) map[string]interface{} {
+	if operation.Deprecated == nil || !*operation.Deprecated {
+		return nil
+	}
+
+	headers := []string{"Deprecation: true"}
+	if mapping.SunsetExtension != "" {
+		if node := findPolicyHint(levels, mapping.SunsetExtension); node != nil && node.Value != "" {
+			headers = append(headers, "Sunset: "+formatSunset(node.Value))
+		}
+	}
+	return map[string]interface{}{
+		"add": map[string]interface{}{
+			"headers": headers,
+		},
+	}
+}
+
+// newInferredPlugin returns a plugin inferred from the hints in the spec.
+func newInferredPlugin(
+	name string,
+	config map[string]interface{},
+	uuidNamespace uuid.UUID,
+	baseName string,
+	tags []string,
+	skipID bool,
+) *map[string]interface{} {
+	logbasics.Debug("inferred policy plugin", "entity", baseName, "plugin", name)
+	plugin := map[string]interface{}{
+		"name":   name,
+		"config": config,
+		"tags":   tags,
+	}
+	if !skipID {
+		plugin["id"] = createPluginID(uuidNamespace, baseName, plugin)
+	}
+	return &plugin
+}
+
+// inferRateLimitingPlugin returns the rate-limiting plugin for the rate limit extensions on the last of the
+// levels. It is to be placed like an 'x-kong-plugin-rate-limiting' extension on that level would be, so a
+// limit on the document level is a plugin on the services, instead of on every route. Returns nil if policy
+// inference is disabled (no mapping), the level has no rate limit extensions, or if any of the levels
+// defines the plugin explicitly.
+func inferRateLimitingPlugin(
+	mapping *policyMapping,
+	levels []provenanceLevel,
+	uuidNamespace uuid.UUID,
+	baseName string,
+	tags []string,
+	skipID bool,
+) (*map[string]interface{}, error) {
+	if mapping == nil || findPolicyHint(levels, "x-kong-plugin-"+rateLimitingPlugin) != nil {
+		return nil, nil
+	}
+	config, err := inferRateLimiting(mapping, levels[len(levels)-1:])
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return newInferredPlugin(rateLimitingPlugin, config, uuidNamespace, baseName, tags, skipID), nil
+}
+
+// inferPolicyPlugins returns the plugins inferred from the hints in the spec, in alphabetical order.
+// Plugins that are defined by an 'x-kong-plugin-...' extension on any of the levels are not inferred.
+// The rate-limiting plugin is only inferred from the operation level (the last level), see
+// inferRateLimitingPlugin for the other levels.
+func inferPolicyPlugins(
+	mapping *policyMapping,
+	operation *v3.Operation,
+	method string,
+	levels []provenanceLevel,
+	uuidNamespace uuid.UUID,
+	baseName string,
+	tags []string,
+	skipID bool,
+) ([]*map[string]interface{}, error) {
+	configs := make(map[string]map[string]interface{})
+
+	rateLimiting, err := inferRateLimiting(mapping, levels[len(levels)-1:])
+	if err != nil {
+		return nil, err
+	}
+	configs[rateLimitingPlugin] = rateLimiting
+	if mapping.ProxyCache {
+		configs[proxyCachePlugin] = inferProxyCache(operation, method)
+	}
+	if mapping.Deprecation {
+		configs[responseTransformerPlugin] = inferDeprecation(mapping, operation, levels)
+	}
+
+	plugins := make([]*map[string]interface{}, 0)
+	for _, name := range []string{proxyCachePlugin, rateLimitingPlugin, responseTransformerPlugin} {
+		if configs[name] == nil || findPolicyHint(levels, "x-kong-plugin-"+name) != nil {
+			continue
+		}
+		plugins = append(plugins, newInferredPlugin(name, configs[name], uuidNamespace, baseName, tags, skipID))
+	}
+	return plugins, nil
+}
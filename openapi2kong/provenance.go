@@ -0,0 +1,217 @@
+package openapi2kong
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pb33f/libopenapi/orderedmap"
+	"go.yaml.in/yaml/v4"
+)
+
+// ProvenanceEntry describes the origin of a generated entity in the OpenAPI document.
+type ProvenanceEntry struct {
+	Entity     string   `json:"entity"`               // the entity type; "service", "upstream", "route", etc.
+	Name       string   `json:"name"`                 // the entity name, for plugins the plugin name
+	Source     string   `json:"source"`               // JSON pointer to the OAS object the entity was generated from
+	Extensions []string `json:"extensions,omitempty"` // JSON pointers to the 'x-kong-...' extensions that contributed
+}
+
+// Provenance maps the generated entities to their origin in the OpenAPI document. The keys are the
+// entity IDs, or if IDs are skipped, the entity names. Plugins without ID are keyed
+// as "<owner-name>.plugin.<plugin-name>".
+type Provenance map[string]ProvenanceEntry
+
+// provenanceLevel is an object in the OAS document, with extensions that apply to an entity.
+type provenanceLevel struct {
+	pointer    string                              // JSON pointer to the object
+	extensions *orderedmap.Map[string, *yaml.Node] // the extensions of the object, may be nil
+}
+
+// provenanceOrigin is the recorded origin of an entity.
+type provenanceOrigin struct {
+	source string            // JSON pointer to the OAS object the entity was generated from
+	levels []provenanceLevel // the levels contributing extensions, from generic (document) to specific
+}
+
+// provenanceExtensions are the extensions that contribute to an entity, by entity type. Plugins also
+// get their 'x-kong-plugin-<name>' extension.
+var provenanceExtensions = map[string][]string{
+	"service":        {"x-kong-name", "x-kong-tags", "x-kong-service-defaults", "x-kong-upstream-defaults"},
+	"upstream":       {"x-kong-name", "x-kong-tags", "x-kong-upstream-defaults"},
+	"route":          {"x-kong-name", "x-kong-tags", "x-kong-route-defaults", "x-kong-route-granularity"},
+	"plugin":         {"x-kong-tags"},
+	"consumer":       {"x-kong-tags"},
+	"consumer_group": {"x-kong-consumer-groups", "x-kong-tags"},
+}
+
+// provenanceTracker records the origins of entities while converting. All methods are no-ops on a nil
+// tracker, such that tracking is optional.
+type provenanceTracker struct {
+	origins   map[string]provenanceOrigin // the recorded origins by "<entity-type>:<entity-name>"
+	tagsGiven bool                        // tags were provided in the options, so 'x-kong-tags' is not used
+}
+
+// newProvenanceTracker returns a new tracker. Set tagsGiven if the tags were passed in the options.
+func newProvenanceTracker(tagsGiven bool) *provenanceTracker {
+	return &provenanceTracker{
+		origins:   make(map[string]provenanceOrigin),
+		tagsGiven: tagsGiven,
+	}
+}
+
+// escapeJSONPointer escapes a key for use as a JSON pointer segment.
+func escapeJSONPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// add records the origin of an entity by its type and name.
+func (t *provenanceTracker) add(entity string, name string, source string, levels ...provenanceLevel) {
+	if t == nil {
+		return
+	}
+	t.origins[entity+":"+name] = provenanceOrigin{
+		source: source,
+		levels: levels,
+	}
+}
+
+// addEntity records the origin of an entity, by the name of the entity object. Nil entities are ignored.
+func (t *provenanceTracker) addEntity(entity string, object map[string]interface{}, source string,
+	levels ...provenanceLevel,
+) {
+	if object == nil {
+		return
+	}
+	if name, ok := object["name"].(string); ok {
+		t.add(entity, name, source, levels...)
+	}
+}
+
+// findExtension returns the JSON pointers for the extension, as defined on the most specific level. If
+// the extension is a reference into '/components/x-kong/', then the reference target is included.
+func (t *provenanceTracker) findExtension(levels []provenanceLevel, key string) []string {
+	for i := len(levels) - 1; i >= 0; i-- {
+		if levels[i].extensions == nil {
+			continue
+		}
+		node, ok := levels[i].extensions.Get(key)
+		if !ok || node == nil {
+			continue
+		}
+		pointers := []string{levels[i].pointer + "/" + escapeJSONPointer(key)}
+		if node.Kind == yaml.MappingNode {
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == "$ref" {
+					pointers = append(pointers, node.Content[j+1].Value)
+				}
+			}
+		}
+		return pointers
+	}
+	return nil
+}
+
+// getEntry returns the provenance entry for an entity, based on the recorded origin.
+func (t *provenanceTracker) getEntry(entity string, name string, origin provenanceOrigin) ProvenanceEntry {
+	entry := ProvenanceEntry{
+		Entity: entity,
+		Name:   name,
+		Source: origin.source,
+	}
+	if entity == "plugin" {
+		if pointers := t.findExtension(origin.levels, "x-kong-plugin-"+name); pointers != nil {
+			// the plugin was defined by the extension, and not generated
+			entry.Source = pointers[0]
+			entry.Extensions = append(entry.Extensions, pointers...)
+		}
+	}
+	for _, key := range provenanceExtensions[entity] {
+		if key == "x-kong-tags" && t.tagsGiven {
+			continue
+		}
+		entry.Extensions = append(entry.Extensions, t.findExtension(origin.levels, key)...)
+	}
+	sort.Strings(entry.Extensions)
+	return entry
+}
+
+// getObjectList returns the objects from a list of entities, whether typed as the converter creates
+// plugin lists, or as plain JSON data (copied entities, and other lists).
+func getObjectList(value interface{}) []map[string]interface{} {
+	objects := make([]map[string]interface{}, 0)
+	switch list := value.(type) {
+	case *[]*map[string]interface{}:
+		if list != nil {
+			for _, object := range *list {
+				objects = append(objects, *object)
+			}
+		}
+	case []interface{}:
+		for _, object := range list {
+			if o, ok := object.(map[string]interface{}); ok {
+				objects = append(objects, o)
+			}
+		}
+	}
+	return objects
+}
+
+// getEntityKey returns the key of an entity in the provenance map; its ID, or name if there is no ID.
+func getEntityKey(object map[string]interface{}, name string) string {
+	if id, ok := object["id"].(string); ok && id != "" {
+		return id
+	}
+	return name
+}
+
+// build creates the provenance for all entities in the conversion result.
+func (t *provenanceTracker) build(result map[string]interface{}) Provenance {
+	provenance := make(Provenance)
+
+	// addEntities adds the entities of the given type, and the plugins attached to them
+	var addEntities func(entity string, value interface{})
+	addEntities = func(entity string, value interface{}) {
+		for _, object := range getObjectList(value) {
+			name, _ := object["name"].(string)
+			if entity == "consumer" {
+				name, _ = object["username"].(string)
+			}
+			origin, found := t.origins[entity+":"+name]
+			if !found {
+				continue
+			}
+			provenance[getEntityKey(object, name)] = t.getEntry(entity, name, origin)
+			for _, plugin := range getObjectList(object["plugins"]) {
+				pluginName, _ := plugin["name"].(string)
+				provenance[getEntityKey(plugin, name+".plugin."+pluginName)] = t.getEntry("plugin", pluginName, origin)
+			}
+			if entity == "service" {
+				addEntities("route", object["routes"])
+			}
+		}
+	}
+	addEntities("service", result["services"])
+	addEntities("upstream", result["upstreams"])
+	addEntities("consumer", result["consumers"])
+	addEntities("consumer_group", result["consumer_groups"])
+
+	// the top-level plugins have foreign keys to their owners
+	for _, plugin := range getObjectList(result["plugins"]) {
+		pluginName, _ := plugin["name"].(string)
+		for _, owner := range []string{"route", "service"} {
+			ownerName, ok := plugin[owner].(string)
+			if !ok {
+				continue
+			}
+			if origin, found := t.origins[owner+":"+ownerName]; found {
+				key := ownerName + ".plugin." + pluginName
+				if consumer, ok := plugin["consumer"].(string); ok {
+					key = key + "." + consumer
+				}
+				provenance[getEntityKey(plugin, key)] = t.getEntry("plugin", pluginName, origin)
+			}
+			break
+		}
+	}
+	return provenance
+}
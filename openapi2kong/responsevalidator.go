@@ -0,0 +1,220 @@
+package openapi2kong
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kong/go-apiops/jsonbasics"
+	"github.com/kong/go-apiops/logbasics"
+	openapibase "github.com/pb33f/libopenapi/datamodel/high/base"
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"github.com/pb33f/libopenapi/orderedmap"
+)
+
+const (
+	// the plugin used for validating responses
+	responseValidatorPlugin = "oas-validation"
+	// the OAS versions of the generated specs for the plugin
+	responseValidatorOAS30 = "3.0.3"
+	responseValidatorOAS31 = "3.1.0"
+)
+
+// rewriteDefinitionRefs updates all references to "#/definitions/" (as generated by extractSchema)
+// in place, to point to "#/components/schemas/".
+func rewriteDefinitionRefs(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, sub := range v {
+			if ref, ok := sub.(string); ok && key == "$ref" && strings.HasPrefix(ref, "#/definitions/") {
+				v[key] = componentsSchemasRef + strings.TrimPrefix(ref, "#/definitions/")
+			} else {
+				rewriteDefinitionRefs(sub)
+			}
+		}
+	case []interface{}:
+		for _, sub := range v {
+			rewriteDefinitionRefs(sub)
+		}
+	}
+}
+
+// generateResponseSpec returns a minimal OAS spec (JSON) holding only the responses of the operation, with
+// all schemas dereferenced, per status code and media type. The path and operation level parameters are
+// included, such that the path template is valid. Returns "" if the operation has no responses.
+func generateResponseSpec(doc v3.Document, path string, method string, pathItem *v3.PathItem,
+	operation *v3.Operation, oas31 bool,
+) string {
+	if operation.Responses == nil {
+		return ""
+	}
+
+	// OAS 3.0 schemas are draft4 based, OAS 3.1 schemas are JSONschema 2020-12
+	openapiVersion, schemaVersion := responseValidatorOAS30, jsonSchemaDraft4
+	if oas31 {
+		openapiVersion, schemaVersion = responseValidatorOAS31, jsonSchemaDraft202012
+	}
+
+	components := make(map[string]interface{})
+	generateSchema := func(proxy *openapibase.SchemaProxy) map[string]interface{} {
+		_, schema := extractSchema(proxy, oas31, schemaVersion)
+		// the definitions are shared by all schemas, so move them to the components
+		if definitions, ok := schema["definitions"].(map[string]interface{}); ok {
+			for name, definition := range definitions {
+				components[name] = definition
+			}
+			delete(schema, "definitions")
+		}
+		return schema
+	}
+	generateContent := func(mediaTypes *orderedmap.Map[string, *v3.MediaType]) map[string]interface{} {
+		content := make(map[string]interface{})
+		for pair := mediaTypes.First(); pair != nil; pair = pair.Next() {
+			mediaType := make(map[string]interface{})
+			if schema := generateSchema(pair.Value().Schema); schema != nil {
+				mediaType["schema"] = schema
+			}
+			content[pair.Key()] = mediaType
+		}
+		return content
+	}
+	generateResponse := func(response *v3.Response) map[string]interface{} {
+		result := map[string]interface{}{
+			"description": response.Description,
+		}
+		if response.Content != nil && response.Content.Len() > 0 {
+			result["content"] = generateContent(response.Content)
+		}
+		return result
+	}
+	generateParameters := func(parameters []*v3.Parameter) []interface{} {
+		result := make([]interface{}, 0, len(parameters))
+		for _, parameter := range parameters {
+			if parameter == nil {
+				continue
+			}
+			param := map[string]interface{}{
+				"name": parameter.Name,
+				"in":   parameter.In,
+			}
+			if parameter.Required != nil {
+				param["required"] = *parameter.Required
+			}
+			if parameter.Style != "" {
+				param["style"] = parameter.Style
+			}
+			if parameter.Explode != nil {
+				param["explode"] = *parameter.Explode
+			}
+			if parameter.Content != nil && parameter.Content.Len() > 0 {
+				param["content"] = generateContent(parameter.Content)
+			} else {
+				schema := generateSchema(parameter.Schema)
+				if schema == nil {
+					schema = map[string]interface{}{} // a parameter requires either a schema or content
+				}
+				param["schema"] = schema
+			}
+			result = append(result, param)
+		}
+		return result
+	}
+
+	responses := make(map[string]interface{})
+	if operation.Responses.Codes != nil {
+		for pair := operation.Responses.Codes.First(); pair != nil; pair = pair.Next() {
+			responses[pair.Key()] = generateResponse(pair.Value())
+		}
+	}
+	if operation.Responses.Default != nil {
+		responses["default"] = generateResponse(operation.Responses.Default)
+	}
+	if len(responses) == 0 {
+		return ""
+	}
+
+	operationSpec := map[string]interface{}{
+		"responses": responses,
+	}
+	if len(operation.Parameters) > 0 {
+		operationSpec["parameters"] = generateParameters(operation.Parameters)
+	}
+	pathSpec := map[string]interface{}{
+		strings.ToLower(method): operationSpec,
+	}
+	if pathItem != nil && len(pathItem.Parameters) > 0 {
+		pathSpec["parameters"] = generateParameters(pathItem.Parameters)
+	}
+
+	spec := map[string]interface{}{
+		"openapi": openapiVersion,
+		"info": map[string]interface{}{
+			"title":   doc.Info.Title,
+			"version": doc.Info.Version,
+		},
+		"paths": map[string]interface{}{
+			path: pathSpec,
+		},
+	}
+	if len(components) > 0 {
+		spec["components"] = map[string]interface{}{
+			"schemas": components,
+		}
+	}
+	rewriteDefinitionRefs(spec)
+
+	result, _ := json.Marshal(spec)
+	return string(result)
+}
+
+// generateResponseValidatorPlugin returns the 'oas-validation' plugin validating the responses of the
+// operation, based on the given plugin config (JSON). The 'api_spec' is generated unless provided. Request
+// validation is disabled unless configured, since the request-validator plugin covers that.
+// Returns nil if there is no config, or nothing to validate.
+func generateResponseValidatorPlugin(operationConfigJSON []byte, doc v3.Document, path string, method string,
+	pathItem *v3.PathItem, operation *v3.Operation, uuidNamespace uuid.UUID, baseName string, skipID bool, oas31 bool,
+) *map[string]interface{} {
+	if len(operationConfigJSON) == 0 {
+		return nil
+	}
+	logbasics.Debug("generating response validator plugin", "operation", baseName)
+
+	var pluginConfig map[string]interface{}
+	_ = json.Unmarshal(operationConfigJSON, &pluginConfig)
+
+	// create a new ID here based on the operation
+	if !skipID {
+		pluginConfig["id"] = createPluginID(uuidNamespace, baseName, pluginConfig)
+	}
+
+	config, _ := jsonbasics.ToObject(pluginConfig["config"])
+	if config == nil {
+		config = make(map[string]interface{})
+		pluginConfig["config"] = config
+	}
+
+	if config["api_spec"] == nil {
+		spec := generateResponseSpec(doc, path, method, pathItem, operation, oas31)
+		if spec == "" {
+			// no responses, so nothing to validate
+			return nil
+		}
+		config["api_spec"] = spec
+		config["api_spec_encoded"] = false
+	}
+
+	defaults := map[string]bool{
+		"validate_response":              true,
+		"validate_request_body":          false,
+		"validate_request_header_params": false,
+		"validate_request_query_params":  false,
+		"validate_request_uri_params":    false,
+	}
+	for key, value := range defaults {
+		if config[key] == nil {
+			config[key] = value
+		}
+	}
+
+	return &pluginConfig
+}
@@ -0,0 +1,158 @@
+package openapi2kong
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kong/go-apiops/jsonbasics"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/routeconflicts"
+	"github.com/pb33f/libopenapi/orderedmap"
+	"go.yaml.in/yaml/v4"
+)
+
+// Route granularities, determining how many routes are generated for the operations of a path
+const (
+	RouteGranularityOperation = "operation" // a route per operation
+	RouteGranularityPath      = "path"      // a route per path, for the operations that can share it
+)
+
+// the extension on document or path level, holding the route granularity
+const routeGranularityExtension = "x-kong-route-granularity"
+
+// getRouteGranularity returns the route granularity from the extensions, or the default if not set.
+func getRouteGranularity(extensions *orderedmap.Map[string, *yaml.Node], defaultValue string) (string, error) {
+	if extensions == nil {
+		return defaultValue, nil
+	}
+	node, ok := extensions.Get(routeGranularityExtension)
+	if !ok || node == nil {
+		return defaultValue, nil
+	}
+	if node.Kind != yaml.ScalarNode || (node.Value != RouteGranularityOperation && node.Value != RouteGranularityPath) {
+		return "", fmt.Errorf("expected '%s' to be either '%s' or '%s'", routeGranularityExtension,
+			RouteGranularityOperation, RouteGranularityPath)
+	}
+	return node.Value, nil
+}
+
+// routeCandidate is the route of an operation, that can be merged into the route of its path.
+type routeCandidate struct {
+	service map[string]interface{} // the service the route is attached to
+	route   map[string]interface{} // the route of the operation
+	method  string                 // the method of the operation, uppercased
+	levels  []provenanceLevel      // the levels applying to the route, the operation level last
+}
+
+// getRouteSignature returns the route as JSON, without the fields that differ per operation; the name,
+// ID, methods, and plugin IDs. Routes with the same signature, on the same service, can be merged.
+func getRouteSignature(route map[string]interface{}) string {
+	signature := jsonbasics.DeepCopyObject(route)
+	delete(signature, "name")
+	delete(signature, "id")
+	delete(signature, "methods")
+	if expression, ok := signature["expression"].(string); ok {
+		// the method is the first term of the expression
+		_, signature["expression"], _ = strings.Cut(expression, " && ")
+	}
+	for _, plugin := range getObjectList(signature["plugins"]) {
+		delete(plugin, "id")
+	}
+	serialized, _ := json.Marshal(signature)
+	return string(serialized)
+}
+
+// mergePathRoutes merges the routes of the operations of a path into a single route for the path, named
+// after the path. Only routes that are the same except for their method can be merged, so operations
+// with their own route defaults, plugins, or service keep their own route. If there are multiple sets
+// of equal routes, the largest set is merged, if it has at least 2 routes. Routes referenced by top-level
+// plugins are not merged.
+func mergePathRoutes(
+	candidates []routeCandidate,
+	pathKey string,
+	pathBaseName string,
+	foreignKeyPlugins *[]*map[string]interface{},
+	tracker *provenanceTracker,
+	routeOrigins map[string]routeconflicts.Origin,
+	uuidNamespace uuid.UUID,
+	skipID bool,
+) {
+	referenced := make(map[string]bool) // route names referenced by top-level plugins
+	for _, plugin := range *foreignKeyPlugins {
+		if name, ok := (*plugin)["route"].(string); ok {
+			referenced[name] = true
+		}
+	}
+
+	// group the candidates by service and signature, in order of the methods
+	groups := make(map[string][]routeCandidate)
+	order := make([]string, 0)
+	for _, candidate := range candidates {
+		if referenced[candidate.route["name"].(string)] {
+			continue
+		}
+		key := candidate.service["name"].(string) + "\x00" + getRouteSignature(candidate.route)
+		if _, found := groups[key]; !found {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], candidate)
+	}
+	var merged []routeCandidate
+	for _, key := range order {
+		if len(groups[key]) > len(merged) {
+			merged = groups[key]
+		}
+	}
+	if len(merged) < 2 {
+		return // nothing to merge, so the route keeps its operation name and ID
+	}
+
+	// the first route becomes the path route, the others are removed from the service
+	route := merged[0].route
+	methods := make([]string, len(merged))
+	removed := make(map[string]bool)
+	for i, candidate := range merged {
+		methods[i] = candidate.method
+		if i > 0 {
+			removed[candidate.route["name"].(string)] = true
+		}
+	}
+	logbasics.Debug("merging routes of path", "path", pathKey, "route", pathBaseName, "methods", methods)
+
+	service := merged[0].service
+	routes := make([]interface{}, 0, len(service["routes"].([]interface{})))
+	for _, r := range service["routes"].([]interface{}) {
+		if !removed[r.(map[string]interface{})["name"].(string)] {
+			routes = append(routes, r)
+		}
+	}
+	service["routes"] = routes
+
+	route["name"] = pathBaseName
+	if expression, ok := route["expression"].(string); ok {
+		terms := make([]string, len(methods))
+		for i, method := range methods {
+			terms[i] = "http.method == " + quoteExpressionString(method)
+		}
+		_, rest, _ := strings.Cut(expression, " && ")
+		route["expression"] = "(" + strings.Join(terms, " || ") + ") && " + rest
+	} else {
+		route["methods"] = methods
+	}
+	if !skipID {
+		route["id"] = uuid.NewSHA1(uuidNamespace, []byte(pathBaseName+".route")).String()
+		// the path name may also be the name of a service, so the plugin IDs need their own base
+		for _, plugin := range getObjectList(route["plugins"]) {
+			plugin["id"] = createPluginID(uuidNamespace, pathBaseName+".route", plugin)
+		}
+	}
+
+	routeOrigins[pathBaseName] = routeconflicts.Origin{
+		Method: strings.Join(methods, "|"),
+		Path:   pathKey,
+	}
+	levels := merged[0].levels[:len(merged[0].levels)-1] // without the operation level
+	tracker.add("route", pathBaseName, levels[len(levels)-1].pointer, levels...)
+}
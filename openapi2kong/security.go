@@ -0,0 +1,469 @@
+package openapi2kong
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/jsonbasics"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/openapitools"
+	openapibase "github.com/pb33f/libopenapi/datamodel/high/base"
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"github.com/pb33f/libopenapi/orderedmap"
+)
+
+// securityPluginPrefix is the prefix of the security-scheme extension that configures
+// the plugin to generate, eg. "x-kong-security-openid-connect".
+const securityPluginPrefix = "x-kong-security-"
+
+// oauth2FlowOrder is the order in which OAuth2 flows are checked for endpoints
+var oauth2FlowOrder = []string{"authorizationCode", "clientCredentials", "password", "implicit"}
+
+// normalizeSecurityType returns the lowercase security-scheme type (case-insensitive,
+// accepting camelCase, kebab-case and snake_case)
+func normalizeSecurityType(schemeType string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(schemeType, "_", ""), "-", ""))
+}
+
+// getDefaultSecurityPlugin returns the name of the Kong plugin that implements the
+// security-scheme by default. Returns an error if the scheme is not supported.
+func getDefaultSecurityPlugin(scheme *v3.SecurityScheme) (string, error) {
+	switch normalizeSecurityType(scheme.Type) {
+	case "openidconnect", "oauth2":
+		return "openid-connect", nil
+
+	case "apikey":
+		if strings.ToLower(scheme.In) == "cookie" {
+			// key-auth only reads keys from headers, query, and body, so it would reject every request
+			return "", fmt.Errorf("security-schemes of type 'apiKey' in a cookie are not supported by " +
+				"the key-auth plugin, use an '" + securityPluginPrefix + "...' extension to select another plugin")
+		}
+		return "key-auth", nil
+
+	case "http":
+		switch strings.ToLower(scheme.Scheme) {
+		case "basic":
+			return "basic-auth", nil
+		case "bearer":
+			return "jwt", nil
+		}
+		return "", fmt.Errorf("security-schemes of type 'http' with scheme '%s' are not supported", scheme.Scheme)
+
+	case "mutualtls":
+		return "mtls-auth", nil
+	}
+
+	return "", fmt.Errorf("security-schemes of type '%s' are not supported", scheme.Type)
+}
+
+// getSecurityPluginName returns the name of the Kong plugin to generate for the security-scheme.
+// An 'x-kong-security-<plugin>' extension selects the plugin, if omitted the default
+// plugin for the scheme type is used.
+func getSecurityPluginName(scheme *v3.SecurityScheme) (string, error) {
+	var pluginNames []string
+	if scheme.Extensions != nil {
+		for pair := scheme.Extensions.First(); pair != nil; pair = pair.Next() {
+			if strings.HasPrefix(pair.Key(), securityPluginPrefix) {
+				pluginNames = append(pluginNames, strings.TrimPrefix(pair.Key(), securityPluginPrefix))
+			}
+		}
+	}
+
+	if len(pluginNames) > 1 {
+		return "", fmt.Errorf("a security-scheme can only have a single '%s...' extension, found: %s",
+			securityPluginPrefix, strings.Join(pluginNames, ", "))
+	}
+	if len(pluginNames) == 1 {
+		return pluginNames[0], nil
+	}
+	return getDefaultSecurityPlugin(scheme)
+}
+
+// getSecurityPlugin returns the plugin config for a single security-scheme. The base config is
+// taken from the 'x-kong-security-<plugin>' extension, and then completed with the information
+// from the security-scheme and the scopes.
+func getSecurityPlugin(
+	schemeName string, // the name of the security-scheme
+	scopes []string, // the scopes required for the security-scheme
+	doc v3.Document, // the complete OAS document
+) (map[string]interface{}, error) {
+	var scheme *v3.SecurityScheme
+	if doc.Components != nil && doc.Components.SecuritySchemes != nil {
+		scheme, _ = doc.Components.SecuritySchemes.Get(schemeName)
+	}
+	if scheme == nil {
+		return nil, fmt.Errorf("no security-schemes with name '%s' found in components", schemeName)
+	}
+
+	pluginName, err := getSecurityPluginName(scheme)
+	if err != nil {
+		return nil, fmt.Errorf("security-scheme '%s': %w", schemeName, err)
+	}
+
+	// Construct the base plugin object from x-kong-security...
+	var (
+		pluginBase   map[string]interface{} // the plugin object
+		pluginConfig map[string]interface{} // the plugin.config object
+	)
+	{
+		kongComponents, err := openapitools.GetXKongComponents(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		// grab the base plugin config from the x-kong-... directive
+		pluginBaseData, err := openapitools.GetXKongObject(
+			scheme.Extensions, securityPluginPrefix+pluginName, kongComponents)
+		if err != nil {
+			return nil, err
+		}
+		if pluginBaseData == nil {
+			// no x-kong-... plugin config, so create an empty one
+			pluginBase = make(map[string]interface{})
+		} else {
+			pluginBase, _ = filebasics.Deserialize(pluginBaseData)
+		}
+
+		// ensure we have a plugin.config object
+		if pluginBase["config"] == nil {
+			pluginBase["config"] = make(map[string]interface{})
+		}
+		pluginConfig, err = jsonbasics.ToObject(pluginBase["config"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Complete the config from the security-scheme. The x-kong-... specifies the Kong behaviour,
+	// the OAS specifies the service-behind-kong behaviour. So the former should win, and we only
+	// set properties that were not already set in the plugin config.
+	switch pluginName {
+	case "openid-connect":
+		if err := setOIDCConfig(pluginConfig, scheme, scopes); err != nil {
+			return nil, err
+		}
+
+	case "key-auth", "key-auth-enc":
+		if normalizeSecurityType(scheme.Type) == "apikey" {
+			setKeyAuthConfig(pluginConfig, scheme)
+		}
+
+	default:
+		if len(scopes) > 0 {
+			logbasics.Info("scopes are not supported by the plugin and will be ignored",
+				"security-scheme", schemeName, "plugin", pluginName)
+		}
+	}
+
+	// construct the final plugin
+	pluginBase["name"] = pluginName
+
+	return pluginBase, nil
+}
+
+// setOIDCConfig completes the openid-connect plugin config with the required scopes, and
+// the issuer or OAuth2 endpoints from the security-scheme.
+func setOIDCConfig(pluginConfig map[string]interface{}, scheme *v3.SecurityScheme, scopes []string) error {
+	// Collect all required scopes, from OAS and x-kong-security..
+	scopesRequired, err := jsonbasics.GetStringArrayField(pluginConfig, "scopes_required")
+	if err != nil {
+		return err
+	}
+
+	// merge the scopes from the security-requirement with the scopes from the plugin config
+	for _, scope1 := range scopes {
+		duplicate := false
+		for _, scope2 := range scopesRequired {
+			if scope1 == scope2 {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			scopesRequired = append(scopesRequired, scope1)
+		}
+	}
+	// sort scopesRequired array for deterministic output
+	sort.Strings(scopesRequired)
+	pluginConfig["scopes_required"] = scopesRequired
+
+	if scheme.OpenIdConnectUrl != "" && pluginConfig["issuer"] == nil {
+		pluginConfig["issuer"] = scheme.OpenIdConnectUrl
+	}
+
+	if scheme.Flows != nil {
+		flows := map[string]*v3.OAuthFlow{
+			"authorizationCode": scheme.Flows.AuthorizationCode,
+			"clientCredentials": scheme.Flows.ClientCredentials,
+			"password":          scheme.Flows.Password,
+			"implicit":          scheme.Flows.Implicit,
+		}
+		for _, flowName := range oauth2FlowOrder {
+			flow := flows[flowName]
+			if flow == nil {
+				continue
+			}
+			if flow.AuthorizationUrl != "" && pluginConfig["authorization_endpoint"] == nil {
+				pluginConfig["authorization_endpoint"] = flow.AuthorizationUrl
+			}
+			if flow.TokenUrl != "" && pluginConfig["token_endpoint"] == nil {
+				pluginConfig["token_endpoint"] = flow.TokenUrl
+			}
+		}
+	}
+
+	return nil
+}
+
+// setKeyAuthConfig completes the key-auth plugin config with the key name and location
+// from an apiKey security-scheme.
+func setKeyAuthConfig(pluginConfig map[string]interface{}, scheme *v3.SecurityScheme) {
+	if pluginConfig["key_names"] == nil && scheme.Name != "" {
+		pluginConfig["key_names"] = []string{scheme.Name}
+	}
+
+	location := strings.ToLower(scheme.In)
+	if pluginConfig["key_in_header"] == nil {
+		pluginConfig["key_in_header"] = location == "header"
+	}
+	if pluginConfig["key_in_query"] == nil {
+		pluginConfig["key_in_query"] = location == "query"
+	}
+}
+
+// getRequirementPlugins returns the plugins for a single security-requirement. All schemes in
+// a requirement must pass (logical AND), so the plugins are chained.
+func getRequirementPlugins(
+	requirement *orderedmap.Map[string, []string],
+	doc v3.Document,
+) ([]*map[string]interface{}, error) {
+	plugins := make([]*map[string]interface{}, 0, requirement.Len())
+	schemeNames := make(map[string]string) // plugin name -> scheme name
+
+	for pair := requirement.First(); pair != nil; pair = pair.Next() {
+		plugin, err := getSecurityPlugin(pair.Key(), pair.Value(), doc)
+		if err != nil {
+			return nil, err
+		}
+		pluginName := plugin["name"].(string)
+		if otherScheme, found := schemeNames[pluginName]; found {
+			return nil, fmt.Errorf("security-schemes '%s' and '%s' both require the '%s' plugin, which can only "+
+				"be configured once per route", otherScheme, pair.Key(), pluginName)
+		}
+		schemeNames[pluginName] = pair.Key()
+		plugins = append(plugins, &plugin)
+	}
+
+	return plugins, nil
+}
+
+// getAlternativePlugins returns the plugins for alternative security-requirements (logical OR). Each
+// plugin allows anonymous access, such that the other plugins get a chance to authenticate. If
+// anonymous access is not allowed (optional == false), a request-termination plugin scoped to the
+// anonymous consumer will block the requests that none of the plugins authenticated.
+func getAlternativePlugins(
+	requirements []*orderedmap.Map[string, []string],
+	optional bool, // anonymous access is allowed
+	anonymousConsumer string, // the consumer to use for anonymous access
+	doc v3.Document,
+) ([]*map[string]interface{}, error) {
+	plugins := make([]*map[string]interface{}, 0, len(requirements)+1)
+	schemeNames := make(map[string]string) // plugin name -> scheme name
+
+	for _, requirement := range requirements {
+		if requirement.Len() > 1 {
+			return nil, fmt.Errorf("a security-requirement with multiple security-schemes (logical AND) " +
+				"cannot be combined with other security-requirements (logical OR)")
+		}
+
+		pair := requirement.First()
+		plugin, err := getSecurityPlugin(pair.Key(), pair.Value(), doc)
+		if err != nil {
+			return nil, err
+		}
+		pluginName := plugin["name"].(string)
+		if otherScheme, found := schemeNames[pluginName]; found {
+			return nil, fmt.Errorf("security-schemes '%s' and '%s' both require the '%s' plugin, which can only "+
+				"be configured once per route", otherScheme, pair.Key(), pluginName)
+		}
+		schemeNames[pluginName] = pair.Key()
+
+		pluginConfig, _ := jsonbasics.ToObject(plugin["config"])
+		if pluginConfig["anonymous"] == nil {
+			pluginConfig["anonymous"] = anonymousConsumer
+		}
+		plugins = append(plugins, &plugin)
+	}
+
+	if !optional {
+		plugins = append(plugins, &map[string]interface{}{
+			"name":     "request-termination",
+			"consumer": anonymousConsumer,
+			"config": map[string]interface{}{
+				"status_code": 401,
+				"message":     "Unauthorized",
+			},
+		})
+	}
+
+	return plugins, nil
+}
+
+// getSecurityPlugins returns the list of security plugins to generate for the security requirements,
+// sorted by plugin name. If there are no security requirements (nil), it returns the "inherited" value.
+// An empty list of requirements, or only empty requirements, means no security, and returns an empty list.
+// A single requirement generates a chain of plugins (logical AND), multiple requirements generate
+// plugins that allow anonymous access (logical OR), see getAlternativePlugins.
+func getSecurityPlugins(
+	requirements []*openapibase.SecurityRequirement, // the security requirements to parse
+	doc v3.Document, // the complete OAS document
+	anonymousConsumer string, // the consumer to use for anonymous access
+	inherited *[]*map[string]interface{}, // the inherited security plugins
+	ignoreSecurityErrors bool, // ignore unsupported security requirements (return "inherited" instead of error)
+) (*[]*map[string]interface{}, error) {
+	if requirements == nil {
+		// nothing is defined, so return inherited (can be nil)
+		return inherited, nil
+	}
+
+	// collect the non-empty requirements, an empty requirement means anonymous access is allowed
+	optional := false
+	nonEmpty := make([]*orderedmap.Map[string, []string], 0, len(requirements))
+	for _, requirement := range requirements {
+		if requirement.Requirements == nil || requirement.Requirements.Len() == 0 {
+			optional = true
+		} else {
+			nonEmpty = append(nonEmpty, requirement.Requirements)
+		}
+	}
+
+	var (
+		plugins []*map[string]interface{}
+		err     error
+	)
+	switch {
+	case len(nonEmpty) == 0:
+		// explicitly empty, so security is disabled
+		plugins = make([]*map[string]interface{}, 0)
+	case len(nonEmpty) == 1 && !optional:
+		plugins, err = getRequirementPlugins(nonEmpty[0], doc)
+	default:
+		plugins, err = getAlternativePlugins(nonEmpty, optional, anonymousConsumer, doc)
+	}
+	if err != nil {
+		if ignoreSecurityErrors {
+			logbasics.Info("ignoring unsupported security-requirement", "error", err.Error())
+			return inherited, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return (*plugins[i])["name"].(string) < (*plugins[j])["name"].(string)
+	})
+	return &plugins, nil
+}
+
+// usesAnonymousConsumer returns true if any of the plugins allows anonymous access using the consumer.
+func usesAnonymousConsumer(plugins *[]*map[string]interface{}, anonymousConsumer string) bool {
+	if plugins == nil {
+		return false
+	}
+	for _, plugin := range *plugins {
+		if config, err := jsonbasics.ToObject((*plugin)["config"]); err == nil && config["anonymous"] == anonymousConsumer {
+			return true
+		}
+	}
+	return false
+}
+
+// createAnonymousConsumer creates the consumer entity used for anonymous access.
+func createAnonymousConsumer(
+	username string,
+	tags []string,
+	uuidNamespace uuid.UUID,
+	skipID bool,
+) map[string]interface{} {
+	consumer := map[string]interface{}{
+		"username": username,
+		"tags":     tags,
+	}
+	if !skipID {
+		consumer["id"] = uuid.NewSHA1(uuidNamespace, []byte(username+".consumer")).String()
+	}
+	return consumer
+}
+
+// equalSecurityPlugins returns true if both lists of security plugins are identical
+func equalSecurityPlugins(list1 *[]*map[string]interface{}, list2 *[]*map[string]interface{}) bool {
+	json1, _ := json.Marshal(list1)
+	json2, _ := json.Marshal(list2)
+	return string(json1) == string(json2)
+}
+
+// skipInsecureOperation handles the unsupported security-requirements of an operation, which is skipped
+// such that no unprotected route is generated. In strict mode the error is added to the returned list,
+// to fail the conversion once all operations have been checked, otherwise a warning is logged.
+func skipInsecureOperation(
+	errs []error,
+	method string,
+	path string,
+	operationID string,
+	err error,
+	strict bool,
+) []error {
+	err = fmt.Errorf("operation '%s %s': %w", strings.ToUpper(method), path, err)
+	if strict {
+		return append(errs, err)
+	}
+	logbasics.Warn("skipping operation with unsupported security-requirements, no route is generated",
+		"method", strings.ToUpper(method), "path", path, "operation-id", operationID, "error", err.Error())
+	return errs
+}
+
+// needRouteLevelSecurity checks whether the document level security plugins can be attached to
+// the services. A route can override a service-level plugin only with a plugin by the same name.
+// So if any operation has security that doesn't override all of the document level plugins, then
+// the document level plugins must be attached to the routes instead. The same goes for consumer
+// bound plugins (request-termination for anonymous access), since they are scoped to a single entity.
+func needRouteLevelSecurity(
+	doc v3.Document,
+	docSecurityPlugins *[]*map[string]interface{},
+	anonymousConsumer string,
+) bool {
+	if docSecurityPlugins == nil || len(*docSecurityPlugins) == 0 || doc.Paths == nil {
+		return false
+	}
+
+	for _, plugin := range *docSecurityPlugins {
+		if (*plugin)["consumer"] != nil {
+			return true
+		}
+	}
+
+	for path := doc.Paths.PathItems.First(); path != nil; path = path.Next() {
+		for op := path.Value().GetOperations().First(); op != nil; op = op.Next() {
+			operationPlugins, err := getSecurityPlugins(op.Value().Security, doc, anonymousConsumer,
+				docSecurityPlugins, true)
+			if err != nil || operationPlugins == nil {
+				// cannot happen when ignoring errors
+				continue
+			}
+
+			overridden := make(map[string]bool)
+			for _, plugin := range *operationPlugins {
+				overridden[(*plugin)["name"].(string)] = true
+			}
+			for _, plugin := range *docSecurityPlugins {
+				if !overridden[(*plugin)["name"].(string)] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
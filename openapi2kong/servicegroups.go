@@ -0,0 +1,202 @@
+package openapi2kong
+
+import (
+	"fmt"
+
+	"github.com/kong/go-apiops/openapitools"
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"github.com/pb33f/libopenapi/orderedmap"
+	"go.yaml.in/yaml/v4"
+)
+
+// Service grouping strategies, determining which Kong services are created
+const (
+	ServiceGroupingDocument  = "document"  // a service per document, unless overridden on path/operation level
+	ServiceGroupingPath      = "path"      // a service per path
+	ServiceGroupingTag       = "tag"       // a service per OAS tag, by the first tag of an operation
+	ServiceGroupingExtension = "extension" // a service per 'x-kong-service-group' value
+)
+
+const (
+	// the extension on operation or path level, holding the name of the service group
+	serviceGroupExtension = "x-kong-service-group"
+	// the document level extension holding the group definitions, by name
+	serviceGroupsExtension = "x-kong-service-groups"
+)
+
+// serviceGroup is a service created for a group of operations.
+type serviceGroup struct {
+	extensions          *orderedmap.Map[string, *yaml.Node] // the extensions defining the group, if any
+	pointer             string                              // JSON pointer to the group definition
+	service             map[string]interface{}              // the service entity for the group
+	upstream            map[string]interface{}              // the upstream entity, if the group has upstream defaults
+	validatorConfig     []byte                              // JSON string representation of validator config to generate
+	respValidatorConfig []byte                              // JSON string representation of response validator config
+}
+
+// validateServiceGrouping checks the grouping strategy, and returns it, defaulting to "document".
+func validateServiceGrouping(grouping string) (string, error) {
+	switch grouping {
+	case "":
+		return ServiceGroupingDocument, nil
+	case ServiceGroupingDocument, ServiceGroupingPath, ServiceGroupingTag, ServiceGroupingExtension:
+		return grouping, nil
+	}
+	return "", fmt.Errorf("unsupported service grouping '%s', expected '%s', '%s', '%s', or '%s'", grouping,
+		ServiceGroupingDocument, ServiceGroupingPath, ServiceGroupingTag, ServiceGroupingExtension)
+}
+
+// getServiceGroupName returns the name of the service group of the operation, or "" if it has none.
+// For "tag" grouping it is the first tag of the operation, for "extension" grouping it is the
+// 'x-kong-service-group' of the operation, or of the path.
+func getServiceGroupName(grouping string, operation *v3.Operation, pathitem *v3.PathItem) (string, error) {
+	switch grouping {
+	case ServiceGroupingTag:
+		if len(operation.Tags) > 0 {
+			return operation.Tags[0], nil
+		}
+	case ServiceGroupingExtension:
+		for _, extensions := range []*orderedmap.Map[string, *yaml.Node]{operation.Extensions, pathitem.Extensions} {
+			if extensions == nil {
+				continue
+			}
+			if node, ok := extensions.Get(serviceGroupExtension); ok && node != nil {
+				if node.Kind != yaml.ScalarNode || node.Value == "" {
+					return "", fmt.Errorf("expected '%s' to be a non-empty string", serviceGroupExtension)
+				}
+				return node.Value, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// getServiceGroupExtensions returns the extensions defining a service group; its 'x-kong-name',
+// service/upstream defaults, and plugins. For "tag" grouping these are the extensions of the tag
+// object, for "extension" grouping the entry in the document level 'x-kong-service-groups'.
+// Returns nil if the group has no definition.
+func getServiceGroupExtensions(grouping string, doc v3.Document, name string,
+) (*orderedmap.Map[string, *yaml.Node], error) {
+	if grouping == ServiceGroupingTag {
+		for _, tag := range doc.Tags {
+			if tag != nil && tag.Name == name {
+				return tag.Extensions, nil
+			}
+		}
+		return nil, nil
+	}
+
+	if doc.Extensions == nil {
+		return nil, nil
+	}
+	groups, ok := doc.Extensions.Get(serviceGroupsExtension)
+	if !ok || groups == nil {
+		return nil, nil
+	}
+	if groups.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected '%s' to be an object", serviceGroupsExtension)
+	}
+	for i := 0; i+1 < len(groups.Content); i += 2 {
+		if groups.Content[i].Value != name {
+			continue
+		}
+		group := groups.Content[i+1]
+		if group.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("expected '%s.%s' to be an object", serviceGroupsExtension, name)
+		}
+		extensions := orderedmap.New[string, *yaml.Node]()
+		for j := 0; j+1 < len(group.Content); j += 2 {
+			extensions.Set(group.Content[j].Value, group.Content[j+1])
+		}
+		return extensions, nil
+	}
+	return nil, nil
+}
+
+// getServiceGroupPointer returns the JSON pointer to the definition of a service group; the tag object,
+// or the entry in 'x-kong-service-groups'. Returns the document root if the tag is not defined.
+func getServiceGroupPointer(grouping string, doc v3.Document, name string) string {
+	if grouping == ServiceGroupingExtension {
+		return "#/" + serviceGroupsExtension + "/" + escapeJSONPointer(name)
+	}
+	for i, tag := range doc.Tags {
+		if tag != nil && tag.Name == name {
+			return fmt.Sprintf("#/tags/%d", i)
+		}
+	}
+	return "#"
+}
+
+// createServiceGroup creates the service for a group, named "<namePrefix><group>", or by the 'x-kong-name'
+// of the group definition. The service/upstream defaults of the group take precedence over the document
+// level ones. If the group has no upstream defaults, then the service host is left empty, to be set to the
+// document level host by the caller. Plugins are not added.
+func createServiceGroup(name string, grouping string, doc v3.Document, namePrefix string,
+	components *map[string]interface{}, tags []string, opts O2kOptions,
+) (*serviceGroup, error) {
+	extensions, err := getServiceGroupExtensions(grouping, doc, name)
+	if err != nil {
+		return nil, err
+	}
+
+	baseName, err := getKongName(extensions)
+	if err != nil {
+		return nil, err
+	}
+	if baseName == "" {
+		baseName = name
+	}
+	baseName = namePrefix + openapitools.Slugify(opts.InsoCompat, baseName)
+
+	serviceDefaults, err := getServiceDefaults(extensions, components)
+	if err != nil {
+		return nil, err
+	}
+	if serviceDefaults == nil {
+		if serviceDefaults, err = getServiceDefaults(doc.Extensions, components); err != nil {
+			return nil, err
+		}
+	}
+	upstreamDefaults, err := getUpstreamDefaults(extensions, components)
+	if err != nil {
+		return nil, err
+	}
+	newUpstream := upstreamDefaults != nil
+	if !newUpstream {
+		if upstreamDefaults, err = getUpstreamDefaults(doc.Extensions, components); err != nil {
+			return nil, err
+		}
+	}
+
+	service, upstream, err := openapitools.CreateKongService(baseName, doc.Servers, serviceDefaults,
+		upstreamDefaults, tags, opts.UUIDNamespace, opts.SkipID)
+	if err != nil {
+		return nil, err
+	}
+	if upstream != nil && !newUpstream {
+		// the document level upstream is used
+		upstream = nil
+		delete(service, "host")
+	}
+
+	return &serviceGroup{
+		extensions: extensions,
+		pointer:    getServiceGroupPointer(grouping, doc, name),
+		service:    service,
+		upstream:   upstream,
+	}, nil
+}
+
+// checkServiceNames returns an error if multiple services have the same name, eg. a group service and a
+// path level service, since decK requires unique names.
+func checkServiceNames(services []interface{}) error {
+	names := make(map[string]bool, len(services))
+	for _, service := range services {
+		name, _ := service.(map[string]interface{})["name"].(string)
+		if names[name] {
+			return fmt.Errorf("multiple services are named '%s'; use 'x-kong-name' to make the names unique", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
@@ -0,0 +1,716 @@
+package openapi2kong
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/jsonbasics"
+	"github.com/kong/go-apiops/logbasics"
+)
+
+// the OpenAPI version that Swagger 2.0 documents are upgraded to
+const swagger2UpgradeVersion = "3.0.3"
+
+// the default media-type if a Swagger document has no 'consumes' or 'produces'
+const swagger2DefaultMediaType = "application/json"
+
+// swagger2SchemaKeys are the Swagger 2.0 parameter/header/items properties that
+// move into the 'schema' object in OpenAPI 3.
+var swagger2SchemaKeys = []string{
+	"type", "format", "items", "default", "maximum", "exclusiveMaximum", "minimum",
+	"exclusiveMinimum", "maxLength", "minLength", "pattern", "maxItems", "minItems",
+	"uniqueItems", "enum", "multipleOf",
+}
+
+// swagger2RefPrefixes maps the Swagger 2.0 reference locations to their OpenAPI 3 equivalent.
+var swagger2RefPrefixes = map[string]string{
+	"#/definitions/": "#/components/schemas/",
+	"#/parameters/":  "#/components/parameters/",
+	"#/responses/":   "#/components/responses/",
+}
+
+// swagger2Upgrader holds the document-level context while upgrading a Swagger 2.0 document.
+type swagger2Upgrader struct {
+	consumes   []string               // document-level 'consumes' media-types
+	produces   []string               // document-level 'produces' media-types
+	parameters map[string]interface{} // document-level 'parameters' (for resolving references)
+}
+
+// upgradeSwagger2 upgrades a Swagger 2.0 document to an OpenAPI 3.0 document. The
+// returned document is JSON encoded. All 'x-' extensions are retained, so the 'x-kong-...'
+// directives work the same as for OpenAPI 3 documents. Since Swagger 2.0 has no 'components'
+// object, the reusable Kong components can be specified in a top-level 'x-kong' object,
+// which will be moved to '/components/x-kong'.
+func upgradeSwagger2(content []byte) ([]byte, error) {
+	swagger, err := filebasics.Deserialize(content)
+	if err != nil {
+		return nil, err
+	}
+
+	u := swagger2Upgrader{
+		consumes:   getSwagger2MediaTypes(swagger, "consumes", nil),
+		produces:   getSwagger2MediaTypes(swagger, "produces", nil),
+		parameters: getSwagger2Object(swagger, "parameters"),
+	}
+
+	oas := make(map[string]interface{})
+	oas["openapi"] = swagger2UpgradeVersion
+	for key, value := range swagger {
+		switch key {
+		case "info", "tags", "externalDocs", "security":
+			oas[key] = value
+		case "x-kong":
+			// moved to /components/x-kong below
+		default:
+			if strings.HasPrefix(key, "x-") {
+				oas[key] = value
+			}
+		}
+	}
+
+	servers, err := u.servers(swagger)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) > 0 {
+		oas["servers"] = servers
+	}
+
+	components := make(map[string]interface{})
+	if definitions := getSwagger2Object(swagger, "definitions"); definitions != nil {
+		schemas := make(map[string]interface{})
+		for name, schema := range definitions {
+			schemas[name] = convertSwagger2Schema(schema)
+		}
+		components["schemas"] = schemas
+	}
+
+	if len(u.parameters) > 0 {
+		parameters := make(map[string]interface{})
+		requestBodies := make(map[string]interface{})
+		for name, param := range u.parameters {
+			paramObj, err := jsonbasics.ToObject(param)
+			if err != nil {
+				return nil, fmt.Errorf("expected parameter '%s' to be an object", name)
+			}
+			switch paramObj["in"] {
+			case "body":
+				requestBodies[name] = convertSwagger2BodyParameter(paramObj, u.consumes)
+			case "formData":
+				// formData parameters are combined into a requestBody where they are used
+			default:
+				parameters[name] = convertSwagger2Parameter(paramObj)
+			}
+		}
+		if len(parameters) > 0 {
+			components["parameters"] = parameters
+		}
+		if len(requestBodies) > 0 {
+			components["requestBodies"] = requestBodies
+		}
+	}
+
+	if responses := getSwagger2Object(swagger, "responses"); responses != nil {
+		converted := make(map[string]interface{})
+		for name, response := range responses {
+			converted[name] = convertSwagger2Response(response, u.produces)
+		}
+		components["responses"] = converted
+	}
+
+	if securityDefinitions := getSwagger2Object(swagger, "securityDefinitions"); securityDefinitions != nil {
+		schemes := make(map[string]interface{})
+		for name, definition := range securityDefinitions {
+			definitionObj, err := jsonbasics.ToObject(definition)
+			if err != nil {
+				return nil, fmt.Errorf("expected security definition '%s' to be an object", name)
+			}
+			if schemes[name], err = convertSwagger2SecurityScheme(definitionObj); err != nil {
+				return nil, fmt.Errorf("failed to convert security definition '%s': %w", name, err)
+			}
+		}
+		components["securitySchemes"] = schemes
+	}
+
+	if xKong, found := swagger["x-kong"]; found {
+		components["x-kong"] = xKong
+	}
+	if len(components) > 0 {
+		oas["components"] = components
+	}
+
+	paths := make(map[string]interface{})
+	for pathKey, pathItem := range getSwagger2Object(swagger, "paths") {
+		if strings.HasPrefix(pathKey, "x-") {
+			paths[pathKey] = pathItem
+			continue
+		}
+		pathObj, err := jsonbasics.ToObject(pathItem)
+		if err != nil {
+			return nil, fmt.Errorf("expected path '%s' to be an object", pathKey)
+		}
+		if paths[pathKey], err = u.pathItem(pathObj); err != nil {
+			return nil, fmt.Errorf("failed to convert path '%s': %w", pathKey, err)
+		}
+	}
+	oas["paths"] = paths
+
+	if err := updateSwagger2Refs(oas); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(oas)
+}
+
+// getSwagger2Object returns the named field as an object, or nil if it isn't one.
+func getSwagger2Object(object map[string]interface{}, fieldName string) map[string]interface{} {
+	result, err := jsonbasics.ToObject(object[fieldName])
+	if err != nil {
+		return nil
+	}
+	return result
+}
+
+// getSwagger2MediaTypes returns the media-types from a 'consumes'/'produces' field. If
+// not set, the inherited value is returned.
+func getSwagger2MediaTypes(object map[string]interface{}, fieldName string, inherited []string) []string {
+	if object[fieldName] == nil {
+		return inherited
+	}
+	mediaTypes, err := jsonbasics.GetStringArrayField(object, fieldName)
+	if err != nil {
+		return inherited
+	}
+	return mediaTypes
+}
+
+// servers creates the OpenAPI 3 servers block from 'host', 'basePath' and 'schemes'.
+// Since a Kong service has a single protocol, only one server is created. The scheme
+// "https" takes precedence over "http", which is also the default if none is given.
+func (u *swagger2Upgrader) servers(swagger map[string]interface{}) ([]interface{}, error) {
+	host, err := jsonbasics.GetStringField(swagger, "host")
+	if err != nil && swagger["host"] != nil {
+		return nil, fmt.Errorf("expected 'host' to be a string")
+	}
+	basePath, err := jsonbasics.GetStringField(swagger, "basePath")
+	if err != nil && swagger["basePath"] != nil {
+		return nil, fmt.Errorf("expected 'basePath' to be a string")
+	}
+	schemes, err := jsonbasics.GetStringArrayField(swagger, "schemes")
+	if err != nil {
+		return nil, fmt.Errorf("expected 'schemes' to be an array of strings")
+	}
+
+	if host == "" {
+		if basePath == "" {
+			return nil, nil
+		}
+		// no host, so a relative server url
+		return []interface{}{map[string]interface{}{"url": basePath}}, nil
+	}
+
+	scheme := ""
+	for _, s := range schemes {
+		s = strings.ToLower(s)
+		if s == "https" {
+			scheme = s
+			break
+		}
+		if s == "http" {
+			scheme = s
+		}
+	}
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	return []interface{}{map[string]interface{}{"url": scheme + "://" + host + basePath}}, nil
+}
+
+// resolveParameter returns the parameter, and if it is a reference to a document-level
+// body or formData parameter, the referenced parameter. Other references are returned as is.
+func (u *swagger2Upgrader) resolveParameter(param map[string]interface{}) (map[string]interface{}, error) {
+	ref, ok := param["$ref"].(string)
+	if !ok {
+		return param, nil
+	}
+	if !strings.HasPrefix(ref, "#/parameters/") {
+		return nil, fmt.Errorf("unsupported parameter reference '%s'", ref)
+	}
+	name := strings.TrimPrefix(ref, "#/parameters/")
+	target, err := jsonbasics.ToObject(u.parameters[name])
+	if err != nil {
+		return nil, fmt.Errorf("parameter reference '%s' not found", ref)
+	}
+	switch target["in"] {
+	case "body":
+		// keep the name, the requestBody will reference it
+		return map[string]interface{}{"in": "body", "$ref": "#/components/requestBodies/" + name}, nil
+	case "formData":
+		return target, nil
+	}
+	return param, nil
+}
+
+// collectParameters returns the parameters of a path or operation object, split in
+// regular parameters, the body parameter, and the formData parameters.
+func (u *swagger2Upgrader) collectParameters(object map[string]interface{}) (
+	params []map[string]interface{}, body map[string]interface{}, formData []map[string]interface{}, err error,
+) {
+	list, err := jsonbasics.ToArray(object["parameters"])
+	if err != nil {
+		return nil, nil, nil, nil // no parameters
+	}
+
+	for _, p := range list {
+		param, err := jsonbasics.ToObject(p)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("expected parameters to be objects")
+		}
+		if param, err = u.resolveParameter(param); err != nil {
+			return nil, nil, nil, err
+		}
+		switch param["in"] {
+		case "body":
+			body = param
+		case "formData":
+			formData = append(formData, param)
+		default:
+			params = append(params, param)
+		}
+	}
+	return params, body, formData, nil
+}
+
+// pathItem converts a Swagger 2.0 path item to an OpenAPI 3 path item.
+func (u *swagger2Upgrader) pathItem(pathItem map[string]interface{}) (map[string]interface{}, error) {
+	params, body, formData, err := u.collectParameters(pathItem)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{})
+	for key, value := range pathItem {
+		switch key {
+		case "get", "put", "post", "delete", "options", "head", "patch":
+			operation, err := jsonbasics.ToObject(value)
+			if err != nil {
+				return nil, fmt.Errorf("expected operation '%s' to be an object", key)
+			}
+			if result[key], err = u.operation(operation, body, formData); err != nil {
+				return nil, fmt.Errorf("failed to convert operation '%s': %w", key, err)
+			}
+		case "parameters":
+			if len(params) > 0 {
+				result[key] = convertSwagger2Parameters(params)
+			}
+		default:
+			// $ref, and 'x-' extensions
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// operation converts a Swagger 2.0 operation to an OpenAPI 3 operation. The path-level
+// body and formData parameters are passed in, since they move into the requestBody.
+func (u *swagger2Upgrader) operation(
+	operation map[string]interface{},
+	pathBody map[string]interface{},
+	pathFormData []map[string]interface{},
+) (map[string]interface{}, error) {
+	params, body, formData, err := u.collectParameters(operation)
+	if err != nil {
+		return nil, err
+	}
+	consumes := getSwagger2MediaTypes(operation, "consumes", u.consumes)
+	produces := getSwagger2MediaTypes(operation, "produces", u.produces)
+
+	result := make(map[string]interface{})
+	for key, value := range operation {
+		switch key {
+		case "parameters", "consumes", "produces":
+			// handled separately
+		case "schemes":
+			logbasics.Info("operation level 'schemes' are not supported and will be ignored")
+		case "responses":
+			responses, err := jsonbasics.ToObject(value)
+			if err != nil {
+				return nil, fmt.Errorf("expected 'responses' to be an object")
+			}
+			converted := make(map[string]interface{})
+			for code, response := range responses {
+				if strings.HasPrefix(code, "x-") {
+					converted[code] = response
+				} else {
+					converted[code] = convertSwagger2Response(response, produces)
+				}
+			}
+			result[key] = converted
+		default:
+			result[key] = value
+		}
+	}
+
+	if len(params) > 0 {
+		result["parameters"] = convertSwagger2Parameters(params)
+	}
+
+	// operation level body/formData parameters override the path level ones
+	if body == nil && formData == nil {
+		body = pathBody
+		formData = pathFormData
+	}
+	if body != nil {
+		if ref, ok := body["$ref"].(string); ok {
+			result["requestBody"] = map[string]interface{}{"$ref": ref}
+		} else {
+			result["requestBody"] = convertSwagger2BodyParameter(body, consumes)
+		}
+	} else if formData != nil {
+		result["requestBody"] = convertSwagger2FormData(formData, consumes)
+	}
+
+	return result, nil
+}
+
+// convertSwagger2Parameters converts a list of (non-body) parameters.
+func convertSwagger2Parameters(params []map[string]interface{}) []interface{} {
+	result := make([]interface{}, len(params))
+	for i, param := range params {
+		result[i] = convertSwagger2Parameter(param)
+	}
+	return result
+}
+
+// convertSwagger2Parameter converts a query, header, or path parameter. The type
+// information moves into a schema, and the 'collectionFormat' becomes a style.
+func convertSwagger2Parameter(param map[string]interface{}) map[string]interface{} {
+	if param["$ref"] != nil {
+		return param
+	}
+
+	result := make(map[string]interface{})
+	for key, value := range param {
+		switch key {
+		case "name", "in", "description", "required", "allowEmptyValue":
+			result[key] = value
+		default:
+			if strings.HasPrefix(key, "x-") {
+				result[key] = value
+			}
+		}
+	}
+	result["schema"] = extractSwagger2Schema(param)
+
+	switch param["collectionFormat"] {
+	case "csv":
+		if param["in"] == "query" {
+			result["style"] = "form"
+		} else {
+			result["style"] = "simple"
+		}
+		result["explode"] = false
+	case "ssv":
+		result["style"] = "spaceDelimited"
+		result["explode"] = false
+	case "pipes":
+		result["style"] = "pipeDelimited"
+		result["explode"] = false
+	case "multi":
+		result["style"] = "form"
+		result["explode"] = true
+	case "tsv":
+		logbasics.Info("collectionFormat 'tsv' has no OpenAPI 3 equivalent and will be ignored",
+			"parameter", param["name"])
+	}
+
+	return result
+}
+
+// extractSwagger2Schema moves the type related properties of a parameter, header, or
+// items object into a new schema object.
+func extractSwagger2Schema(object map[string]interface{}) map[string]interface{} {
+	schema := make(map[string]interface{})
+	for _, key := range swagger2SchemaKeys {
+		if value, found := object[key]; found {
+			schema[key] = value
+		}
+	}
+	if items, err := jsonbasics.ToObject(schema["items"]); err == nil {
+		schema["items"] = extractSwagger2Schema(items)
+	}
+	if schema["type"] == "file" {
+		schema["type"] = "string"
+		schema["format"] = "binary"
+	}
+	return schema
+}
+
+// convertSwagger2BodyParameter converts a body parameter into a requestBody object.
+func convertSwagger2BodyParameter(param map[string]interface{}, consumes []string) map[string]interface{} {
+	if len(consumes) == 0 {
+		consumes = []string{swagger2DefaultMediaType}
+	}
+
+	schema := convertSwagger2Schema(param["schema"])
+	content := make(map[string]interface{})
+	for _, mediaType := range consumes {
+		content[mediaType] = map[string]interface{}{"schema": schema}
+	}
+
+	result := map[string]interface{}{"content": content}
+	for key, value := range param {
+		if key == "description" || key == "required" || strings.HasPrefix(key, "x-") {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// convertSwagger2FormData combines formData parameters into a requestBody object, with
+// an object schema that has a property for each parameter.
+func convertSwagger2FormData(params []map[string]interface{}, consumes []string) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	hasFile := false
+	for _, param := range params {
+		name, _ := param["name"].(string)
+		schema := extractSwagger2Schema(param)
+		if description, found := param["description"]; found {
+			schema["description"] = description
+		}
+		if param["type"] == "file" {
+			hasFile = true
+		}
+		properties[name] = schema
+		if isRequired, _ := param["required"].(bool); isRequired {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	// only the form media-types apply to formData
+	mediaTypes := make([]string, 0)
+	for _, mediaType := range consumes {
+		if mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data" {
+			mediaTypes = append(mediaTypes, mediaType)
+		}
+	}
+	if len(mediaTypes) == 0 {
+		if hasFile {
+			mediaTypes = append(mediaTypes, "multipart/form-data")
+		} else {
+			mediaTypes = append(mediaTypes, "application/x-www-form-urlencoded")
+		}
+	}
+
+	content := make(map[string]interface{})
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = map[string]interface{}{"schema": schema}
+	}
+
+	result := map[string]interface{}{"content": content}
+	if len(required) > 0 {
+		result["required"] = true
+	}
+	return result
+}
+
+// convertSwagger2Response converts a response object. The schema and examples are
+// added as content for each of the media-types produced.
+func convertSwagger2Response(response interface{}, produces []string) interface{} {
+	responseObj, err := jsonbasics.ToObject(response)
+	if err != nil || responseObj["$ref"] != nil {
+		return response
+	}
+	if len(produces) == 0 {
+		produces = []string{swagger2DefaultMediaType}
+	}
+
+	result := make(map[string]interface{})
+	for key, value := range responseObj {
+		if key == "description" || strings.HasPrefix(key, "x-") {
+			result[key] = value
+		}
+	}
+	if result["description"] == nil {
+		result["description"] = "" // required in OpenAPI 3
+	}
+
+	if headers, err := jsonbasics.ToObject(responseObj["headers"]); err == nil {
+		converted := make(map[string]interface{})
+		for name, header := range headers {
+			headerObj, err := jsonbasics.ToObject(header)
+			if err != nil {
+				continue
+			}
+			h := map[string]interface{}{"schema": extractSwagger2Schema(headerObj)}
+			if description, found := headerObj["description"]; found {
+				h["description"] = description
+			}
+			converted[name] = h
+		}
+		result["headers"] = converted
+	}
+
+	if responseObj["schema"] != nil {
+		schema := convertSwagger2Schema(responseObj["schema"])
+		examples, _ := jsonbasics.ToObject(responseObj["examples"])
+		content := make(map[string]interface{})
+		for _, mediaType := range produces {
+			mediaContent := map[string]interface{}{"schema": schema}
+			if example, found := examples[mediaType]; found {
+				mediaContent["example"] = example
+			}
+			content[mediaType] = mediaContent
+		}
+		result["content"] = content
+	}
+
+	return result
+}
+
+// convertSwagger2Schema converts the Swagger specific schema properties; 'x-nullable',
+// the 'discriminator' property name, and 'file' types.
+func convertSwagger2Schema(schema interface{}) interface{} {
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{})
+		for key, value := range s {
+			switch key {
+			case "x-nullable":
+				result["nullable"] = value
+			case "discriminator":
+				if propertyName, ok := value.(string); ok {
+					result[key] = map[string]interface{}{"propertyName": propertyName}
+				} else {
+					result[key] = value
+				}
+			case "properties":
+				if properties, err := jsonbasics.ToObject(value); err == nil {
+					converted := make(map[string]interface{})
+					for name, property := range properties {
+						converted[name] = convertSwagger2Schema(property)
+					}
+					result[key] = converted
+				} else {
+					result[key] = value
+				}
+			case "example", "enum", "default", "required":
+				result[key] = value
+			default:
+				result[key] = convertSwagger2Schema(value)
+			}
+		}
+		if result["type"] == "file" {
+			result["type"] = "string"
+			result["format"] = "binary"
+		}
+		return result
+
+	case []interface{}:
+		result := make([]interface{}, len(s))
+		for i, value := range s {
+			result[i] = convertSwagger2Schema(value)
+		}
+		return result
+	}
+	return schema
+}
+
+// convertSwagger2SecurityScheme converts a security definition to a security scheme.
+func convertSwagger2SecurityScheme(definition map[string]interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for key, value := range definition {
+		if key == "description" || strings.HasPrefix(key, "x-") {
+			result[key] = value
+		}
+	}
+
+	switch definition["type"] {
+	case "basic":
+		result["type"] = "http"
+		result["scheme"] = "basic"
+
+	case "apiKey":
+		result["type"] = "apiKey"
+		result["name"] = definition["name"]
+		result["in"] = definition["in"]
+
+	case "oauth2":
+		flow := map[string]interface{}{"scopes": map[string]interface{}{}}
+		if definition["scopes"] != nil {
+			flow["scopes"] = definition["scopes"]
+		}
+		var flowName string
+		switch definition["flow"] {
+		case "implicit":
+			flowName = "implicit"
+			flow["authorizationUrl"] = definition["authorizationUrl"]
+		case "password":
+			flowName = "password"
+			flow["tokenUrl"] = definition["tokenUrl"]
+		case "application":
+			flowName = "clientCredentials"
+			flow["tokenUrl"] = definition["tokenUrl"]
+		case "accessCode":
+			flowName = "authorizationCode"
+			flow["authorizationUrl"] = definition["authorizationUrl"]
+			flow["tokenUrl"] = definition["tokenUrl"]
+		default:
+			return nil, fmt.Errorf("unknown oauth2 flow '%v'", definition["flow"])
+		}
+		result["type"] = "oauth2"
+		result["flows"] = map[string]interface{}{flowName: flow}
+
+	default:
+		return nil, fmt.Errorf("unknown security definition type '%v'", definition["type"])
+	}
+
+	return result, nil
+}
+
+// updateSwagger2Refs updates all '$ref' values from the Swagger 2.0 locations
+// to their OpenAPI 3 locations. References to external files are rejected, since
+// only the document itself is upgraded; the referenced files would still be
+// Swagger 2.0, and fail to resolve as OpenAPI 3.
+func updateSwagger2Refs(data interface{}) error {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		for key, value := range d {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				if !strings.HasPrefix(ref, "#") {
+					return fmt.Errorf("external reference '%s' is not supported in Swagger 2.0 documents, "+
+						"upgrade the document and its referenced files to OpenAPI 3 first", ref)
+				}
+				for oldPrefix, newPrefix := range swagger2RefPrefixes {
+					if strings.HasPrefix(ref, oldPrefix) {
+						d[key] = newPrefix + strings.TrimPrefix(ref, oldPrefix)
+						break
+					}
+				}
+			} else if err := updateSwagger2Refs(value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range d {
+			if err := updateSwagger2Refs(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
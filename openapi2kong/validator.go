@@ -14,7 +14,8 @@ import (
 	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
 )
 
-const JSONSchemaVersion = "draft4"
+// JSONSchemaVersion is the default JSONschema version used by the generated request-validator plugins
+const JSONSchemaVersion = jsonSchemaDraft4
 
 // getDefaultParamStyles returns default styles per OAS parameter-type.
 func getDefaultParamStyle(givenStyle string, paramType string) string {
@@ -34,9 +35,9 @@ func getDefaultParamStyle(givenStyle string, paramType string) string {
 
 // generateParameterSchema returns the given schema if there is one, a generated
 // schema if it was specified, or nil if there is none.
-// Parameters include path, query, and headers
+// Parameters include path, query, and headers. Cookie parameters are validated using the Cookie header.
 func generateParameterSchema(operation *v3.Operation, path *v3.PathItem,
-	insoCompat bool,
+	insoCompat bool, oas31 bool, version string,
 ) ([]map[string]interface{}, error) {
 	pathParameters := path.Parameters
 	operationParameters := operation.Parameters
@@ -71,12 +72,13 @@ func generateParameterSchema(operation *v3.Operation, path *v3.PathItem,
 	result := make([]map[string]interface{}, len(combinedParameters))
 	i := 0
 	invalidParamCounts := 0
+	cookieParameters := make([]*v3.Parameter, 0)
 
 	for _, parameter := range combinedParameters {
 		if parameter != nil {
 			if parameter.In == "cookie" {
-				logbasics.Info("cookie parameters are not supported by the request-validator plugin; validation will be skipped")
-
+				// cookies are validated as a single Cookie header, see below
+				cookieParameters = append(cookieParameters, parameter)
 				invalidParamCounts++
 
 				continue
@@ -108,7 +110,7 @@ func generateParameterSchema(operation *v3.Operation, path *v3.PathItem,
 				paramConf["required"] = false
 			}
 
-			schema, schemaMap := extractSchema(parameter.Schema)
+			schema, schemaMap := extractSchema(parameter.Schema, oas31, version)
 			if schema != "" {
 				paramConf["schema"] = schema
 
@@ -126,7 +128,17 @@ func generateParameterSchema(operation *v3.Operation, path *v3.PathItem,
 
 	// This ensures that we don't return nulls in the map, in case of invalid parameters
 	// indexing makes sure that order is maintained and nulls are in the end
-	return result[:len(result)-invalidParamCounts], nil
+	result = result[:len(result)-invalidParamCounts]
+
+	// the request-validator plugin does not support cookie parameters, so validate the Cookie header instead
+	cookieParam, err := generateCookieParameter(cookieParameters)
+	if err != nil {
+		return nil, err
+	}
+	if cookieParam != nil {
+		result = append(result, cookieParam)
+	}
+	return result, nil
 }
 
 func parseMediaType(mediaType string) (string, string, error) {
@@ -140,7 +152,7 @@ func parseMediaType(mediaType string) (string, string, error) {
 
 // generateBodySchema returns the given schema if there is one, a generated
 // schema if it was specified, or "" if there is none.
-func generateBodySchema(operation *v3.Operation) string {
+func generateBodySchema(operation *v3.Operation, oas31 bool, version string) string {
 	requestBody := operation.RequestBody
 	if requestBody == nil {
 		return ""
@@ -163,7 +175,7 @@ func generateBodySchema(operation *v3.Operation) string {
 			return ""
 		}
 		if typ == "application" && (subtype == "json" || strings.HasSuffix(subtype, "+json")) {
-			schema, _ := extractSchema((*contentValue).Schema)
+			schema, _ := extractSchema((*contentValue).Schema, oas31, version)
 			return schema
 		}
 
@@ -203,10 +215,22 @@ func generateContentTypes(operation *v3.Operation) []string {
 	return list
 }
 
+// getValidatorVersion returns the JSONschema version to generate for the request-validator plugin.
+// If the plugin config specifies a supported JSONschema version, then that one is used, otherwise
+// the default JSONSchemaVersion.
+func getValidatorVersion(config map[string]interface{}) string {
+	if version, ok := config["version"].(string); ok && jsonSchemaVersionIndex(version) != -1 {
+		return version
+	}
+	return JSONSchemaVersion
+}
+
 // generateValidatorPlugin generates the validator plugin configuration, based
-// on the JSON snippet, and the OAS inputs. This can return nil
+// on the JSON snippet, and the OAS inputs. The oas31 flag indicates that the
+// schemas are JSONschema 2020-12 (OAS 3.1), which might require down-conversion.
+// This can return nil
 func generateValidatorPlugin(operationConfigJSON []byte, operation *v3.Operation, path *v3.PathItem,
-	uuidNamespace uuid.UUID, baseName string, skipID bool, insoCompat bool,
+	uuidNamespace uuid.UUID, baseName string, skipID bool, insoCompat bool, oas31 bool,
 ) (*map[string]interface{}, error) {
 	if len(operationConfigJSON) == 0 {
 		return nil, nil
@@ -227,22 +251,24 @@ func generateValidatorPlugin(operationConfigJSON []byte, operation *v3.Operation
 		pluginConfig["config"] = config
 	}
 
+	version := getValidatorVersion(config)
+
 	if config["parameter_schema"] == nil {
-		parameterSchema, err := generateParameterSchema(operation, path, insoCompat)
+		parameterSchema, err := generateParameterSchema(operation, path, insoCompat, oas31, version)
 		if err != nil {
 			return nil, err
 		}
 		if len(parameterSchema) != 0 {
 			config["parameter_schema"] = parameterSchema
-			config["version"] = JSONSchemaVersion
+			config["version"] = version
 		}
 	}
 
 	if config["body_schema"] == nil {
-		bodySchema := generateBodySchema(operation)
+		bodySchema := generateBodySchema(operation, oas31, version)
 		if bodySchema != "" {
 			config["body_schema"] = bodySchema
-			config["version"] = JSONSchemaVersion
+			config["version"] = version
 		} else {
 			if config["parameter_schema"] == nil {
 				// neither parameter nor body schema given, there is nothing to validate
@@ -254,7 +280,7 @@ func generateValidatorPlugin(operationConfigJSON []byte, operation *v3.Operation
 				// add an empty schema, which passes everything, but it also activates the
 				// content-type check
 				config["body_schema"] = "{}"
-				config["version"] = JSONSchemaVersion
+				config["version"] = version
 			}
 		}
 	}
@@ -309,8 +335,17 @@ func fetchTopLevelType(schemaMap map[string]interface{}) (string, bool) {
 
 	// Check if type exists at the current level
 	if typ, ok := schemaMap["type"]; ok {
-		if str, isString := typ.(string); isString {
-			typeStr = str
+		switch typ := typ.(type) {
+		case string:
+			typeStr = typ
+		case []interface{}:
+			// OAS 3.1 type arrays, use the first non-null type
+			for _, t := range typ {
+				if str, isString := t.(string); isString && str != "null" {
+					typeStr = str
+					break
+				}
+			}
 		}
 	}
 
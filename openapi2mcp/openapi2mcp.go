@@ -3,7 +3,9 @@ package openapi2mcp
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"sort"
 	"strings"
@@ -44,6 +46,19 @@ type O2MOptions struct {
 	IncludeDirectRoute bool
 	// Ignore security errors (unsupported schemes, missing x-kong-mcp-acl extension)
 	IgnoreSecurityErrors bool
+	// Base directory for resolving relative file references ($ref: ./schemas/pet.yaml),
+	// typically the directory of the spec file. If empty, only local references are resolved.
+	BasePath string
+	// Filesystem for resolving relative file references, rooted at the directory of the spec
+	// file. Takes precedence over BasePath.
+	FS fs.FS
+	// Local mirrors of remote references, maps a URL prefix to a local path. Conversion is
+	// offline-only, so remote references without a mirror are rejected.
+	RemoteRefMirrors map[string]string
+	// Maximum number of schema objects in the schema of a tool parameter or request body. The referenced
+	// schemas are inlined breadth first, deeper schemas beyond the budget are pruned to their type and
+	// description. Recursive references are always pruned. Defaults to DefaultSchemaBudget.
+	SchemaBudget int
 }
 
 // setDefaults sets the defaults for the OpenAPI2MCP operation.
@@ -57,6 +72,10 @@ func (opts *O2MOptions) setDefaults() {
 	if opts.Mode == "" {
 		opts.Mode = ModeConversionListener
 	}
+
+	if opts.SchemaBudget <= 0 {
+		opts.SchemaBudget = DefaultSchemaBudget
+	}
 }
 
 // getMCPProxyConfig returns the x-kong-mcp-proxy override config
@@ -285,43 +304,8 @@ func getExtensionString(extensions *orderedmap.Map[string, *yaml.Node], key stri
 	return value, nil
 }
 
-// simplifySchema simplifies an OpenAPI schema to essential properties
-func simplifySchema(schema *openapibase.Schema) map[string]interface{} {
-	if schema == nil {
-		return nil
-	}
-
-	result := make(map[string]interface{})
-
-	// Handle type
-	if len(schema.Type) > 0 {
-		result["type"] = schema.Type[0]
-	}
-
-	// For objects, include properties and required
-	if result["type"] == "object" && schema.Properties != nil {
-		props := make(map[string]interface{})
-		for pair := schema.Properties.First(); pair != nil; pair = pair.Next() {
-			propSchema := pair.Value().Schema()
-			props[pair.Key()] = simplifySchema(propSchema)
-		}
-		result["properties"] = props
-
-		if len(schema.Required) > 0 {
-			result["required"] = schema.Required
-		}
-	}
-
-	// For arrays, include items
-	if result["type"] == "array" && schema.Items != nil && schema.Items.A != nil {
-		result["items"] = simplifySchema(schema.Items.A.Schema())
-	}
-
-	return result
-}
-
 // buildParameters builds the parameters array for an MCP tool
-func buildParameters(params []*v3.Parameter) []map[string]interface{} {
+func buildParameters(params []*v3.Parameter, oas31 bool, schemaBudget int) []map[string]interface{} {
 	if len(params) == 0 {
 		return nil
 	}
@@ -342,11 +326,8 @@ func buildParameters(params []*v3.Parameter) []map[string]interface{} {
 			p["description"] = param.Description
 		}
 
-		if param.Schema != nil {
-			schema := param.Schema.Schema()
-			if schema != nil {
-				p["schema"] = simplifySchema(schema)
-			}
+		if schema := getToolSchema(param.Schema, oas31, schemaBudget); schema != nil {
+			p["schema"] = schema
 		}
 
 		result = append(result, p)
@@ -356,7 +337,7 @@ func buildParameters(params []*v3.Parameter) []map[string]interface{} {
 }
 
 // buildRequestBody builds the request_body object for an MCP tool
-func buildRequestBody(rb *v3.RequestBody) map[string]interface{} {
+func buildRequestBody(rb *v3.RequestBody, oas31 bool, schemaBudget int) map[string]interface{} {
 	if rb == nil {
 		return nil
 	}
@@ -374,11 +355,8 @@ func buildRequestBody(rb *v3.RequestBody) map[string]interface{} {
 			mediaTypeObj := pair.Value()
 
 			mediaContent := make(map[string]interface{})
-			if mediaTypeObj.Schema != nil {
-				schema := mediaTypeObj.Schema.Schema()
-				if schema != nil {
-					mediaContent["schema"] = simplifySchema(schema)
-				}
+			if schema := getToolSchema(mediaTypeObj.Schema, oas31, schemaBudget); schema != nil {
+				mediaContent["schema"] = schema
 			}
 			content[mediaType] = mediaContent
 		}
@@ -395,6 +373,8 @@ func buildMCPTool(
 	operation *v3.Operation,
 	pathParams []*v3.Parameter,
 	acl map[string]interface{},
+	oas31 bool,
+	schemaBudget int,
 ) (map[string]interface{}, error) {
 	// Get tool name: x-kong-mcp-tool-name > operationId
 	toolName, err := getExtensionString(operation.Extensions, "x-kong-mcp-tool-name")
@@ -459,12 +439,12 @@ func buildMCPTool(
 	}
 
 	if len(allParams) > 0 {
-		tool["parameters"] = buildParameters(allParams)
+		tool["parameters"] = buildParameters(allParams, oas31, schemaBudget)
 	}
 
 	// Add request body
 	if operation.RequestBody != nil {
-		tool["request_body"] = buildRequestBody(operation.RequestBody)
+		tool["request_body"] = buildRequestBody(operation.RequestBody, oas31, schemaBudget)
 	}
 
 	// Add ACL if provided
@@ -491,10 +471,13 @@ func Convert(content []byte, opts O2MOptions) (map[string]interface{}, error) {
 
 	// convert to openapi2kong options
 	o2kOpts := openapi2kong.O2kOptions{
-		Tags:          opts.Tags,
-		DocName:       opts.DocName,
-		UUIDNamespace: opts.UUIDNamespace,
-		SkipID:        opts.SkipID,
+		Tags:             opts.Tags,
+		DocName:          opts.DocName,
+		UUIDNamespace:    opts.UUIDNamespace,
+		SkipID:           opts.SkipID,
+		BasePath:         opts.BasePath,
+		FS:               opts.FS,
+		RemoteRefMirrors: opts.RemoteRefMirrors,
 	}
 
 	// generate the base Kong configuration
@@ -511,9 +494,18 @@ func Convert(content []byte, opts O2MOptions) (map[string]interface{}, error) {
 	docConfig := datamodel.NewDocumentConfiguration()
 	docConfig.IgnoreArrayCircularReferences = true
 	docConfig.IgnorePolymorphicCircularReferences = true
+	referenceErrors, err := openapitools.ConfigureReferences(docConfig, openapitools.ReferenceOptions{
+		BasePath:      opts.BasePath,
+		FS:            opts.FS,
+		RemoteMirrors: opts.RemoteRefMirrors,
+	})
+	if err != nil {
+		return nil, err
+	}
 	openapiDoc.SetConfiguration(docConfig)
 	v3Model, errs := openapiDoc.BuildV3Model()
 	if errs != nil {
+		errs = errors.Join(referenceErrors(), errs)
 		logbasics.Error(errs, "error while building v3 document model")
 		return nil, fmt.Errorf("cannot create v3 model from document: %w", errs)
 	}
@@ -521,6 +513,7 @@ func Convert(content []byte, opts O2MOptions) (map[string]interface{}, error) {
 	if v3Model != nil {
 		doc = v3Model.Model
 	}
+	oas31 := !strings.HasPrefix(doc.Version, "3.0") // the schemas are JSONschema 2020-12
 
 	// get the main service
 	services, ok := result["services"].([]interface{})
@@ -613,7 +606,8 @@ func Convert(content []byte, opts O2MOptions) (map[string]interface{}, error) {
 					}
 				}
 
-				tool, err := buildMCPTool(pathKey, methodKey, operation, pathItem.Parameters, toolACL)
+				tool, err := buildMCPTool(pathKey, methodKey, operation, pathItem.Parameters, toolACL, oas31,
+					opts.SchemaBudget)
 				if err != nil {
 					return nil, fmt.Errorf("failed to build MCP tool for %s %s: %w", methodKey, pathKey, err)
 				}
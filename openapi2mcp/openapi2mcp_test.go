@@ -262,8 +262,7 @@ paths:
 	assert.Nil(t, plugin["id"], "plugin should not have id when SkipID=true")
 }
 
-func Test_Openapi2mcp_SimplifySchema(t *testing.T) {
-	// Test that schemas are simplified properly
+func Test_Openapi2mcp_ToolSchema(t *testing.T) {
 	dataIn := []byte(`
 openapi: 3.0.0
 info:
@@ -272,9 +271,9 @@ servers:
   - url: https://api.example.com
 paths:
   /items:
-    get:
-      operationId: list-items
-      summary: List items
+    post:
+      operationId: create-item
+      summary: Create an item
       parameters:
         - name: date
           in: query
@@ -284,36 +283,126 @@ paths:
             pattern: "^\\d{4}-\\d{2}-\\d{2}$"
             minLength: 10
             maxLength: 10
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Item'
+components:
+  schemas:
+    Item:
+      type: object
+      description: An item, with its sub-items
+      required: [name]
+      properties:
+        name:
+          type: string
+          nullable: true
+          default: unnamed
+        kind:
+          type: string
+          enum: [small, large]
+        size:
+          type: integer
+          minimum: 1
+          maximum: 10
+        children:
+          type: array
+          items:
+            $ref: '#/components/schemas/Item'
+        owner:
+          oneOf:
+            - $ref: '#/components/schemas/Person'
+            - type: string
+    Person:
+      type: object
+      properties:
+        name:
+          type: string
 `)
 
-	dataOut, err := Convert(dataIn, O2MOptions{
-		SkipID: true,
-	})
-	if err != nil {
-		t.Errorf("didn't expect error: %v", err)
-		return
+	getTool := func(opts O2MOptions) map[string]interface{} {
+		opts.SkipID = true
+		dataOut, err := Convert(dataIn, opts)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		service := dataOut["services"].([]interface{})[0].(map[string]interface{})
+		route := service["routes"].([]interface{})[0].(map[string]interface{})
+		plugin := route["plugins"].([]interface{})[0].(map[string]interface{})
+		tools := plugin["config"].(map[string]interface{})["tools"].([]interface{})
+		return tools[0].(map[string]interface{})
+	}
+	getBodySchema := func(tool map[string]interface{}) string {
+		content := tool["request_body"].(map[string]interface{})["content"].(map[string]interface{})
+		schema, _ := json.Marshal(content["application/json"].(map[string]interface{})["schema"])
+		return string(schema)
 	}
 
-	// Navigate to the parameter schema
-	services := dataOut["services"].([]interface{})
-	service := services[0].(map[string]interface{})
-	routes := service["routes"].([]interface{})
-	route := routes[0].(map[string]interface{})
-	plugins := route["plugins"].([]interface{})
-	plugin := plugins[0].(map[string]interface{})
-	config := plugin["config"].(map[string]interface{})
-	tools := config["tools"].([]interface{})
-	tool := tools[0].(map[string]interface{})
-	params := tool["parameters"].([]map[string]interface{})
-	param := params[0]
-	schema := param["schema"].(map[string]interface{})
-
-	// Verify schema is simplified - only type should be present
-	assert.Equal(t, "string", schema["type"], "schema should have type")
-	assert.Nil(t, schema["format"], "schema should not have format (simplified)")
-	assert.Nil(t, schema["pattern"], "schema should not have pattern (simplified)")
-	assert.Nil(t, schema["minLength"], "schema should not have minLength (simplified)")
-	assert.Nil(t, schema["maxLength"], "schema should not have maxLength (simplified)")
+	t.Run("keeps all keywords", func(t *testing.T) {
+		tool := getTool(O2MOptions{})
+		if tool == nil {
+			return
+		}
+		param := tool["parameters"].([]map[string]interface{})[0]
+		assert.Equal(t, map[string]interface{}{
+			"type":      "string",
+			"format":    "date",
+			"pattern":   "^\\d{4}-\\d{2}-\\d{2}$",
+			"minLength": float64(10),
+			"maxLength": float64(10),
+		}, param["schema"])
+	})
+
+	t.Run("inlines references, and prunes recursion", func(t *testing.T) {
+		tool := getTool(O2MOptions{})
+		if tool == nil {
+			return
+		}
+		assert.JSONEq(t, `{
+			"type": "object",
+			"description": "An item, with its sub-items",
+			"required": ["name"],
+			"properties": {
+				"name": { "type": "string", "nullable": true, "default": "unnamed" },
+				"kind": { "type": "string", "enum": ["small", "large"] },
+				"size": { "type": "integer", "minimum": 1, "maximum": 10 },
+				"children": {
+					"type": "array",
+					"items": { "type": "object", "description": "An item, with its sub-items" }
+				},
+				"owner": {
+					"oneOf": [
+						{ "type": "object", "properties": { "name": { "type": "string" } } },
+						{ "type": "string" }
+					]
+				}
+			}
+		}`, getBodySchema(tool))
+	})
+
+	t.Run("prunes the deepest schemas beyond the budget", func(t *testing.T) {
+		// the root, and the first 3 properties by name, fit in the budget
+		tool := getTool(O2MOptions{SchemaBudget: 4})
+		if tool == nil {
+			return
+		}
+		assert.JSONEq(t, `{
+			"type": "object",
+			"description": "An item, with its sub-items",
+			"required": ["name"],
+			"properties": {
+				"name": { "type": "string", "nullable": true, "default": "unnamed" },
+				"kind": { "type": "string", "enum": ["small", "large"] },
+				"size": { "type": "integer" },
+				"children": {
+					"type": "array",
+					"items": { "type": "object", "description": "An item, with its sub-items" }
+				},
+				"owner": {}
+			}
+		}`, getBodySchema(tool))
+	})
 }
 
 func Test_Openapi2mcp_SecurityACL(t *testing.T) {
@@ -0,0 +1,171 @@
+package openapi2mcp
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/kong/go-apiops/jsonbasics"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/openapi2kong"
+	openapibase "github.com/pb33f/libopenapi/datamodel/high/base"
+)
+
+// DefaultSchemaBudget is the default maximum number of schema objects in a tool parameter or request body schema
+const DefaultSchemaBudget = 200
+
+// definitionsRef is the prefix of references to the schemas extracted by openapi2kong.ExtractSchema
+const definitionsRef = "#/definitions/"
+
+// the keywords holding sub-schemas, by the way they hold them
+var (
+	singleSchemaKeywords = []string{
+		"not", "if", "then", "else", "contains", "propertyNames", "additionalProperties",
+		"additionalItems", "unevaluatedItems", "unevaluatedProperties", "items",
+	}
+	listSchemaKeywords = []string{"allOf", "anyOf", "oneOf", "prefixItems", "items"}
+	mapSchemaKeywords  = []string{"properties", "patternProperties", "dependentSchemas"}
+)
+
+// schemaNode is a schema object waiting to be inlined, see getToolSchema.
+type schemaNode struct {
+	schema    map[string]interface{}
+	set       func(map[string]interface{}) // replaces the schema in its parent
+	ancestors []string                     // the references inlined on the way to the schema
+}
+
+// pruneSchema returns the placeholder for a pruned schema; only its 'type' and 'description', such
+// that an agent still knows what to pass.
+func pruneSchema(schema map[string]interface{}) map[string]interface{} {
+	pruned := make(map[string]interface{})
+	for _, keyword := range []string{"type", "description"} {
+		if value, found := schema[keyword]; found {
+			pruned[keyword] = value
+		}
+	}
+	return pruned
+}
+
+// getDefinition returns the definition a reference points to, or nil if it is not a reference into the definitions.
+func getDefinition(ref string, definitions map[string]interface{}) map[string]interface{} {
+	if !strings.HasPrefix(ref, definitionsRef) {
+		return nil
+	}
+	name := strings.ReplaceAll(strings.ReplaceAll(strings.TrimPrefix(ref, definitionsRef), "~1", "/"), "~0", "~")
+	definition, _ := definitions[name].(map[string]interface{})
+	return definition
+}
+
+// resolveReference returns the schema with its reference into the definitions inlined, and the references
+// inlined on the way to it. Keywords next to the reference are kept; if they conflict with the referenced
+// schema, then the reference is moved into an 'allOf' instead. If the reference is recursive (already
+// being inlined by an ancestor), then the referenced schema is returned as pruned, and recursive is true.
+func resolveReference(
+	node schemaNode,
+	definitions map[string]interface{},
+) (schema map[string]interface{}, ancestors []string, recursive bool) {
+	schema, ancestors = node.schema, node.ancestors
+	for {
+		ref, _ := schema["$ref"].(string)
+		definition := getDefinition(ref, definitions)
+		if definition == nil {
+			return schema, ancestors, false
+		}
+		if slices.Contains(ancestors, ref) {
+			logbasics.Debug("pruning recursive schema reference", "ref", ref)
+			return pruneSchema(definition), ancestors, true
+		}
+
+		resolved := jsonbasics.DeepCopyObject(definition)
+		for keyword, value := range schema {
+			if keyword == "$ref" {
+				continue
+			}
+			if _, found := resolved[keyword]; found {
+				// conflicting siblings, so combine them instead
+				siblings := make(map[string]interface{}, len(schema))
+				for keyword, value := range schema {
+					siblings[keyword] = value
+				}
+				delete(siblings, "$ref")
+				allOf, _ := siblings["allOf"].([]interface{})
+				siblings["allOf"] = append(slices.Clone(allOf), map[string]interface{}{"$ref": ref})
+				return siblings, ancestors, false
+			}
+			resolved[keyword] = value
+		}
+		schema = resolved
+		ancestors = append(slices.Clone(ancestors), ref)
+	}
+}
+
+// getToolSchema returns the schema for a tool parameter or request body; the complete JSONschema, with
+// the referenced schemas inlined. The schema is walked breadth first, and once the budget (the number
+// of schema objects) is used up, the remaining deeper schemas are pruned. Recursive references are
+// always pruned. A pruned schema only keeps its 'type' and 'description'. Returns nil if there is no schema.
+func getToolSchema(proxy *openapibase.SchemaProxy, oas31 bool, budget int) map[string]interface{} {
+	root := openapi2kong.ExtractSchema(proxy, oas31)
+	if root == nil {
+		return nil
+	}
+	definitions, _ := root["definitions"].(map[string]interface{})
+	delete(root, "definitions")
+
+	var result map[string]interface{}
+	queue := []schemaNode{{
+		schema: root,
+		set:    func(schema map[string]interface{}) { result = schema },
+	}}
+	count := 0
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		schema, ancestors, recursive := resolveReference(node, definitions)
+		if recursive {
+			node.set(schema)
+			continue
+		}
+		if count >= budget {
+			logbasics.Debug("pruning schema, the schema budget is used up", "budget", budget)
+			node.set(pruneSchema(schema))
+			continue
+		}
+		count++
+		node.set(schema)
+
+		// queue the sub-schemas
+		addNode := func(subSchema interface{}, set func(map[string]interface{})) {
+			if object, ok := subSchema.(map[string]interface{}); ok {
+				queue = append(queue, schemaNode{schema: object, set: set, ancestors: ancestors})
+			}
+		}
+		for _, keyword := range singleSchemaKeywords {
+			addNode(schema[keyword], func(subSchema map[string]interface{}) { schema[keyword] = subSchema })
+		}
+		for _, keyword := range listSchemaKeywords {
+			if subSchemas, ok := schema[keyword].([]interface{}); ok {
+				for i, subSchema := range subSchemas {
+					addNode(subSchema, func(subSchema map[string]interface{}) { subSchemas[i] = subSchema })
+				}
+			}
+		}
+		for _, keyword := range mapSchemaKeywords {
+			if subSchemas, ok := schema[keyword].(map[string]interface{}); ok {
+				for _, name := range sortedKeys(subSchemas) {
+					addNode(subSchemas[name], func(subSchema map[string]interface{}) { subSchemas[name] = subSchema })
+				}
+			}
+		}
+	}
+	return result
+}
+
+// sortedKeys returns the keys of the map in sorted order, such that pruning is deterministic.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
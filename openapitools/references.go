@@ -0,0 +1,140 @@
+package openapitools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pb33f/libopenapi/datamodel"
+	"github.com/pb33f/libopenapi/index"
+)
+
+// ReferenceOptions defines how references to other files ($ref: ./schemas/pet.yaml) are resolved.
+// Resolving is always offline; remote references are only resolved from local mirrors.
+type ReferenceOptions struct {
+	// Base directory for resolving relative file references, typically the directory of the spec file.
+	BasePath string
+	// Filesystem for resolving relative file references, rooted at the directory of the spec file.
+	// Takes precedence over BasePath. References outside of the filesystem cannot be resolved.
+	FS fs.FS
+	// Local mirrors of remote references; maps a URL prefix to a local path (resolved like a relative
+	// file reference). Remote references that do not match any prefix are rejected.
+	RemoteMirrors map[string]string
+}
+
+// ConfigureReferences updates the document configuration to resolve references to other files,
+// according to the options. Remote references are never fetched, they are rejected unless
+// a local mirror is provided. The returned function returns the errors for the remote references
+// that could not be resolved, since libopenapi only reports them as missing.
+func ConfigureReferences(config *datamodel.DocumentConfiguration, opts ReferenceOptions) (func() error, error) {
+	config.BasePath = opts.BasePath
+	if opts.FS != nil {
+		// libopenapi only accepts its own filesystem type, which reads all files upfront
+		basePath, _ := filepath.Abs(opts.BasePath)
+		localFS, err := index.NewLocalFSWithConfig(&index.LocalFSConfig{
+			BaseDirectory: basePath,
+			DirFS:         opts.FS,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the files for resolving references: %w", err)
+		}
+		config.LocalFS = localFS
+		config.AllowFileReferences = true
+	}
+
+	// remote references are handled by a handler that only serves the local mirrors
+	handler := &mirrorHandler{
+		opts:   opts,
+		failed: make(map[string]bool),
+	}
+	config.AllowRemoteReferences = true
+	config.RemoteURLHandler = handler.get
+
+	return handler.getErrors, nil
+}
+
+// mirrorHandler is a remote URL handler that serves files from the local mirrors instead of
+// fetching them.
+type mirrorHandler struct {
+	opts   ReferenceOptions
+	lock   sync.Mutex // libopenapi resolves references concurrently
+	failed map[string]bool
+	errors []error
+}
+
+// getErrors returns the errors of all failed requests, or nil.
+func (h *mirrorHandler) getErrors() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return errors.Join(h.errors...)
+}
+
+// get returns the local mirror of the remote url.
+func (h *mirrorHandler) get(url string) (*http.Response, error) {
+	response, err := h.read(url)
+	if err != nil {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		if !h.failed[url] {
+			// the same url might be requested multiple times, only report it once
+			h.failed[url] = true
+			h.errors = append(h.errors, err)
+		}
+	}
+	return response, err
+}
+
+// read reads the local mirror of the remote url.
+func (h *mirrorHandler) read(url string) (*http.Response, error) {
+	// longest prefixes first, so the most specific mirror wins
+	prefixes := make([]string, 0, len(h.opts.RemoteMirrors))
+	for prefix := range h.opts.RemoteMirrors {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+
+	url, _, _ = strings.Cut(url, "#")
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(url, prefix) {
+			continue
+		}
+
+		// the remainder of the url must stay within the mirror, eg. no "../" escaping from it
+		relative := strings.TrimPrefix(url, prefix)
+		if !filepath.IsLocal(filepath.FromSlash(relative)) {
+			return nil, fmt.Errorf("remote reference '%s' resolves outside of its local mirror '%s'",
+				url, h.opts.RemoteMirrors[prefix])
+		}
+		filename := path.Join(h.opts.RemoteMirrors[prefix], relative)
+		var (
+			content []byte
+			err     error
+		)
+		if h.opts.FS != nil {
+			content, err = fs.ReadFile(h.opts.FS, filename)
+		} else {
+			content, err = os.ReadFile(filepath.Join(h.opts.BasePath, filepath.FromSlash(filename)))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read local mirror '%s' of remote reference '%s': %w", filename, url, err)
+		}
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewReader(content)),
+		}, nil
+	}
+	return nil, fmt.Errorf("remote reference '%s' is not allowed, references are only resolved offline; "+
+		"provide a local mirror for it", url)
+}
@@ -0,0 +1,181 @@
+package openapitools
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"go.yaml.in/yaml/v4"
+)
+
+// ServerVariableModeExtension is the extension on a server object, selecting how the enums of the
+// server variables are used.
+const ServerVariableModeExtension = "x-kong-server-variable-mode"
+
+// Server variable modes, the values of the 'x-kong-server-variable-mode' extension
+const (
+	ServerVariableModeDefault = "default" // only the default values of the variables are used
+	ServerVariableModeTargets = "targets" // each combination of enum values becomes an upstream target
+	ServerVariableModeHosts   = "hosts"   // each combination of enum values becomes a route host
+)
+
+const (
+	// the extension on a server variable, mapping its enum values to target weights
+	serverWeightsExtension = "x-kong-weights"
+	defaultTargetWeight    = 100   // the Kong default target weight
+	maxTargetWeight        = 65535 // the Kong maximum target weight
+	noTargetWeight         = -1    // the target weight is not set
+)
+
+// serverURL is a server URL, rendered for a combination of variable values.
+type serverURL struct {
+	url    string // the rendered URL
+	weight int    // the upstream target weight, noTargetWeight if not set
+}
+
+// GetServerVariableMode returns the 'x-kong-server-variable-mode' of the server, defaults to "default".
+func GetServerVariableMode(server *v3.Server) (string, error) {
+	if server == nil || server.Extensions == nil {
+		return ServerVariableModeDefault, nil
+	}
+	node, ok := server.Extensions.Get(ServerVariableModeExtension)
+	if !ok || node == nil {
+		return ServerVariableModeDefault, nil
+	}
+	switch node.Value {
+	case ServerVariableModeDefault, ServerVariableModeTargets, ServerVariableModeHosts:
+		if node.Kind == yaml.ScalarNode {
+			return node.Value, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported '%s' value '%s', expected '%s', '%s', or '%s'", ServerVariableModeExtension,
+		node.Value, ServerVariableModeDefault, ServerVariableModeTargets, ServerVariableModeHosts)
+}
+
+// getServerVariableWeights returns the weights by enum value, from the 'x-kong-weights' extension of
+// the variable. Values without a weight get the default weight.
+func getServerVariableWeights(name string, variable *v3.ServerVariable) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, value := range variable.Enum {
+		weights[value] = defaultTargetWeight
+	}
+	if variable.Extensions == nil {
+		return weights, nil
+	}
+	node, ok := variable.Extensions.Get(serverWeightsExtension)
+	if !ok || node == nil {
+		return weights, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected '%s' of server variable '%s' to be an object", serverWeightsExtension, name)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		value := node.Content[i].Value
+		if _, found := weights[value]; !found {
+			return nil, fmt.Errorf("'%s' of server variable '%s' has a weight for '%s', which is not in its enum",
+				serverWeightsExtension, name, value)
+		}
+		weight, err := strconv.Atoi(node.Content[i+1].Value)
+		if err != nil || weight < 0 || weight > maxTargetWeight {
+			return nil, fmt.Errorf("expected the '%s' of server variable '%s' to be integers from 0 to %d",
+				serverWeightsExtension, name, maxTargetWeight)
+		}
+		weights[value] = weight
+	}
+	return weights, nil
+}
+
+// renderServerURL renders the server URL with only the default values of its variables.
+func renderServerURL(server *v3.Server) string {
+	uriString := server.URL
+	if server.Variables != nil {
+		for pair := server.Variables.First(); pair != nil; pair = pair.Next() {
+			uriString = strings.ReplaceAll(uriString, "{"+pair.Key()+"}", pair.Value().Default)
+		}
+	}
+	return uriString
+}
+
+// expandServer renders the server URL for each combination of the enum values of its variables, starting
+// with the combination of default values. Variables without an enum only use their default. The weight
+// of a combination is the product of the weights of its values, relative to the default weight.
+func expandServer(server *v3.Server) ([]serverURL, error) {
+	if server.Variables == nil || server.Variables.Len() == 0 {
+		return []serverURL{{url: server.URL, weight: defaultTargetWeight}}, nil
+	}
+
+	names := make([]string, 0, server.Variables.Len())
+	values := make([][]any, 0, server.Variables.Len())
+	weights := make([]map[string]int, 0, server.Variables.Len())
+	for pair := server.Variables.First(); pair != nil; pair = pair.Next() {
+		variable := pair.Value()
+		variableWeights, err := getServerVariableWeights(pair.Key(), variable)
+		if err != nil {
+			return nil, err
+		}
+		// the default goes first, such that the first combination is the default one
+		variableValues := []any{variable.Default}
+		for _, value := range variable.Enum {
+			if value != variable.Default {
+				variableValues = append(variableValues, value)
+			}
+		}
+		if _, found := variableWeights[variable.Default]; !found {
+			variableWeights[variable.Default] = defaultTargetWeight
+		}
+		names = append(names, pair.Key())
+		values = append(values, variableValues)
+		weights = append(weights, variableWeights)
+	}
+
+	combinations := CrossProduct(values...)
+	result := make([]serverURL, len(combinations))
+	for i, combination := range combinations {
+		uriString := server.URL
+		weight := 1.0
+		for j, value := range combination {
+			uriString = strings.ReplaceAll(uriString, "{"+names[j]+"}", value.(string))
+			weight = weight * float64(weights[j][value.(string)]) / defaultTargetWeight
+		}
+		result[i] = serverURL{
+			url:    uriString,
+			weight: int(math.Min(math.Round(weight*defaultTargetWeight), maxTargetWeight)),
+		}
+	}
+	return result, nil
+}
+
+// GetServerHosts returns the hostnames of the servers that have 'x-kong-server-variable-mode' set
+// to "hosts", for each combination of the enum values of their variables. Returns an empty
+// list if there are none.
+func GetServerHosts(servers []*v3.Server) ([]string, error) {
+	hosts := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, server := range servers {
+		mode, err := GetServerVariableMode(server)
+		if err != nil {
+			return nil, err
+		}
+		if mode != ServerVariableModeHosts {
+			continue
+		}
+		expanded, err := expandServer(server)
+		if err != nil {
+			return nil, err
+		}
+		for _, rendered := range expanded {
+			uriObject, err := url.ParseRequestURI(rendered.url)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse uri '%s'; %w", rendered.url, err)
+			}
+			if host := uriObject.Hostname(); host != "" && !seen[host] {
+				hosts = append(hosts, host)
+				seen[host] = true
+			}
+		}
+	}
+	return hosts, nil
+}
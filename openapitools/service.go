@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
-	"strings"
 
 	"github.com/google/uuid"
 	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
@@ -22,22 +21,12 @@ const (
 // equivalent server definitions (e.g., "https://api.example.com" vs
 // "https://api.example.com:443") produce the same output.
 func RenderServerURLs(servers []*v3.Server) []string {
-	targets, err := parseServerUris(servers)
+	targets, _, err := parseServerUris(servers)
 	if err != nil {
 		// On parse error, fall back to raw variable-substituted URLs
 		result := make([]string, len(servers))
 		for i, server := range servers {
-			rendered := server.URL
-			if server.Variables != nil {
-				pair := server.Variables.First()
-				for pair != nil {
-					name := pair.Key()
-					svar := pair.Value()
-					rendered = strings.ReplaceAll(rendered, "{"+name+"}", svar.Default)
-					pair = pair.Next()
-				}
-			}
-			result[i] = rendered
+			result[i] = renderServerURL(server)
 		}
 		return result
 	}
@@ -52,44 +41,61 @@ func RenderServerURLs(servers []*v3.Server) []string {
 }
 
 // parseServerUris parses the server uri's after rendering the template variables.
-// result will always have at least 1 entry, but not necessarily a hostname/port/scheme
-func parseServerUris(servers []*v3.Server) ([]*url.URL, error) {
-	var targets []*url.URL
+// result will always have at least 1 entry, but not necessarily a hostname/port/scheme.
+// Servers with 'x-kong-server-variable-mode' set to "targets" result in an entry for each
+// combination of the enum values of their variables. The returned weights are the upstream
+// target weights of the entries, -1 if not set.
+func parseServerUris(servers []*v3.Server) ([]*url.URL, []int, error) {
+	var (
+		targets []*url.URL
+		weights []int
+	)
 
 	if len(servers) == 0 {
 		uriObject, _ := url.ParseRequestURI("/") // path '/' is the default for empty server blocks
-		targets = make([]*url.URL, 1)
-		targets[0] = uriObject
+		targets = []*url.URL{uriObject}
+		weights = []int{noTargetWeight}
 
 	} else {
-		targets = make([]*url.URL, len(servers))
-
-		for i, server := range servers {
-			uriString := server.URL
+		for _, server := range servers {
+			mode, err := GetServerVariableMode(server)
+			if err != nil {
+				return targets, weights, err
+			}
+			rendered := []serverURL{{url: renderServerURL(server), weight: noTargetWeight}}
+			if mode == ServerVariableModeTargets {
+				if rendered, err = expandServer(server); err != nil {
+					return targets, weights, err
+				}
+			}
 
-			pair := server.Variables.First()
-			for pair != nil {
-				name := pair.Key()
-				svar := pair.Value()
-				uriString = strings.ReplaceAll(uriString, "{"+name+"}", svar.Default)
+			var first *url.URL // the default rendering of the server
+			for _, entry := range rendered {
+				uriObject, err := url.ParseRequestURI(entry.url)
+				if err != nil {
+					return targets, weights, fmt.Errorf("failed to parse uri '%s'; %w", entry.url, err)
+				}
 
-				pair = pair.Next()
-			}
+				if uriObject.Path == "" {
+					uriObject.Path = "/" // path '/' is the default
+				}
 
-			uriObject, err := url.ParseRequestURI(uriString)
-			if err != nil {
-				return targets, fmt.Errorf("failed to parse uri '%s'; %w", uriString, err)
-			}
+				if first == nil {
+					first = uriObject
+				} else if uriObject.Scheme != first.Scheme || uriObject.Path != first.Path {
+					// the targets share the service, so only their host and port can differ
+					return targets, weights, fmt.Errorf("server '%s' expands to urls that differ in more than "+
+						"their host and port ('%s' and '%s'), which cannot be upstream targets of a single service",
+						server.URL, rendered[0].url, entry.url)
+				}
 
-			if uriObject.Path == "" {
-				uriObject.Path = "/" // path '/' is the default
+				targets = append(targets, uriObject)
+				weights = append(weights, entry.weight)
 			}
-
-			targets[i] = uriObject
 		}
 	}
 
-	return targets, nil
+	return targets, weights, nil
 }
 
 // setServerDefaults sets the scheme and port if missing and inferable.
@@ -195,20 +201,28 @@ func createKongUpstream(
 	// no target array provided, so take from servers
 
 	// the server urls, will have minimum 1 entry on success
-	targets, err := parseServerUris(servers)
+	targets, weights, err := parseServerUris(servers)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate upstream: %w", err)
 	}
 
 	setServerDefaults(targets, httpsScheme)
 
-	// now add the targets to the upstream
-	upstreamTargets := make([]map[string]interface{}, len(targets))
+	// now add the targets to the upstream, a host:port can only be a target once, the first one is kept
+	upstreamTargets := make([]map[string]interface{}, 0, len(targets))
+	seen := make(map[string]bool, len(targets))
 	for i, target := range targets {
+		if seen[target.Host] {
+			continue
+		}
+		seen[target.Host] = true
 		t := make(map[string]interface{})
 		t["target"] = target.Host
+		if weights[i] != noTargetWeight {
+			t["weight"] = weights[i]
+		}
 		t["tags"] = tags
-		upstreamTargets[i] = t
+		upstreamTargets = append(upstreamTargets, t)
 	}
 	upstream["targets"] = upstreamTargets
 
@@ -249,7 +263,7 @@ func CreateKongService(
 	service["routes"] = make([]interface{}, 0)
 
 	// the server urls, will have minimum 1 entry on success
-	targets, err := parseServerUris(servers)
+	targets, _, err := parseServerUris(servers)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create service: %w", err)
 	}
@@ -5,8 +5,10 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
 	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
 	"github.com/pb33f/libopenapi/orderedmap"
+	"go.yaml.in/yaml/v4"
 )
 
 func Test_parseServerUris(t *testing.T) {
@@ -31,7 +33,7 @@ func Test_parseServerUris(t *testing.T) {
 			Path:   "/bitter/sweet",
 		},
 	}
-	targets, err := parseServerUris(servers)
+	targets, _, err := parseServerUris(servers)
 	if err != nil {
 		t.Errorf("did not expect error: %v", err)
 	}
@@ -64,7 +66,7 @@ func Test_parseServerUris(t *testing.T) {
 			Path:   "/chocolate/cookie",
 		},
 	}
-	targets, err = parseServerUris(servers)
+	targets, _, err = parseServerUris(servers)
 	if err != nil {
 		t.Errorf("did not expect error: %v", err)
 	}
@@ -72,6 +74,85 @@ func Test_parseServerUris(t *testing.T) {
 		t.Errorf("diff: %s", diff)
 	}
 
+	// expands the variable enums in "targets" mode, the defaults first
+	extensions := orderedmap.New[string, *yaml.Node]()
+	extensions.Set(ServerVariableModeExtension, &yaml.Node{Kind: yaml.ScalarNode, Value: ServerVariableModeTargets})
+	weights := orderedmap.New[string, *yaml.Node]()
+	weights.Set("x-kong-weights", &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: "world"},
+		{Kind: yaml.ScalarNode, Value: "50"},
+	}})
+	variables.Set("var1", &v3.ServerVariable{
+		Default:    "hello",
+		Enum:       []string{"hello", "world"},
+		Extensions: weights,
+	})
+	servers = []*v3.Server{
+		{
+			URL:        "http://{var1}-{var2}.com/chocolate/cookie",
+			Variables:  variables,
+			Extensions: extensions,
+		},
+		{
+			URL: "https://konghq.com/bitter/sweet",
+		},
+	}
+
+	expected = []*url.URL{
+		{Scheme: "http", Host: "hello-Welt.com", Path: "/chocolate/cookie"},
+		{Scheme: "http", Host: "hello-hallo.com", Path: "/chocolate/cookie"},
+		{Scheme: "http", Host: "world-Welt.com", Path: "/chocolate/cookie"},
+		{Scheme: "http", Host: "world-hallo.com", Path: "/chocolate/cookie"},
+		{Scheme: "https", Host: "konghq.com", Path: "/bitter/sweet"},
+	}
+	targets, targetWeights, err := parseServerUris(servers)
+	if err != nil {
+		t.Errorf("did not expect error: %v", err)
+	}
+	if diff := cmp.Diff(targets, expected); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if diff := cmp.Diff(targetWeights, []int{100, 100, 50, 50, noTargetWeight}); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	// returns the hosts in "hosts" mode
+	extensions.Set(ServerVariableModeExtension, &yaml.Node{Kind: yaml.ScalarNode, Value: ServerVariableModeHosts})
+	hosts, err := GetServerHosts(servers)
+	if err != nil {
+		t.Errorf("did not expect error: %v", err)
+	}
+	expectedHosts := []string{"hello-Welt.com", "hello-hallo.com", "world-Welt.com", "world-hallo.com"}
+	if diff := cmp.Diff(hosts, expectedHosts); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	// returns error on an unknown mode
+	extensions.Set(ServerVariableModeExtension, &yaml.Node{Kind: yaml.ScalarNode, Value: "all"})
+	_, _, err = parseServerUris(servers)
+	if err == nil {
+		t.Error("expected an error")
+	}
+
+	// returns error in "targets" mode, if the variables change more than the host and port
+	versions := orderedmap.New[string, *v3.ServerVariable]()
+	versions.Set("version", &v3.ServerVariable{
+		Default: "v1",
+		Enum:    []string{"v1", "v2"},
+	})
+	extensions.Set(ServerVariableModeExtension, &yaml.Node{Kind: yaml.ScalarNode, Value: ServerVariableModeTargets})
+	servers = []*v3.Server{
+		{
+			URL:        "https://api.example.com/{version}",
+			Variables:  versions,
+			Extensions: extensions,
+		},
+	}
+	_, _, err = parseServerUris(servers)
+	if err == nil {
+		t.Error("expected an error")
+	}
+
 	// returns error on a bad URL
 
 	servers = []*v3.Server{
@@ -81,7 +162,7 @@ func Test_parseServerUris(t *testing.T) {
 			URL: "not really a url...",
 		},
 	}
-	_, err = parseServerUris(servers)
+	_, _, err = parseServerUris(servers)
 	if err == nil {
 		t.Error("expected an error")
 	}
@@ -93,7 +174,7 @@ func Test_parseServerUris(t *testing.T) {
 			Path: "/",
 		},
 	}
-	targets, err = parseServerUris([]*v3.Server{})
+	targets, _, err = parseServerUris([]*v3.Server{})
 	if err != nil {
 		t.Errorf("did not expect error: %v", err)
 	}
@@ -108,7 +189,7 @@ func Test_parseServerUris(t *testing.T) {
 			Path: "/",
 		},
 	}
-	targets, err = parseServerUris(nil)
+	targets, _, err = parseServerUris(nil)
 	if err != nil {
 		t.Errorf("did not expect error: %v", err)
 	}
@@ -141,3 +222,23 @@ func Test_setServerDefaults(t *testing.T) {
 		}
 	}
 }
+
+func Test_createKongUpstream(t *testing.T) {
+	// a host:port is only added once as a target
+	servers := []*v3.Server{
+		{URL: "https://api.example.com/v1"},
+		{URL: "https://api.example.com:443/v1"},
+		{URL: "http://api.example.com/v1"},
+	}
+	upstream, err := createKongUpstream("pets", servers, nil, nil, uuid.Nil, true)
+	if err != nil {
+		t.Errorf("did not expect error: %v", err)
+	}
+	expected := []map[string]interface{}{
+		{"target": "api.example.com:443", "tags": []string(nil)},
+		{"target": "api.example.com:80", "tags": []string(nil)},
+	}
+	if diff := cmp.Diff(upstream["targets"], expected); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
@@ -0,0 +1,241 @@
+package overlay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/yamlbasics"
+	"go.yaml.in/yaml/v4"
+)
+
+// supportedVersion is the major version of the Overlay specification that is supported
+const supportedVersion = "1."
+
+// Action is a single action of an overlay, updating or removing the targeted nodes.
+type Action struct {
+	Target      string                 // JSONpath selecting the nodes to act on
+	Description string                 // description of the action
+	Update      *yaml.Node             // the value to merge into the targets, nil if none
+	Remove      bool                   // remove the targets, takes precedence over Update
+	selector    yamlbasics.SelectorSet // the compiled Target
+}
+
+// Overlay is an OpenAPI Overlay document, see https://spec.openapis.org/overlay/v1.0.0.html
+type Overlay struct {
+	Version string // the Overlay specification version, eg. "1.0.0"
+	Title   string // the title of the overlay
+	Extends string // URL of the document the overlay is meant for, if any
+	Actions []Action
+}
+
+// overlayDocument is the serialized format of an overlay.
+type overlayDocument struct {
+	Overlay string `yaml:"overlay"`
+	Info    struct {
+		Title   string `yaml:"title"`
+		Version string `yaml:"version"`
+	} `yaml:"info"`
+	Extends string `yaml:"extends"`
+	Actions []struct {
+		Target      string    `yaml:"target"`
+		Description string    `yaml:"description"`
+		Update      yaml.Node `yaml:"update"`
+		Remove      bool      `yaml:"remove"`
+	} `yaml:"actions"`
+}
+
+// Parse parses an overlay document (JSON or YAML). The targets are compiled, such
+// that invalid JSONpath expressions are reported here.
+func Parse(data []byte) (*Overlay, error) {
+	var doc overlayDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse overlay: %w", err)
+	}
+
+	if doc.Overlay == "" {
+		return nil, errors.New("not an overlay document, the 'overlay' version is missing")
+	}
+	if !strings.HasPrefix(doc.Overlay, supportedVersion) {
+		return nil, fmt.Errorf("unsupported overlay version '%s', only 1.x is supported", doc.Overlay)
+	}
+	if len(doc.Actions) == 0 {
+		return nil, errors.New("the overlay has no 'actions'")
+	}
+
+	overlay := &Overlay{
+		Version: doc.Overlay,
+		Title:   doc.Info.Title,
+		Extends: doc.Extends,
+		Actions: make([]Action, len(doc.Actions)),
+	}
+	for i, action := range doc.Actions {
+		if action.Target == "" {
+			return nil, fmt.Errorf("actions[%d] has no 'target'", i)
+		}
+		selector, err := yamlbasics.NewSelectorSet([]string{action.Target})
+		if err != nil {
+			return nil, fmt.Errorf("actions[%d]: %w", i, err)
+		}
+		overlay.Actions[i] = Action{
+			Target:      action.Target,
+			Description: action.Description,
+			Remove:      action.Remove,
+			selector:    selector,
+		}
+		if action.Update.Kind != 0 {
+			update := action.Update
+			overlay.Actions[i].Update = &update
+		}
+	}
+	return overlay, nil
+}
+
+// ParseFile reads and parses an overlay file. Reads from stdin if filename == "-".
+func ParseFile(filename string) (*Overlay, error) {
+	data, err := filebasics.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	overlay, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return overlay, nil
+}
+
+// merge merges the update into the target node. Objects are merged recursively, arrays get
+// the update appended, and any other value is replaced.
+func merge(target *yaml.Node, update *yaml.Node) {
+	switch {
+	case target.Kind == yaml.MappingNode && update.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(update.Content); i += 2 {
+			key := update.Content[i].Value
+			value := update.Content[i+1]
+			existing := yamlbasics.GetFieldValue(target, key)
+			switch {
+			case existing == nil:
+				// new field, copy the key as well to retain its style
+				target.Content = append(target.Content, yamlbasics.CopyNode(update.Content[i]), yamlbasics.CopyNode(value))
+			case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode,
+				existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+				merge(existing, value)
+			default:
+				yamlbasics.SetFieldValue(target, key, yamlbasics.CopyNode(value))
+			}
+		}
+
+	case target.Kind == yaml.SequenceNode && update.Kind == yaml.SequenceNode:
+		for _, value := range update.Content {
+			target.Content = append(target.Content, yamlbasics.CopyNode(value))
+		}
+
+	case target.Kind == yaml.SequenceNode:
+		target.Content = append(target.Content, yamlbasics.CopyNode(update))
+	}
+}
+
+// findParent returns the parent node of the given node, or nil if not found.
+func findParent(root *yaml.Node, node *yaml.Node) *yaml.Node {
+	for _, child := range root.Content {
+		if child == node {
+			return root
+		}
+		if parent := findParent(child, node); parent != nil {
+			return parent
+		}
+	}
+	return nil
+}
+
+// remove removes the node from its parent object or array.
+func remove(parent *yaml.Node, node *yaml.Node) {
+	for i, child := range parent.Content {
+		if child != node {
+			continue
+		}
+		if parent.Kind == yaml.MappingNode {
+			// remove the key as well
+			if i%2 == 1 {
+				yamlbasics.RemoveFieldByIdx(parent, i-1)
+			}
+		} else {
+			parent.Content = append(parent.Content[:i], parent.Content[i+1:]...)
+		}
+		return
+	}
+}
+
+// Apply applies the actions of the overlay, in order, to the document. The document
+// must be an object (the root of the document), and is updated in place.
+func (overlay *Overlay) Apply(document *yaml.Node) error {
+	if err := yamlbasics.CheckType(document, yamlbasics.TypeObject); err != nil {
+		return fmt.Errorf("expected the document to be an object; %w", err)
+	}
+
+	for i, action := range overlay.Actions {
+		targets, err := action.selector.Find(document)
+		if err != nil {
+			return fmt.Errorf("actions[%d]: %w", i, err)
+		}
+		if len(targets) == 0 {
+			logbasics.Info("overlay action target did not match", "action", i, "target", action.Target)
+			continue
+		}
+
+		for _, target := range targets {
+			if action.Remove {
+				if target == document {
+					return fmt.Errorf("actions[%d]: cannot remove the document root", i)
+				}
+				if parent := findParent(document, target); parent != nil {
+					remove(parent, target)
+				}
+				continue
+			}
+			if action.Update == nil {
+				continue
+			}
+			if target.Kind != yaml.MappingNode && target.Kind != yaml.SequenceNode {
+				logbasics.Info("skipping overlay target, only objects and arrays can be updated",
+					"action", i, "target", action.Target)
+				continue
+			}
+			if target.Kind == yaml.MappingNode && action.Update.Kind != yaml.MappingNode {
+				return fmt.Errorf("actions[%d]: the 'update' for an object target must be an object", i)
+			}
+			merge(target, action.Update)
+		}
+	}
+	return nil
+}
+
+// ApplyToContent applies the overlays, in order, to a serialized document (JSON or YAML), and returns
+// the updated document as YAML.
+func ApplyToContent(content []byte, overlays ...*Overlay) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse the document: %w", err)
+	}
+	root := &document
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+
+	for i, overlay := range overlays {
+		if err := overlay.Apply(root); err != nil {
+			return nil, fmt.Errorf("failed to apply overlay %d: %w", i, err)
+		}
+	}
+
+	var result bytes.Buffer
+	encoder := yaml.NewEncoder(&result)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, fmt.Errorf("failed to serialize the document: %w", err)
+	}
+	return result.Bytes(), nil
+}
@@ -0,0 +1,176 @@
+package overlay_test
+
+import (
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/overlay"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const spec = `openapi: 3.0.3
+info:
+  title: Pets
+  version: v1
+tags:
+- name: pets
+paths:
+  /pets:
+    get:
+      operationId: list-pets
+      x-internal: true
+    post:
+      operationId: create-pet
+      x-kong-plugin-cors:
+        config:
+          origins: ['*']
+`
+
+// apply parses the overlay, applies it to the spec, and returns the result as plain data
+func apply(overlayDoc string) map[string]interface{} {
+	o, err := overlay.Parse([]byte(overlayDoc))
+	Expect(err).ToNot(HaveOccurred())
+	result, err := overlay.ApplyToContent([]byte(spec), o)
+	Expect(err).ToNot(HaveOccurred())
+	return filebasics.MustDeserialize(result)
+}
+
+var _ = Describe("Overlay", func() {
+	Describe("Parse", func() {
+		It("parses an overlay", func() {
+			o, err := overlay.Parse([]byte(`
+overlay: 1.0.0
+info:
+  title: Kong settings
+  version: 1.0.0
+extends: https://example.com/pets.yaml
+actions:
+- target: $.paths.*.*
+  description: add a plugin
+  update:
+    x-kong-plugin-key-auth: {}
+- target: $.paths.*.*[?(@['x-internal'] == true)]
+  remove: true
+`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o.Version).To(Equal("1.0.0"))
+			Expect(o.Title).To(Equal("Kong settings"))
+			Expect(o.Extends).To(Equal("https://example.com/pets.yaml"))
+			Expect(o.Actions).To(HaveLen(2))
+			Expect(o.Actions[0].Target).To(Equal("$.paths.*.*"))
+			Expect(o.Actions[0].Description).To(Equal("add a plugin"))
+			Expect(o.Actions[0].Update).ToNot(BeNil())
+			Expect(o.Actions[1].Remove).To(BeTrue())
+			Expect(o.Actions[1].Update).To(BeNil())
+		})
+
+		It("fails on a missing or unsupported version", func() {
+			_, err := overlay.Parse([]byte(`actions: [{target: $}]`))
+			Expect(err).To(MatchError("not an overlay document, the 'overlay' version is missing"))
+			_, err = overlay.Parse([]byte(`{overlay: 2.0.0, actions: [{target: $}]}`))
+			Expect(err).To(MatchError("unsupported overlay version '2.0.0', only 1.x is supported"))
+		})
+
+		It("fails without actions, or targets", func() {
+			_, err := overlay.Parse([]byte(`overlay: 1.0.0`))
+			Expect(err).To(MatchError("the overlay has no 'actions'"))
+			_, err = overlay.Parse([]byte(`{overlay: 1.0.0, actions: [{remove: true}]}`))
+			Expect(err).To(MatchError("actions[0] has no 'target'"))
+		})
+
+		It("fails on an invalid JSONpath target", func() {
+			_, err := overlay.Parse([]byte(`{overlay: 1.0.0, actions: [{target: "$[", remove: true}]}`))
+			Expect(err).To(MatchError(ContainSubstring("actions[0]: selector '$[' is not a valid JSONpath expression")))
+		})
+	})
+
+	Describe("Apply", func() {
+		It("merges updates into objects recursively", func() {
+			result := apply(`
+overlay: 1.0.0
+actions:
+- target: $
+  update:
+    info:
+      x-kong-name: petstore
+    x-kong-plugin-key-auth: {}
+- target: $.paths['/pets'].post
+  update:
+    x-kong-plugin-cors:
+      config:
+        credentials: true
+`)
+			Expect(result["info"]).To(Equal(map[string]interface{}{
+				"title":       "Pets",
+				"version":     "v1",
+				"x-kong-name": "petstore",
+			}))
+			Expect(result["x-kong-plugin-key-auth"]).To(Equal(map[string]interface{}{}))
+			post := result["paths"].(map[string]interface{})["/pets"].(map[string]interface{})["post"]
+			Expect(post.(map[string]interface{})["x-kong-plugin-cors"]).To(Equal(map[string]interface{}{
+				"config": map[string]interface{}{
+					"origins":     []interface{}{"*"},
+					"credentials": true,
+				},
+			}))
+		})
+
+		It("appends updates to arrays", func() {
+			result := apply(`
+overlay: 1.0.0
+actions:
+- target: $.tags
+  update:
+    name: users
+- target: $
+  update:
+    tags:
+    - name: admin
+`)
+			Expect(result["tags"]).To(Equal([]interface{}{
+				map[string]interface{}{"name": "pets"},
+				map[string]interface{}{"name": "users"},
+				map[string]interface{}{"name": "admin"},
+			}))
+		})
+
+		It("removes the targets", func() {
+			result := apply(`
+overlay: 1.0.0
+actions:
+- target: $.paths.*[?(@['x-internal'] == true)]
+  remove: true
+- target: $.tags[0]
+  remove: true
+`)
+			Expect(result["paths"]).To(Equal(map[string]interface{}{
+				"/pets": map[string]interface{}{
+					"post": map[string]interface{}{
+						"operationId": "create-pet",
+						"x-kong-plugin-cors": map[string]interface{}{
+							"config": map[string]interface{}{"origins": []interface{}{"*"}},
+						},
+					},
+				},
+			}))
+			Expect(result["tags"]).To(Equal([]interface{}{}))
+		})
+
+		It("ignores targets that do not match", func() {
+			result := apply(`{overlay: 1.0.0, actions: [{target: $.components, update: {schemas: {}}}]}`)
+			Expect(result).ToNot(HaveKey("components"))
+		})
+
+		It("fails on removing the root, or a non-object update of an object", func() {
+			o, err := overlay.Parse([]byte(`{overlay: 1.0.0, actions: [{target: $, remove: true}]}`))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = overlay.ApplyToContent([]byte(spec), o)
+			Expect(err).To(MatchError("failed to apply overlay 0: actions[0]: cannot remove the document root"))
+
+			o, err = overlay.Parse([]byte(`{overlay: 1.0.0, actions: [{target: $.info, update: [1]}]}`))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = overlay.ApplyToContent([]byte(spec), o)
+			Expect(err).To(MatchError("failed to apply overlay 0: actions[0]: the 'update' for an object target " +
+				"must be an object"))
+		})
+	})
+})
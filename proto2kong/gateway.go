@@ -0,0 +1,223 @@
+package proto2kong
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kong/go-apiops/openapitools"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	// the field number of the 'google.api.http' extension on MethodOptions
+	httpRuleExtension protowire.Number = 72295728
+
+	// the field numbers of 'google.api.HttpRule', and 'google.api.CustomHttpPattern'
+	httpRuleGet                protowire.Number = 2
+	httpRulePut                protowire.Number = 3
+	httpRulePost               protowire.Number = 4
+	httpRuleDelete             protowire.Number = 5
+	httpRulePatch              protowire.Number = 6
+	httpRuleCustom             protowire.Number = 8
+	httpRuleAdditionalBindings protowire.Number = 11
+	customPatternKind          protowire.Number = 1
+	customPatternPath          protowire.Number = 2
+)
+
+// httpBinding is an HTTP method and path template, from a 'google.api.http' annotation.
+type httpBinding struct {
+	method   string // the HTTP method
+	template string // the path template, eg. "/v1/{name=shelves/*}"
+}
+
+// parseHTTPRule parses an encoded 'google.api.HttpRule' into its bindings; the rule itself and its
+// additional bindings.
+func parseHTTPRule(b []byte) ([]httpBinding, error) {
+	bindings := make([]httpBinding, 1)
+	additional := make([]httpBinding, 0)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case httpRuleGet:
+			bindings[0] = httpBinding{method: http.MethodGet, template: string(value)}
+		case httpRulePut:
+			bindings[0] = httpBinding{method: http.MethodPut, template: string(value)}
+		case httpRulePost:
+			bindings[0] = httpBinding{method: http.MethodPost, template: string(value)}
+		case httpRuleDelete:
+			bindings[0] = httpBinding{method: http.MethodDelete, template: string(value)}
+		case httpRulePatch:
+			bindings[0] = httpBinding{method: http.MethodPatch, template: string(value)}
+		case httpRuleCustom:
+			custom, err := parseCustomPattern(value)
+			if err != nil {
+				return nil, err
+			}
+			bindings[0] = custom
+		case httpRuleAdditionalBindings:
+			nested, err := parseHTTPRule(value)
+			if err != nil {
+				return nil, err
+			}
+			additional = append(additional, nested...)
+		}
+	}
+	if bindings[0].template == "" {
+		bindings = bindings[:0] // only additional bindings, or no pattern at all
+	}
+	return append(bindings, additional...), nil
+}
+
+// parseCustomPattern parses an encoded 'google.api.CustomHttpPattern'.
+func parseCustomPattern(b []byte) (httpBinding, error) {
+	var binding httpBinding
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return binding, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return binding, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return binding, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case customPatternKind:
+			binding.method = strings.ToUpper(string(value))
+		case customPatternPath:
+			binding.template = string(value)
+		}
+	}
+	return binding, nil
+}
+
+// getHTTPBindings returns the bindings from the 'google.api.http' annotation of the method. Returns
+// nil if there is no annotation. The options are serialized, such that it works for both known and
+// unknown extensions.
+func getHTTPBindings(options *descriptorpb.MethodOptions) ([]httpBinding, error) {
+	if options == nil {
+		return nil, nil
+	}
+	b, err := proto.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var bindings []httpBinding
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num != httpRuleExtension || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if bindings, err = parseHTTPRule(value); err != nil {
+			return nil, fmt.Errorf("failed to parse the 'google.api.http' annotation: %w", err)
+		}
+	}
+	return bindings, nil
+}
+
+// convertSegments converts the segments of a path template to a regex. A '*' matches a single
+// segment, a '**' matches the remainder of the path.
+func convertSegments(segments string) string {
+	parts := strings.Split(segments, "/")
+	for i, part := range parts {
+		switch part {
+		case "*":
+			parts[i] = "[^#?/]+"
+		case "**":
+			parts[i] = "[^#?]*"
+		default:
+			parts[i] = escapeRegex(part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// escapeRegex escapes the regex characters in a literal part of a path template.
+func escapeRegex(literal string) string {
+	for _, char := range []string{"(", ")", ".", "+", "?", "*", "[", "$"} {
+		literal = strings.ReplaceAll(literal, char, "\\"+char)
+	}
+	return literal
+}
+
+// convertTemplateToRegex converts a 'google.api.http' path template to a Kong regex path. Variables
+// become named captures, of a single segment, or of their own segments (eg. "{name=shelves/*}").
+// Returns the regex path, and whether it has any variables.
+func convertTemplateToRegex(template string) (string, bool, error) {
+	if !strings.HasPrefix(template, "/") {
+		return "", false, fmt.Errorf("path template '%s' must start with '/'", template)
+	}
+
+	var (
+		regex        strings.Builder
+		hasVariables bool
+		remainder    = template
+	)
+	for remainder != "" {
+		start := strings.Index(remainder, "{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(remainder[start:], "}")
+		if end < 0 {
+			return "", false, fmt.Errorf("path template '%s' has an unterminated variable", template)
+		}
+		end += start
+
+		regex.WriteString(convertSegments(remainder[:start]))
+		fieldPath, segments, found := strings.Cut(remainder[start+1:end], "=")
+		capture := "[^#?/]+"
+		if found {
+			capture = convertSegments(segments)
+		}
+		regex.WriteString("(?<" + openapitools.SanitizeRegexCapture(fieldPath, false) + ">" + capture + ")")
+		hasVariables = true
+		remainder = remainder[end+1:]
+	}
+	regex.WriteString(convertSegments(remainder))
+
+	return "~" + regex.String() + "$", hasVariables, nil
+}
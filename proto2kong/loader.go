@@ -0,0 +1,127 @@
+package proto2kong
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/kong/go-apiops/logbasics"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// googleAPIs holds the 'google/api' annotations, used if they are not found on the import paths.
+//
+//go:embed googleapis
+var googleAPIs embed.FS
+
+// openGoogleAPI opens a file from the embedded 'google/api' annotations.
+func openGoogleAPI(filename string) (io.ReadCloser, error) {
+	return googleAPIs.Open(path.Join("googleapis", filename))
+}
+
+// getImportName returns the name of a '.proto' file relative to the import path holding it, and that
+// import path. The first import path that holds the file is used.
+func getImportName(filename string, importPaths []string) (string, string, error) {
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return "", "", err
+	}
+	for _, importPath := range importPaths {
+		absImportPath, err := filepath.Abs(importPath)
+		if err != nil {
+			return "", "", err
+		}
+		name, err := filepath.Rel(absImportPath, absFilename)
+		if err == nil && name != ".." && !strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(name), importPath, nil
+		}
+	}
+	return "", "", fmt.Errorf("file '%s' is not located in any of the import paths", filename)
+}
+
+// compileProtoFiles compiles the '.proto' files, and returns their descriptors. If no import paths are
+// given, then the directory of each file is used as its import path.
+func compileProtoFiles(filenames []string, importPaths []string) ([]*descriptorpb.FileDescriptorProto, error) {
+	names := make([]string, len(filenames))
+	sourcePaths := importPaths
+	for i, filename := range filenames {
+		if len(importPaths) == 0 {
+			names[i] = filepath.Base(filename)
+			sourcePaths = append(sourcePaths, filepath.Dir(filename))
+			continue
+		}
+		name, importPath, err := getImportName(filename, importPaths)
+		if err != nil {
+			return nil, err
+		}
+		logbasics.Debug("resolved proto file", "file", filename, "name", name, "import-path", importPath)
+		names[i] = name
+	}
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(protocompile.CompositeResolver{
+			&protocompile.SourceResolver{ImportPaths: sourcePaths},
+			&protocompile.SourceResolver{Accessor: openGoogleAPI},
+		}),
+	}
+	compiled, err := compiler.Compile(context.Background(), names...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile the proto files: %w", err)
+	}
+
+	files := make([]*descriptorpb.FileDescriptorProto, len(compiled))
+	for i, file := range compiled {
+		files[i] = protodesc.ToFileDescriptorProto(file)
+	}
+	return files, nil
+}
+
+// readDescriptorSet reads a compiled FileDescriptorSet (eg. 'protoc --descriptor_set_out').
+func readDescriptorSet(filename string) ([]*descriptorpb.FileDescriptorProto, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %w", filename, err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse FileDescriptorSet '%s': %w", filename, err)
+	}
+	return set.GetFile(), nil
+}
+
+// LoadFiles reads the '.proto' files, and compiled FileDescriptorSet files (any other extension), from disk
+// and returns the file descriptors. The '.proto' files are compiled together, their imports are resolved from
+// the import paths. If no import paths are given, then the directory of each file is used as its import path.
+// The 'google/api' annotations are provided if they are not found on the import paths.
+func LoadFiles(filenames []string, importPaths []string) ([]*descriptorpb.FileDescriptorProto, error) {
+	protoFiles := make([]string, 0)
+	files := make([]*descriptorpb.FileDescriptorProto, 0)
+	for _, filename := range filenames {
+		if strings.EqualFold(filepath.Ext(filename), ".proto") {
+			protoFiles = append(protoFiles, filename)
+			continue
+		}
+		setFiles, err := readDescriptorSet(filename)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, setFiles...)
+	}
+
+	if len(protoFiles) > 0 {
+		compiled, err := compileProtoFiles(protoFiles, importPaths)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, compiled...)
+	}
+	return files, nil
+}
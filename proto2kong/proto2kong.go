@@ -0,0 +1,275 @@
+package proto2kong
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/openapitools"
+	v3 "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	formatVersionKey   = "_format_version"
+	formatVersionValue = "3.0"
+
+	grpcScheme       = "grpc"
+	grpcsScheme      = "grpcs"
+	defaultServerURL = "grpc://localhost:80"
+
+	// the name of the plugin transcoding REST requests to gRPC
+	grpcGatewayPlugin = "grpc-gateway"
+
+	// default regex priorities to assign to routes, same as openapi2kong
+	regexPriorityWithPathParams = 100
+	regexPriorityPlain          = 200
+)
+
+// P2kOptions defines the options for a proto2kong conversion operation
+type P2kOptions struct {
+	// Array of tags to mark all generated entities with.
+	Tags []string
+	// Base name for the entities (for UUID generation!), defaults to the protobuf package of each service.
+	DocName string
+	// Namespace for UUID generation, defaults to DNS namespace for UUID v5
+	UUIDNamespace uuid.UUID
+	// Skip ID generation (UUIDs)
+	SkipID bool
+	// Import paths for resolving the imports of '.proto' files. If empty, then the directory of each
+	// file is used as its import path.
+	ImportPaths []string
+	// URLs of the gRPC backend, eg. "grpcs://grpc.example.com:443". With multiple URLs each service gets
+	// an upstream with a target per URL. Defaults to "grpc://localhost:80". The port defaults to 80
+	// for "grpc", and 443 for "grpcs".
+	Servers []string
+	// Generate a route with a 'grpc-gateway' plugin for each 'google.api.http' annotation, exposing the
+	// method as a REST endpoint.
+	GrpcGateway bool
+	// Directory holding the '.proto' files on the Kong nodes, used for the 'proto' setting of the
+	// 'grpc-gateway' plugins. If empty, then the names of the files are used as-is.
+	ProtoPath string
+}
+
+// setDefaults sets the defaults for the Proto2Kong operation.
+func (opts *P2kOptions) setDefaults() {
+	var emptyUUID uuid.UUID
+
+	if bytes.Equal(emptyUUID[:], opts.UUIDNamespace[:]) {
+		opts.UUIDNamespace = uuid.NameSpaceDNS
+	}
+	if opts.Tags == nil {
+		opts.Tags = make([]string, 0)
+	}
+	if len(opts.Servers) == 0 {
+		opts.Servers = []string{defaultServerURL}
+	}
+}
+
+// getServers validates the backend URLs to be 'grpc' or 'grpcs', and returns them as OAS server objects,
+// with the default port set if omitted.
+func getServers(urls []string) ([]*v3.Server, error) {
+	servers := make([]*v3.Server, len(urls))
+	for i, serverURL := range urls {
+		uriObject, err := url.Parse(serverURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse server url '%s'; %w", serverURL, err)
+		}
+		if uriObject.Hostname() == "" {
+			return nil, fmt.Errorf("expected server url '%s' to have a hostname", serverURL)
+		}
+		switch uriObject.Scheme {
+		case grpcScheme:
+			if uriObject.Port() == "" {
+				uriObject.Host = uriObject.Host + ":80"
+			}
+		case grpcsScheme:
+			if uriObject.Port() == "" {
+				uriObject.Host = uriObject.Host + ":443"
+			}
+		default:
+			return nil, fmt.Errorf("expected server url '%s' to have scheme '%s' or '%s'",
+				serverURL, grpcScheme, grpcsScheme)
+		}
+		servers[i] = &v3.Server{URL: uriObject.String()}
+	}
+	return servers, nil
+}
+
+// createGrpcRoute creates the route for calling a method over gRPC, on path '/package.Service/Method'.
+func createGrpcRoute(routeName string, grpcPath string, opts P2kOptions) map[string]interface{} {
+	route := map[string]interface{}{
+		"name":           routeName,
+		"protocols":      []string{grpcScheme, grpcsScheme},
+		"paths":          []string{"~" + escapeRegex(grpcPath) + "$"},
+		"regex_priority": regexPriorityPlain,
+		"strip_path":     false,
+		"plugins":        make([]interface{}, 0),
+		"tags":           opts.Tags,
+	}
+	if !opts.SkipID {
+		route["id"] = uuid.NewSHA1(opts.UUIDNamespace, []byte(routeName+".route")).String()
+	}
+	return route
+}
+
+// createGatewayRoute creates a route for calling a method over REST, as annotated by 'google.api.http',
+// with a 'grpc-gateway' plugin transcoding the requests.
+func createGatewayRoute(
+	routeName string,
+	binding httpBinding,
+	protoFile string,
+	opts P2kOptions,
+) (map[string]interface{}, error) {
+	regexPath, hasVariables, err := convertTemplateToRegex(binding.template)
+	if err != nil {
+		return nil, err
+	}
+	regexPriority := regexPriorityPlain
+	if hasVariables {
+		regexPriority = regexPriorityWithPathParams
+	}
+
+	plugin := map[string]interface{}{
+		"name": grpcGatewayPlugin,
+		"config": map[string]interface{}{
+			"proto": protoFile,
+		},
+		"tags": opts.Tags,
+	}
+	route := map[string]interface{}{
+		"name":           routeName,
+		"protocols":      []string{"http", "https"},
+		"methods":        []string{binding.method},
+		"paths":          []string{regexPath},
+		"regex_priority": regexPriority,
+		"strip_path":     false,
+		"plugins":        []interface{}{plugin},
+		"tags":           opts.Tags,
+	}
+	if !opts.SkipID {
+		route["id"] = uuid.NewSHA1(opts.UUIDNamespace, []byte(routeName+".route")).String()
+		plugin["id"] = uuid.NewSHA1(opts.UUIDNamespace, []byte(routeName+".plugin."+grpcGatewayPlugin)).String()
+	}
+	return route, nil
+}
+
+// createGatewayRoutes creates the 'grpc-gateway' routes for the method, one per binding of its
+// 'google.api.http' annotation. Streaming methods cannot be transcoded, and get no routes.
+func createGatewayRoutes(
+	routeName string,
+	method *descriptorpb.MethodDescriptorProto,
+	protoFile string,
+	opts P2kOptions,
+) ([]interface{}, error) {
+	bindings, err := getHTTPBindings(method.GetOptions())
+	if err != nil {
+		return nil, err
+	}
+	routes := make([]interface{}, 0, len(bindings))
+	if len(bindings) == 0 {
+		return routes, nil
+	}
+	if method.GetClientStreaming() || method.GetServerStreaming() {
+		logbasics.Info("skipping grpc-gateway routes for streaming method", "route", routeName)
+		return routes, nil
+	}
+
+	for i, binding := range bindings {
+		gatewayRouteName := routeName + "_gateway"
+		if i > 0 {
+			gatewayRouteName = gatewayRouteName + "_" + strconv.Itoa(i+1)
+		}
+		route, err := createGatewayRoute(gatewayRouteName, binding, protoFile, opts)
+		if err != nil {
+			return nil, fmt.Errorf("method '%s': %w", method.GetName(), err)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// Convert reads the '.proto' files, and compiled FileDescriptorSet files, from disk, and converts the
+// gRPC services in them to Kong services, see ConvertDescriptors.
+func Convert(filenames []string, opts P2kOptions) (map[string]interface{}, error) {
+	files, err := LoadFiles(filenames, opts.ImportPaths)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertDescriptors(files, opts)
+}
+
+// ConvertDescriptors converts the gRPC services in the file descriptors to Kong services. Each method gets
+// a route matching its gRPC path ('/package.Service/Method'). Entities are named like openapi2kong does;
+// the service is named '<package>_<service>' (or '<DocName>_<service>'), and its routes '<service-name>_<method>'.
+func ConvertDescriptors(files []*descriptorpb.FileDescriptorProto, opts P2kOptions) (map[string]interface{}, error) {
+	opts.setDefaults()
+	logbasics.Debug("received Proto2Kong options", "options", opts)
+
+	servers, err := getServers(opts.Servers)
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]interface{}, 0)
+	upstreams := make([]interface{}, 0)
+	for _, file := range files {
+		baseName := opts.DocName
+		if baseName == "" {
+			baseName = file.GetPackage()
+		}
+		protoFile := path.Join(opts.ProtoPath, file.GetName())
+
+		for _, serviceDescriptor := range file.GetService() {
+			serviceName := openapitools.Slugify(false, baseName, serviceDescriptor.GetName())
+			logbasics.Info("creating service", "name", serviceName, "file", file.GetName())
+
+			service, upstream, err := openapitools.CreateKongService(serviceName, servers, nil, nil,
+				opts.Tags, opts.UUIDNamespace, opts.SkipID)
+			if err != nil {
+				return nil, err
+			}
+			delete(service, "path") // gRPC services cannot have a path
+			if upstream != nil {
+				upstreams = append(upstreams, upstream)
+			}
+
+			grpcService := serviceDescriptor.GetName()
+			if file.GetPackage() != "" {
+				grpcService = file.GetPackage() + "." + grpcService
+			}
+
+			routes := service["routes"].([]interface{})
+			for _, method := range serviceDescriptor.GetMethod() {
+				routeName := serviceName + "_" + openapitools.Slugify(false, method.GetName())
+				logbasics.Debug("creating route", "name", routeName)
+				routes = append(routes, createGrpcRoute(routeName, "/"+grpcService+"/"+method.GetName(), opts))
+
+				if opts.GrpcGateway {
+					gatewayRoutes, err := createGatewayRoutes(routeName, method, protoFile, opts)
+					if err != nil {
+						return nil, fmt.Errorf("service '%s': %w", grpcService, err)
+					}
+					routes = append(routes, gatewayRoutes...)
+				}
+			}
+			service["routes"] = routes
+			services = append(services, service)
+		}
+	}
+	if len(services) == 0 {
+		return nil, errors.New("no gRPC services found")
+	}
+
+	result := map[string]interface{}{
+		formatVersionKey: formatVersionValue,
+		"services":       services,
+		"upstreams":      upstreams,
+	}
+	return result, nil
+}
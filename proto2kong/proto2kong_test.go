@@ -0,0 +1,153 @@
+package proto2kong
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const fixturePath = "./proto_testfiles/"
+
+func Test_Proto2kong(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       []string
+		importPaths []string
+		opts        P2kOptions
+	}{
+		{
+			name:  "01-basic",
+			files: []string{"01-basic.proto"},
+			opts: P2kOptions{
+				Servers: []string{"grpcs://greeter.example.com"},
+			},
+		},
+		{
+			name:        "02-grpc-gateway",
+			files:       []string{"02-imports/library.proto"},
+			importPaths: []string{"02-imports"},
+			opts: P2kOptions{
+				DocName:     "library",
+				Servers:     []string{"grpc://library-1.internal:9000", "grpc://library-2.internal:9000"},
+				GrpcGateway: true,
+				ProtoPath:   "/usr/local/kong/protos",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fileNameExpected := tc.name + ".expected.json"
+			fileNameOut := tc.name + ".generated.json"
+
+			files := make([]string, len(tc.files))
+			for i, file := range tc.files {
+				files[i] = filepath.Join(fixturePath, file)
+			}
+			for _, importPath := range tc.importPaths {
+				tc.opts.ImportPaths = append(tc.opts.ImportPaths, filepath.Join(fixturePath, importPath))
+			}
+			tc.opts.Tags = []string{"proto_import", "protofile_" + tc.name}
+
+			dataOut, err := Convert(files, tc.opts)
+			require.NoError(t, err)
+
+			JSONOut, _ := json.MarshalIndent(dataOut, "", "  ")
+			os.WriteFile(fixturePath+fileNameOut, JSONOut, 0o600)
+			JSONExpected, err := os.ReadFile(fixturePath + fileNameExpected)
+			require.NoError(t, err)
+
+			assert.JSONEq(t, string(JSONExpected), string(JSONOut),
+				"'%s': the JSON blobs should be equal", fixturePath+tc.name)
+		})
+	}
+}
+
+func Test_Proto2kong_DescriptorSet(t *testing.T) {
+	// compile the fixture, and store it as a FileDescriptorSet, like 'protoc --descriptor_set_out' would
+	files, err := LoadFiles([]string{filepath.Join(fixturePath, "02-imports/library.proto")},
+		[]string{filepath.Join(fixturePath, "02-imports")})
+	require.NoError(t, err)
+	content, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: files})
+	require.NoError(t, err)
+	setFile := filepath.Join(t.TempDir(), "library.protoset")
+	require.NoError(t, os.WriteFile(setFile, content, 0o600))
+
+	opts := P2kOptions{
+		DocName:     "library",
+		Servers:     []string{"grpc://library-1.internal:9000", "grpc://library-2.internal:9000"},
+		GrpcGateway: true,
+		ProtoPath:   "/usr/local/kong/protos",
+		Tags:        []string{"proto_import", "protofile_02-grpc-gateway"},
+	}
+	dataOut, err := Convert([]string{setFile}, opts)
+	require.NoError(t, err)
+
+	JSONOut, _ := json.Marshal(dataOut)
+	JSONExpected, err := os.ReadFile(fixturePath + "02-grpc-gateway.expected.json")
+	require.NoError(t, err)
+	assert.JSONEq(t, string(JSONExpected), string(JSONOut))
+}
+
+func Test_Proto2kong_Errors(t *testing.T) {
+	basic := filepath.Join(fixturePath, "01-basic.proto")
+
+	t.Run("fails on a non-grpc server url", func(t *testing.T) {
+		_, err := Convert([]string{basic}, P2kOptions{Servers: []string{"https://greeter.example.com"}})
+		assert.EqualError(t, err, "expected server url 'https://greeter.example.com' to have scheme 'grpc' or 'grpcs'")
+	})
+
+	t.Run("fails on a file outside the import paths", func(t *testing.T) {
+		_, err := Convert([]string{basic}, P2kOptions{ImportPaths: []string{filepath.Join(fixturePath, "02-imports")}})
+		assert.EqualError(t, err, "file '"+basic+"' is not located in any of the import paths")
+	})
+
+	t.Run("fails on an unresolved import", func(t *testing.T) {
+		_, err := Convert([]string{filepath.Join(fixturePath, "02-imports/library.proto")}, P2kOptions{
+			ImportPaths: []string{fixturePath},
+		})
+		assert.ErrorContains(t, err, "failed to compile the proto files")
+	})
+
+	t.Run("fails on an invalid descriptor set", func(t *testing.T) {
+		_, err := Convert([]string{basic + ".missing"}, P2kOptions{})
+		assert.ErrorContains(t, err, "failed to read '"+basic+".missing'")
+	})
+
+	t.Run("fails without services", func(t *testing.T) {
+		_, err := ConvertDescriptors([]*descriptorpb.FileDescriptorProto{}, P2kOptions{})
+		assert.EqualError(t, err, "no gRPC services found")
+	})
+}
+
+func Test_convertTemplateToRegex(t *testing.T) {
+	tests := []struct {
+		template     string
+		regex        string
+		hasVariables bool
+	}{
+		{"/v1/shelves", "~/v1/shelves$", false},
+		{"/v1/shelves:watch", "~/v1/shelves:watch$", false},
+		{"/v1/{name}", "~/v1/(?<name>[^#?/]+)$", true},
+		{"/v1/{name=shelves/*}", "~/v1/(?<name>shelves/[^#?/]+)$", true},
+		{"/v1/{book.id}/{path=**}", "~/v1/(?<book_id>[^#?/]+)/(?<path>[^#?]*)$", true},
+	}
+	for _, tc := range tests {
+		regex, hasVariables, err := convertTemplateToRegex(tc.template)
+		if assert.NoError(t, err, tc.template) {
+			assert.Equal(t, tc.regex, regex, tc.template)
+			assert.Equal(t, tc.hasVariables, hasVariables, tc.template)
+		}
+	}
+
+	_, _, err := convertTemplateToRegex("v1/shelves")
+	assert.EqualError(t, err, "path template 'v1/shelves' must start with '/'")
+	_, _, err = convertTemplateToRegex("/v1/{name")
+	assert.EqualError(t, err, "path template '/v1/{name' has an unterminated variable")
+}
@@ -0,0 +1,329 @@
+package routeconflicts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// charClass is a set of characters, eg. "[^#?/]". A negated class with no characters
+// matches any character.
+type charClass struct {
+	negated bool
+	chars   string
+}
+
+var anyChar = charClass{negated: true}
+
+// matches returns true if the class contains the character.
+func (c charClass) matches(char rune) bool {
+	return strings.ContainsRune(c.chars, char) != c.negated
+}
+
+// intersects returns true if there is a character that is in both classes.
+func (c charClass) intersects(other charClass) bool {
+	switch {
+	case c.negated && other.negated:
+		return true // the classes are small, so there is always a character left
+	case c.negated:
+		return strings.ContainsFunc(other.chars, c.matches)
+	default:
+		return strings.ContainsFunc(c.chars, other.matches)
+	}
+}
+
+// contains returns true if all characters of the other class are in this class.
+func (c charClass) contains(other charClass) bool {
+	switch {
+	case c.negated && other.negated:
+		return !strings.ContainsFunc(c.chars, other.matches)
+	case c.negated:
+		return !strings.ContainsFunc(other.chars, func(r rune) bool { return !c.matches(r) })
+	case other.negated:
+		return false
+	default:
+		return !strings.ContainsFunc(other.chars, func(r rune) bool { return !c.matches(r) })
+	}
+}
+
+// pathToken is a single element of a path pattern, matching a character from its class. If
+// repeat is set, then it matches zero or more characters.
+type pathToken struct {
+	class  charClass
+	repeat bool
+}
+
+// pathPattern is a Kong route path, parsed into a sequence of tokens. Plain paths are prefixes, and
+// regex paths are anchored at the start, so a path matches if its pattern matches the full
+// request path. Only the regex constructs typically used in paths are supported.
+type pathPattern struct {
+	source   string      // the path as defined on the route
+	template string      // the path as an OAS path template, eg. "/pets/{id}"
+	tokens   []pathToken // the tokens to match
+	regex    bool        // the path is a regex
+}
+
+// parsePath parses a Kong route path into a pattern. Returns an error if the path uses
+// regex constructs that are not supported.
+func parsePath(path string) (*pathPattern, error) {
+	pattern := &pathPattern{source: path}
+	if !strings.HasPrefix(path, "~") {
+		// plain path, matches as a prefix
+		pattern.template = path
+		for _, char := range path {
+			pattern.tokens = append(pattern.tokens, pathToken{class: charClass{chars: string(char)}})
+		}
+		pattern.tokens = append(pattern.tokens, pathToken{class: anyChar, repeat: true})
+		return pattern, nil
+	}
+
+	pattern.regex = true
+	p := &regexParser{input: []rune(strings.TrimPrefix(strings.TrimPrefix(path, "~"), "^"))}
+	tokens, template, err := p.parseSequence(0)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported regex path '%s': %w", path, err)
+	}
+	if p.pos != len(p.input) {
+		return nil, fmt.Errorf("unsupported regex path '%s': unexpected '%c'", path, p.input[p.pos])
+	}
+	if !p.anchored {
+		tokens = append(tokens, pathToken{class: anyChar, repeat: true})
+	}
+	pattern.tokens = tokens
+	pattern.template = template
+	return pattern, nil
+}
+
+// regexParser parses the subset of regex syntax used in route paths: literals, escapes, '.',
+// character classes, quantifiers '*' and '+', and (named) groups without alternatives.
+type regexParser struct {
+	input    []rune
+	pos      int
+	anchored bool // the regex ends with '$'
+}
+
+// parseSequence parses tokens until the end of the input, or the end of the group at the given depth.
+func (p *regexParser) parseSequence(depth int) ([]pathToken, string, error) {
+	tokens := make([]pathToken, 0)
+	template := ""
+	for p.pos < len(p.input) {
+		char := p.input[p.pos]
+		switch char {
+		case ')':
+			if depth == 0 {
+				return nil, "", fmt.Errorf("unbalanced ')'")
+			}
+			return tokens, template, nil
+		case '$':
+			if p.pos != len(p.input)-1 || depth != 0 {
+				return nil, "", fmt.Errorf("'$' is only supported at the end")
+			}
+			p.pos++
+			p.anchored = true
+			continue
+		case '(':
+			groupTokens, groupTemplate, err := p.parseGroup(depth)
+			if err != nil {
+				return nil, "", err
+			}
+			tokens = append(tokens, groupTokens...)
+			template += groupTemplate
+			continue
+		case '|', '?', '{', '*', '+', '^':
+			return nil, "", fmt.Errorf("'%c' is not supported", char)
+		}
+
+		token, literal, err := p.parseAtom()
+		if err != nil {
+			return nil, "", err
+		}
+		template += literal
+		tokens = append(tokens, p.parseQuantifier(token)...)
+	}
+	if depth != 0 {
+		return nil, "", fmt.Errorf("unbalanced '('")
+	}
+	return tokens, template, nil
+}
+
+// parseGroup parses a group, the current position is at the '('. Named groups are returned as
+// a template parameter, eg. "{id}".
+func (p *regexParser) parseGroup(depth int) ([]pathToken, string, error) {
+	p.pos++ // skip '('
+	name := ""
+	rest := string(p.input[p.pos:])
+	switch {
+	case strings.HasPrefix(rest, "?<") || strings.HasPrefix(rest, "?P<"):
+		end := strings.IndexRune(rest, '>')
+		if end == -1 {
+			return nil, "", fmt.Errorf("unterminated group name")
+		}
+		name = rest[strings.IndexRune(rest, '<')+1 : end]
+		p.pos += len([]rune(rest[:end+1]))
+	case strings.HasPrefix(rest, "?:"):
+		p.pos += 2
+	case strings.HasPrefix(rest, "?"):
+		return nil, "", fmt.Errorf("group type '(%s' is not supported", rest[:2])
+	}
+
+	tokens, template, err := p.parseSequence(depth + 1)
+	if err != nil {
+		return nil, "", err
+	}
+	if p.pos >= len(p.input) {
+		return nil, "", fmt.Errorf("unbalanced '('")
+	}
+	p.pos++ // skip ')'
+	if p.pos < len(p.input) && strings.ContainsRune("*+?{", p.input[p.pos]) {
+		return nil, "", fmt.Errorf("quantifiers on groups are not supported")
+	}
+	if name != "" {
+		template = "{" + name + "}"
+	}
+	return tokens, template, nil
+}
+
+// parseAtom parses a single character matcher: a literal, an escaped character, '.', or a
+// character class. Returns the literal text for the template.
+func (p *regexParser) parseAtom() (pathToken, string, error) {
+	char := p.input[p.pos]
+	p.pos++
+	switch char {
+	case '.':
+		return pathToken{class: anyChar}, "*", nil
+	case '\\':
+		if p.pos >= len(p.input) {
+			return pathToken{}, "", fmt.Errorf("trailing '\\'")
+		}
+		escaped := p.input[p.pos]
+		p.pos++
+		if strings.ContainsRune("dDwWsSbB", escaped) {
+			return pathToken{}, "", fmt.Errorf("'\\%c' is not supported", escaped)
+		}
+		return pathToken{class: charClass{chars: string(escaped)}}, string(escaped), nil
+	case '[':
+		class := charClass{}
+		if p.pos < len(p.input) && p.input[p.pos] == '^' {
+			class.negated = true
+			p.pos++
+		}
+		for p.pos < len(p.input) && p.input[p.pos] != ']' {
+			member := p.input[p.pos]
+			if member == '\\' && p.pos+1 < len(p.input) {
+				p.pos++
+				member = p.input[p.pos]
+			} else if member == '-' && class.chars != "" && p.pos+1 < len(p.input) && p.input[p.pos+1] != ']' {
+				return pathToken{}, "", fmt.Errorf("character ranges are not supported")
+			}
+			class.chars += string(member)
+			p.pos++
+		}
+		if p.pos >= len(p.input) {
+			return pathToken{}, "", fmt.Errorf("unterminated character class")
+		}
+		p.pos++ // skip ']'
+		return pathToken{class: class}, "*", nil
+	}
+	return pathToken{class: charClass{chars: string(char)}}, string(char), nil
+}
+
+// parseQuantifier applies a quantifier following the token, if any.
+func (p *regexParser) parseQuantifier(token pathToken) []pathToken {
+	if p.pos < len(p.input) {
+		switch p.input[p.pos] {
+		case '*':
+			p.pos++
+			return []pathToken{{class: token.class, repeat: true}}
+		case '+':
+			p.pos++
+			return []pathToken{token, {class: token.class, repeat: true}}
+		}
+	}
+	return []pathToken{token}
+}
+
+// tokenPair is a position in 2 patterns, used for walking them in parallel.
+type tokenPair struct{ a, b int }
+
+// overlaps returns true if there is a request path that matches both patterns.
+func (a *pathPattern) overlaps(b *pathPattern) bool {
+	// walk both patterns in parallel, consuming a character that matches both each step
+	visited := make(map[tokenPair]bool)
+	queue := []tokenPair{{0, 0}}
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+		if visited[pos] {
+			continue
+		}
+		visited[pos] = true
+
+		if pos.a == len(a.tokens) && pos.b == len(b.tokens) {
+			return true
+		}
+		// repeating tokens can match nothing
+		if pos.a < len(a.tokens) && a.tokens[pos.a].repeat {
+			queue = append(queue, tokenPair{pos.a + 1, pos.b})
+		}
+		if pos.b < len(b.tokens) && b.tokens[pos.b].repeat {
+			queue = append(queue, tokenPair{pos.a, pos.b + 1})
+		}
+		if pos.a == len(a.tokens) || pos.b == len(b.tokens) {
+			continue
+		}
+
+		tokenA, tokenB := a.tokens[pos.a], b.tokens[pos.b]
+		if tokenA.class.intersects(tokenB.class) {
+			next := pos
+			if !tokenA.repeat {
+				next.a++
+			}
+			if !tokenB.repeat {
+				next.b++
+			}
+			queue = append(queue, next)
+		}
+	}
+	return false
+}
+
+// covers returns true if every request path that matches b also matches a. The check is
+// conservative; it can return false for complex patterns that are in fact covered.
+func (a *pathPattern) covers(b *pathPattern) bool {
+	visited := make(map[tokenPair]bool)
+	queue := []tokenPair{{0, 0}}
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+		if visited[pos] {
+			continue
+		}
+		visited[pos] = true
+
+		if pos.a == len(a.tokens) && pos.b == len(b.tokens) {
+			return true
+		}
+		if pos.a < len(a.tokens) && a.tokens[pos.a].repeat {
+			queue = append(queue, tokenPair{pos.a + 1, pos.b})
+		}
+		if pos.a == len(a.tokens) || pos.b == len(b.tokens) {
+			continue
+		}
+
+		tokenA, tokenB := a.tokens[pos.a], b.tokens[pos.b]
+		if !tokenA.class.contains(tokenB.class) {
+			continue
+		}
+		switch {
+		case tokenB.repeat && tokenA.repeat:
+			// any repetition of b is absorbed by a
+			queue = append(queue, tokenPair{pos.a, pos.b + 1})
+		case tokenB.repeat:
+			// a single token cannot absorb a repetition
+		case tokenA.repeat:
+			queue = append(queue, tokenPair{pos.a, pos.b + 1})
+		default:
+			queue = append(queue, tokenPair{pos.a + 1, pos.b + 1})
+		}
+	}
+	return false
+}
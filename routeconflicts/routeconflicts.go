@@ -0,0 +1,448 @@
+package routeconflicts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-apiops/jsonbasics"
+	"github.com/kong/go-apiops/logbasics"
+)
+
+// ConflictType is the type of conflict between 2 routes.
+type ConflictType string
+
+const (
+	// ConflictShadowed means the route can never be matched, since every request it matches
+	// is matched by the other route first.
+	ConflictShadowed ConflictType = "shadowed"
+	// ConflictAmbiguous means both routes match the same requests with equal precedence, so
+	// which one is matched is undefined.
+	ConflictAmbiguous ConflictType = "ambiguous"
+)
+
+// Origin describes where a route was generated from.
+type Origin struct {
+	Method      string // the OAS method, eg. "GET"
+	Path        string // the OAS path, eg. "/pets/{id}"
+	OperationID string // the OAS operationId, if any
+}
+
+// String returns a description of the origin, eg. "GET /pets/{id}, operation 'get-pet'".
+func (o Origin) String() string {
+	result := strings.TrimSpace(o.Method + " " + o.Path)
+	if o.OperationID != "" {
+		result += ", operation '" + o.OperationID + "'"
+	}
+	return result
+}
+
+// Route is a route as analyzed for conflicts.
+type Route struct {
+	Name          string              // the route name, or id if it has no name
+	Service       string              // the name (or id) of the service the route belongs to
+	Methods       []string            // uppercased, empty matches any method
+	Hosts         []string            // lowercased, empty matches any host
+	Headers       map[string][]string // lowercased header names and values
+	paths         []*pathPattern      // the parsed paths, never empty
+	RegexPriority int64               // the regex_priority of the route
+	Origin        Origin              // where the route was generated from
+}
+
+// Conflict is a conflict between 2 routes.
+type Conflict struct {
+	Type  ConflictType
+	Route *Route // the route that is shadowed, or the first of the ambiguous routes
+	Other *Route // the route that shadows it, or the second of the ambiguous routes
+}
+
+// String returns a description of the conflict.
+func (c Conflict) String() string {
+	describe := func(r *Route) string {
+		return fmt.Sprintf("route '%s' of service '%s' (%s)", r.Name, r.Service, r.Origin)
+	}
+	if c.Type == ConflictShadowed {
+		return describe(c.Route) + " is shadowed by " + describe(c.Other)
+	}
+	return describe(c.Route) + " is ambiguous with " + describe(c.Other)
+}
+
+// getRoutes returns the routes from the deck file, both top-level and nested under services.
+// Routes that cannot be analyzed are skipped.
+func getRoutes(deckfile map[string]interface{}, origins map[string]Origin) ([]*Route, error) {
+	result := make([]*Route, 0)
+
+	add := func(route map[string]interface{}, service string) error {
+		r, err := newRoute(route, service, origins)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			result = append(result, r)
+		}
+		return nil
+	}
+
+	services, err := jsonbasics.GetObjectArrayField(deckfile, "services")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse 'services': %w", err)
+	}
+	for _, service := range services {
+		serviceName := getNameOrID(service)
+		routes, err := jsonbasics.GetObjectArrayField(service, "routes")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse 'routes' of service '%s': %w", serviceName, err)
+		}
+		for _, route := range routes {
+			if err := add(route, serviceName); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	routes, err := jsonbasics.GetObjectArrayField(deckfile, "routes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse 'routes': %w", err)
+	}
+	for _, route := range routes {
+		serviceName := ""
+		switch service := route["service"].(type) {
+		case string:
+			serviceName = service
+		case map[string]interface{}:
+			serviceName = getNameOrID(service)
+		}
+		if err := add(route, serviceName); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// getNameOrID returns the name of the entity, or its id if it has no name.
+func getNameOrID(entity map[string]interface{}) string {
+	if name, err := jsonbasics.GetStringField(entity, "name"); err == nil {
+		return name
+	}
+	id, _ := jsonbasics.GetStringField(entity, "id")
+	return id
+}
+
+// newRoute parses a route entity. Returns nil if the route cannot be analyzed.
+func newRoute(route map[string]interface{}, service string, origins map[string]Origin) (*Route, error) {
+	r := &Route{
+		Name:    getNameOrID(route),
+		Service: service,
+		Headers: make(map[string][]string),
+	}
+
+	if route["expression"] != nil {
+		logbasics.Info("skipping expression route, only traditional routes are analyzed", "route", r.Name)
+		return nil, nil
+	}
+
+	var err error
+	if r.Methods, err = jsonbasics.GetStringArrayField(route, "methods"); err != nil {
+		return nil, fmt.Errorf("failed to parse 'methods' of route '%s': %w", r.Name, err)
+	}
+	for i, method := range r.Methods {
+		r.Methods[i] = strings.ToUpper(method)
+	}
+
+	if r.Hosts, err = jsonbasics.GetStringArrayField(route, "hosts"); err != nil {
+		return nil, fmt.Errorf("failed to parse 'hosts' of route '%s': %w", r.Name, err)
+	}
+	for i, host := range r.Hosts {
+		r.Hosts[i] = strings.ToLower(host)
+	}
+
+	if route["headers"] != nil {
+		headers, err := jsonbasics.ToObject(route["headers"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse 'headers' of route '%s': %w", r.Name, err)
+		}
+		for name := range headers {
+			values, err := jsonbasics.GetStringArrayField(headers, name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse header '%s' of route '%s': %w", name, r.Name, err)
+			}
+			for i, value := range values {
+				values[i] = strings.ToLower(value)
+			}
+			r.Headers[strings.ToLower(name)] = values
+		}
+	}
+
+	if route["regex_priority"] != nil {
+		if r.RegexPriority, err = jsonbasics.GetInt64Field(route, "regex_priority"); err != nil {
+			return nil, fmt.Errorf("failed to parse 'regex_priority' of route '%s': %w", r.Name, err)
+		}
+	}
+
+	paths, err := jsonbasics.GetStringArrayField(route, "paths")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse 'paths' of route '%s': %w", r.Name, err)
+	}
+	if len(paths) == 0 {
+		paths = []string{"/"} // no paths matches any path
+	}
+	for _, path := range paths {
+		pattern, err := parsePath(path)
+		if err != nil {
+			logbasics.Info("skipping route path: "+err.Error(), "route", r.Name)
+			continue
+		}
+		r.paths = append(r.paths, pattern)
+	}
+	if len(r.paths) == 0 {
+		return nil, nil
+	}
+
+	if origin, found := origins[r.Name]; found {
+		r.Origin = origin
+	} else {
+		// derive the origin from the route itself
+		templates := make([]string, len(r.paths))
+		for i, path := range r.paths {
+			templates[i] = path.template
+		}
+		r.Origin = Origin{
+			Method: strings.Join(r.Methods, "|"),
+			Path:   strings.Join(templates, "|"),
+		}
+	}
+
+	return r, nil
+}
+
+// criteria returns the number of matching criteria the route uses.
+func (r *Route) criteria() int {
+	count := 1 // paths
+	for _, used := range []bool{len(r.Methods) > 0, len(r.Hosts) > 0, len(r.Headers) > 0} {
+		if used {
+			count++
+		}
+	}
+	return count
+}
+
+// comparePrecedence compares the precedence of 2 route paths, following the Kong router
+// rules: the route with more criteria, then more headers, then plain over regex paths, then higher
+// regex_priority, and then longer paths goes first. Returns >0 if a goes first, <0 if b goes first,
+// and 0 if undefined.
+func comparePrecedence(a *Route, pathA *pathPattern, b *Route, pathB *pathPattern) int {
+	if d := a.criteria() - b.criteria(); d != 0 {
+		return d
+	}
+	if d := len(a.Headers) - len(b.Headers); d != 0 {
+		return d
+	}
+	if pathA.regex != pathB.regex {
+		if pathB.regex {
+			return 1
+		}
+		return -1
+	}
+	if pathA.regex && a.RegexPriority != b.RegexPriority {
+		if a.RegexPriority > b.RegexPriority {
+			return 1
+		}
+		return -1
+	}
+	return len(pathA.source) - len(pathB.source)
+}
+
+// listsOverlap returns true if there is a value (eg. a method) in both lists. An empty list matches any value.
+func listsOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, method := range a {
+		if contains(b, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// listsCover returns true if all values matched by b are matched by a. An empty list matches any value.
+func listsCover(a, b []string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	if len(b) == 0 {
+		return false
+	}
+	for _, method := range b {
+		if !contains(a, method) {
+			return false
+		}
+	}
+	return true
+}
+
+// hostMatches returns true if the host pattern (with a leading or trailing wildcard) matches the host.
+func hostMatches(pattern, host string) bool {
+	switch {
+	case pattern == host:
+		return true
+	case strings.HasPrefix(pattern, "*"):
+		return strings.HasSuffix(host, strings.TrimPrefix(pattern, "*"))
+	case strings.HasSuffix(pattern, "*"):
+		return strings.HasPrefix(host, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+// hostsOverlap returns true if there is a host that matches both lists.
+func hostsOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, hostA := range a {
+		for _, hostB := range b {
+			if hostMatches(hostA, hostB) || hostMatches(hostB, hostA) {
+				return true
+			}
+			if strings.Contains(hostA, "*") && strings.Contains(hostB, "*") && hostA[0] != hostB[0] {
+				return true // a leading and a trailing wildcard; "*.example.com" and "api.*"
+			}
+		}
+	}
+	return false
+}
+
+// hostsCover returns true if all hosts matched by b are matched by a.
+func hostsCover(a, b []string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	if len(b) == 0 {
+		return false
+	}
+	for _, hostB := range b {
+		covered := false
+		for _, hostA := range a {
+			if hostMatches(hostA, hostB) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// headersOverlap returns true if a request can match the headers of both routes.
+func headersOverlap(a, b map[string][]string) bool {
+	for name, valuesA := range a {
+		if valuesB, found := b[name]; found && !listsOverlap(valuesA, valuesB) {
+			return false
+		}
+	}
+	return true
+}
+
+// headersCover returns true if all requests matching the headers of b also match the headers of a.
+func headersCover(a, b map[string][]string) bool {
+	for name, valuesA := range a {
+		valuesB, found := b[name]
+		if !found || !listsCover(valuesA, valuesB) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+// compareRoutes returns the conflict between 2 routes, or nil if there is none.
+func compareRoutes(a, b *Route) *Conflict {
+	if !listsOverlap(a.Methods, b.Methods) || !hostsOverlap(a.Hosts, b.Hosts) ||
+		!headersOverlap(a.Headers, b.Headers) {
+		return nil
+	}
+
+	// for each path, check which route goes first for the requests they both match
+	coveredA := make([]bool, len(a.paths)) // path of a that is matched by b first, for all requests
+	coveredB := make([]bool, len(b.paths))
+	for i, pathA := range a.paths {
+		for j, pathB := range b.paths {
+			if !pathA.overlaps(pathB) {
+				continue
+			}
+			switch precedence := comparePrecedence(a, pathA, b, pathB); {
+			case precedence == 0:
+				return &Conflict{Type: ConflictAmbiguous, Route: a, Other: b}
+			case precedence > 0:
+				coveredB[j] = coveredB[j] || pathA.covers(pathB)
+			default:
+				coveredA[i] = coveredA[i] || pathB.covers(pathA)
+			}
+		}
+	}
+
+	allCovered := func(covered []bool) bool {
+		for _, c := range covered {
+			if !c {
+				return false
+			}
+		}
+		return true
+	}
+	if allCovered(coveredB) && listsCover(a.Methods, b.Methods) && hostsCover(a.Hosts, b.Hosts) &&
+		headersCover(a.Headers, b.Headers) {
+		return &Conflict{Type: ConflictShadowed, Route: b, Other: a}
+	}
+	if allCovered(coveredA) && listsCover(b.Methods, a.Methods) && hostsCover(b.Hosts, a.Hosts) &&
+		headersCover(b.Headers, a.Headers) {
+		return &Conflict{Type: ConflictShadowed, Route: a, Other: b}
+	}
+
+	logbasics.Debug("routes overlap, resolved by precedence", "route", a.Name, "other", b.Name)
+	return nil
+}
+
+// Analyze checks the routes in a deck file for conflicts; routes that are shadowed by other
+// routes, or routes that are ambiguous with other routes. Routes of all services are compared,
+// since routes are global in Kong. Routes using expressions, or unsupported regex paths, are
+// skipped. The deckfile must be plain JSON data, as parsed from a file. The origins map route
+// names to where they were generated from; if omitted, the origin of a route is derived from
+// its methods and paths.
+func Analyze(deckfile map[string]interface{}, origins map[string]Origin) ([]Conflict, error) {
+	if deckfile == nil {
+		panic("expected 'deckfile' to be non-nil")
+	}
+
+	routes, err := getRoutes(deckfile, origins)
+	if err != nil {
+		return nil, err
+	}
+	// sort for a stable result
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Service != routes[j].Service {
+			return routes[i].Service < routes[j].Service
+		}
+		return routes[i].Name < routes[j].Name
+	})
+
+	conflicts := make([]Conflict, 0)
+	for i, route := range routes {
+		for _, other := range routes[i+1:] {
+			if conflict := compareRoutes(route, other); conflict != nil {
+				conflicts = append(conflicts, *conflict)
+			}
+		}
+	}
+	return conflicts, nil
+}
@@ -0,0 +1,13 @@
+package routeconflicts_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRouteConflicts(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RouteConflicts Suite")
+}
@@ -0,0 +1,235 @@
+package routeconflicts_test
+
+import (
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/routeconflicts"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func toDeckfile(data string) map[string]interface{} {
+	return filebasics.MustDeserialize([]byte(data))
+}
+
+// analyze returns the conflicts as strings, for easy comparison
+func analyze(data string, origins map[string]routeconflicts.Origin) []string {
+	conflicts, err := routeconflicts.Analyze(toDeckfile(data), origins)
+	Expect(err).ToNot(HaveOccurred())
+	result := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		result[i] = conflict.String()
+	}
+	return result
+}
+
+var _ = Describe("RouteConflicts", func() {
+	Describe("Analyze", func() {
+		It("accepts a plain path taking precedence over a templated path", func() {
+			Expect(analyze(`
+services:
+- name: pets
+  routes:
+  - name: get-pet
+    methods: [GET]
+    paths: ['~/pets/(?<id>[^#?/]+)$']
+    regex_priority: 100
+  - name: my-pets
+    methods: [GET]
+    paths: ['~/pets/mine$']
+    regex_priority: 200
+`, nil)).To(BeEmpty())
+		})
+
+		It("reports a templated path shadowing a plain path", func() {
+			Expect(analyze(`
+services:
+- name: pets
+  routes:
+  - name: get-pet
+    methods: [GET]
+    paths: ['~/pets/(?<id>[^#?/]+)$']
+    regex_priority: 200
+  - name: my-pets
+    methods: [GET]
+    paths: ['~/pets/mine$']
+    regex_priority: 100
+`, nil)).To(Equal([]string{
+				"route 'my-pets' of service 'pets' (GET /pets/mine) is shadowed by " +
+					"route 'get-pet' of service 'pets' (GET /pets/{id})",
+			}))
+		})
+
+		It("reports identical routes across services as ambiguous", func() {
+			Expect(analyze(`
+services:
+- name: users-v1
+  routes:
+  - name: list-users
+    methods: [GET]
+    paths: ['~/v1/users$']
+- name: accounts
+  routes:
+  - name: all-users
+    methods: [GET, POST]
+    paths: ['~/v1/users$']
+`, map[string]routeconflicts.Origin{
+				"list-users": {Method: "GET", Path: "/v1/users", OperationID: "listUsers"},
+			})).To(Equal([]string{
+				"route 'all-users' of service 'accounts' (GET|POST /v1/users) is ambiguous with " +
+					"route 'list-users' of service 'users-v1' (GET /v1/users, operation 'listUsers')",
+			}))
+		})
+
+		It("includes top-level routes", func() {
+			Expect(analyze(`
+services:
+- name: svc
+  routes:
+  - name: nested
+    paths: ['/api']
+routes:
+- name: top-level
+  service:
+    name: other
+  paths: ['/api']
+`, nil)).To(Equal([]string{
+				"route 'top-level' of service 'other' (/api) is ambiguous with " +
+					"route 'nested' of service 'svc' (/api)",
+			}))
+		})
+
+		It("does not report routes that cannot match the same request", func() {
+			Expect(analyze(`
+services:
+- name: svc
+  routes:
+  - name: get
+    methods: [GET]
+    paths: ['~/pets$']
+  - name: post
+    methods: [POST]
+    paths: ['~/pets$']
+  - name: other-host
+    methods: [GET]
+    hosts: [api.example.com]
+    paths: ['~/pets$']
+  - name: other-path
+    methods: [GET]
+    paths: ['~/pets/(?<id>[^#?/]+)$']
+  - name: header-v1
+    headers:
+      x-version: [v1]
+    paths: ['~/pets$']
+  - name: header-v2
+    headers:
+      x-version: [v2]
+    paths: ['~/pets$']
+`, nil)).To(BeEmpty())
+		})
+
+		It("compares hosts with wildcards", func() {
+			Expect(analyze(`
+services:
+- name: svc
+  routes:
+  - name: wildcard
+    hosts: ['*.example.com']
+    paths: ['~/pets$']
+  - name: specific
+    hosts: [api.example.com]
+    paths: ['~/pets$']
+`, nil)).To(Equal([]string{
+				"route 'specific' of service 'svc' (/pets) is ambiguous with " +
+					"route 'wildcard' of service 'svc' (/pets)",
+			}))
+		})
+
+		It("reports a prefix path shadowing a longer regex path", func() {
+			Expect(analyze(`
+services:
+- name: svc
+  routes:
+  - name: prefix
+    paths: ['/pets']
+  - name: regex
+    paths: ['~/pets/(?<id>[^#?/]+)$']
+`, nil)).To(Equal([]string{
+				"route 'regex' of service 'svc' (/pets/{id}) is shadowed by " +
+					"route 'prefix' of service 'svc' (/pets)",
+			}))
+		})
+
+		It("does not report a header route over its fallback route", func() {
+			Expect(analyze(`
+services:
+- name: svc
+  routes:
+  - name: op_0
+    methods: [GET]
+    headers:
+      X-Version: [v1, v2]
+    paths: ['~/pets$']
+  - name: op
+    methods: [GET]
+    paths: ['~/pets$']
+`, nil)).To(BeEmpty())
+		})
+
+		It("reports a regex with a higher regex_priority shadowing a more specific regex", func() {
+			Expect(analyze(`
+services:
+- name: svc
+  routes:
+  - name: any
+    methods: [GET]
+    paths: ['~/files/.*']
+    regex_priority: 10
+  - name: json
+    methods: [GET]
+    paths: ['~/files/(?<name>[^#?/]+)\.json$']
+`, nil)).To(Equal([]string{
+				"route 'json' of service 'svc' (GET /files/{name}.json) is shadowed by " +
+					"route 'any' of service 'svc' (GET /files/*)",
+			}))
+		})
+
+		It("gives longer regex paths precedence", func() {
+			Expect(analyze(`
+services:
+- name: svc
+  routes:
+  - name: any
+    methods: [GET]
+    paths: ['~/files/.*']
+  - name: json
+    methods: [GET]
+    paths: ['~/files/(?<name>[^#?/]+)\.json$']
+`, nil)).To(BeEmpty())
+		})
+
+		It("skips expression routes and unsupported regexes", func() {
+			Expect(analyze(`
+services:
+- name: svc
+  routes:
+  - name: expression
+    expression: http.path == "/pets"
+  - name: alternatives
+    paths: ['~/(pets|animals)$']
+  - name: pets
+    paths: ['~/pets$']
+`, nil)).To(BeEmpty())
+		})
+
+		It("returns an error on bad data", func() {
+			_, err := routeconflicts.Analyze(toDeckfile(`
+services:
+- name: svc
+  routes:
+  - name: bad
+    methods: GET
+`), nil)
+			Expect(err).To(MatchError(ContainSubstring("failed to parse 'methods' of route 'bad'")))
+		})
+	})
+})